@@ -2,7 +2,18 @@ package chunk
 
 // Chunk represents a chunk of data
 type Chunk struct {
-	Name     string `json:"name"`
-	Complete bool   `json:"complete"`
+	Name      string    `json:"name"`
+	Complete  bool      `json:"complete"`
+	Size      uint64    `json:"size"`
+	Owner     string    `json:"owner,omitempty"`
+	OwnerAddr string    `json:"ownerAddr,omitempty"`
+	Replicas  []Replica `json:"replicas,omitempty"`
+}
+
+// Replica represents a copy of a chunk held by an instance other than its owner
+type Replica struct {
+	Instance string `json:"instance"`
+	Addr     string `json:"addr"`
 	Size     uint64 `json:"size"`
+	Complete bool   `json:"complete"`
 }
@@ -2,35 +2,206 @@ package client
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/Vignesh-Rajarajan/event-bus/chunk"
+	"github.com/Vignesh-Rajarajan/event-bus/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"net/http"
 	"net/url"
 	"strconv"
+	"sync"
+	"time"
 )
 
 type Client struct {
-	addr      string
-	httpCli   http.Client
+	addr        string
+	httpCli     http.Client
+	clusterView bool
+	consumer    string
+	tlsConfig   *tls.Config
+	token       string
+	maxBackoff  time.Duration
+	// patternRefresh is how often the categories matching a pattern are listed again
+	patternRefresh time.Duration
+
+	mu            sync.Mutex
+	positions     map[string]*position
+	subscriptions map[string]*subscription
+}
+
+// position is how far the client has consumed a category
+type position struct {
 	offset    uint64
 	currChunk chunk.Chunk
 }
 
+// position returns the position of the client in the category, starting from the oldest chunk
+func (c *Client) position(category string) *position {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.positions == nil {
+		c.positions = make(map[string]*position)
+	}
+	p, ok := c.positions[category]
+	if !ok {
+		p = &position{}
+		c.positions[category] = p
+	}
+	return p
+}
+
+// defaultMaxBackoff is how long the client waits at most for a quota of the server by default
+const defaultMaxBackoff = 30 * time.Second
+
+// Option configures optional behaviour of the client
+type Option func(*Client)
+
+// WithClusterView makes the client consume the chunks stored on every instance of the cluster
+// instead of only the ones stored on the instance it is connected to
+func WithClusterView() Option {
+	return func(c *Client) {
+		c.clusterView = true
+	}
+}
+
+// WithConsumerName commits the position of the client under the given name after every processed batch,
+// so that the server can report how far behind the consumer is
+func WithConsumerName(name string) Option {
+	return func(c *Client) {
+		c.consumer = name
+	}
+}
+
+// WithTLS connects to the instances over TLS, the configuration carries the client certificate
+// when the instances require one
+func WithTLS(cfg *tls.Config) Option {
+	return func(c *Client) {
+		c.tlsConfig = cfg
+	}
+}
+
+// WithToken authenticates every request with the bearer token
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// tokenTransport adds the bearer token to the requests
+type tokenTransport struct {
+	base  http.RoundTripper
+	token string
+}
+
+func (t tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.base.RoundTrip(req)
+}
+
+// WithMaxBackoff bounds how long the client waits for the server when a quota is exceeded or the
+// server is short of disk space before it gives up and returns the error, zero disables the retries
+func WithMaxBackoff(d time.Duration) Option {
+	return func(c *Client) {
+		c.maxBackoff = d
+	}
+}
+
+// backoffTransport retries the requests the server asked to retry later, either because of a quota
+// or with a Retry-After header on a 503, once the server lets them through
+type backoffTransport struct {
+	base    http.RoundTripper
+	maxWait time.Duration
+}
+
+func (t backoffTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var waited time.Duration
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if err != nil || !retryable(resp) {
+			return resp, err
+		}
+		wait := retryAfter(resp, attempt)
+		if waited+wait > t.maxWait || (req.Body != nil && req.GetBody == nil) {
+			return resp, nil
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+		waited += wait
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+func retryable(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusTooManyRequests ||
+		(resp.StatusCode == http.StatusServiceUnavailable && resp.Header.Get("Retry-After") != "")
+}
+
+// retryAfter returns the delay asked for by the server, or an exponential backoff when it did not ask for one
+func retryAfter(resp *http.Response, attempt int) time.Duration {
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return min(100*time.Millisecond<<attempt, 5*time.Second)
+}
+
 var errRetry = errors.New("retry the request")
 
 // NewClient creates a new client
-func NewClient(addr string) *Client {
-	return &Client{addr: addr, httpCli: http.Client{}}
+func NewClient(addr string, opts ...Option) *Client {
+	c := &Client{addr: addr, httpCli: http.Client{}, maxBackoff: defaultMaxBackoff, patternRefresh: defaultPatternRefresh}
+	for _, opt := range opts {
+		opt(c)
+	}
+	var transport http.RoundTripper = http.DefaultTransport
+	if c.tlsConfig != nil {
+		transport = &http.Transport{TLSClientConfig: c.tlsConfig}
+	}
+	if c.token != "" {
+		transport = tokenTransport{base: transport, token: c.token}
+	}
+	if c.maxBackoff > 0 {
+		transport = backoffTransport{base: transport, maxWait: c.maxBackoff}
+	}
+	c.httpCli.Transport = transport
+	return c
 }
 
-// Send sends messages to the server
-func (c *Client) Send(category string, messages []byte) error {
+// Send sends messages to the server, the trace context of the write is handed over to the consumers of the messages
+func (c *Client) Send(category string, messages []byte) (err error) {
+	ctx, span := tracing.Tracer().Start(context.Background(), "EventBus.Send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("category", category), attribute.Int("bytes", len(messages))))
+	defer func() { tracing.End(span, err) }()
+
 	u := url.Values{}
 	u.Add("category", category)
-	resp, err := c.httpCli.Post(fmt.Sprintf("%s/write?%s", c.addr, u.Encode()), "application/octet-stream", bytes.NewReader(messages))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/write?%s", c.addr, u.Encode()), bytes.NewReader(messages))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	tracing.Propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := c.httpCli.Do(req)
 	if err != nil {
 		return err
 	}
@@ -64,70 +235,216 @@ func (c *Client) Process(category string, temp []byte, processFn func([]byte) er
 }
 
 func (c *Client) process(category string, temp []byte, processFn func([]byte) error) error {
+	p := c.position(category)
 
 	if err := c.updateCurrChunk(category); err != nil {
-		return fmt.Errorf("error while updating current chunk %v, err %w", c.currChunk.Name, err)
+		return fmt.Errorf("error while updating current chunk %v, err %w", p.currChunk.Name, err)
 	}
 
-	u := url.Values{}
-	u.Add("category", category)
-	u.Add("offset", strconv.Itoa(int(c.offset)))
-	u.Add("chunk", c.currChunk.Name)
-	u.Add("maxSize", strconv.Itoa(len(temp)))
-	resp, err := c.httpCli.Get(fmt.Sprintf("%s/read?%s", c.addr, u.Encode()))
-	if err != nil {
-		return fmt.Errorf("error while reading %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		var b bytes.Buffer
-		_, _ = io.Copy(&b, resp.Body)
-		return fmt.Errorf("process: status code:: %d - error::%s ", resp.StatusCode, b.String())
-	}
-
-	b := bytes.NewBuffer(temp[0:0])
-	_, err = io.Copy(b, resp.Body)
-
+	b, producers, err := c.read(category, p, temp)
 	if err != nil {
-		return fmt.Errorf("error while copying resp %v", err)
+		return err
 	}
 
 	if b.Len() == 0 {
-		if !c.currChunk.Complete {
+		if !p.currChunk.Complete {
 			if err := c.updateCurrChunkCompleteStatus(category); err != nil {
 				return fmt.Errorf("error while updating current chunk complete status %v", err)
 			}
-			if !c.currChunk.Complete {
-				if c.offset >= c.currChunk.Size {
+			if !p.currChunk.Complete {
+				if p.offset >= p.currChunk.Size {
 					return io.EOF
 				}
 				return errRetry
 			}
 		}
-		if c.offset < c.currChunk.Size {
+		if p.offset < p.currChunk.Size {
 			return errRetry
 		}
 		if err := c.Ack(category, c.addr); err != nil {
 			return fmt.Errorf("error while acking %v", err)
 		}
-		c.currChunk = chunk.Chunk{}
-		c.offset = 0
+		p.currChunk = chunk.Chunk{}
+		p.offset = 0
 		return errRetry
 	}
-	err = processFn(b.Bytes())
-	if err == nil {
-		c.offset += uint64(b.Len())
+	if err := c.processBatch(category, b.Bytes(), producers, processFn); err != nil {
+		return err
+	}
+	p.offset += uint64(b.Len())
+	if c.consumer != "" {
+		if err := c.Commit(category); err != nil {
+			return fmt.Errorf("error while committing %v", err)
+		}
+	}
+	return nil
+}
+
+// processBatch runs processFn in a consumer span linked to the writes which produced the messages
+func (c *Client) processBatch(category string, batch []byte, producers []trace.Link, processFn func([]byte) error) (err error) {
+	p := c.position(category)
+	_, span := tracing.Tracer().Start(context.Background(), "EventBus.Process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(producers...),
+		trace.WithAttributes(
+			attribute.String("category", category),
+			attribute.String("chunk", p.currChunk.Name),
+			attribute.Int64("offset", int64(p.offset)),
+			attribute.Int("bytes", len(batch)),
+		))
+	defer func() { tracing.End(span, err) }()
+	return processFn(batch)
+}
+
+// Commit records the position of the consumer in the current chunk
+func (c *Client) Commit(category string) error {
+	p := c.position(category)
+	u := url.Values{}
+	u.Add("category", category)
+	u.Add("consumer", c.consumer)
+	u.Add("chunk", p.currChunk.Name)
+	u.Add("offset", strconv.FormatUint(p.offset, 10))
+	resp, err := c.httpCli.Post(fmt.Sprintf("%s/commit?%s", c.addr, u.Encode()), "application/octet-stream", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var b bytes.Buffer
+		_, _ = io.Copy(&b, resp.Body)
+		return fmt.Errorf("status code:: %d - error::%s ", resp.StatusCode, b.String())
 	}
-	return err
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// read reads the chunk of the position from its owner, falling back to the replicas when the owner is unavailable
+func (c *Client) read(category string, p *position, temp []byte) (*bytes.Buffer, []trace.Link, error) {
+	var firstErr error
+	for _, loc := range c.chunkLocations(p) {
+		maxSize := uint64(len(temp))
+		if loc.limit > 0 {
+			// never read beyond what has been replicated, the rest of the chunk might not be there yet
+			if p.offset >= loc.limit {
+				continue
+			}
+			if loc.limit-p.offset < maxSize {
+				maxSize = loc.limit - p.offset
+			}
+		}
+		b, producers, err := c.readFrom(loc.addr, category, p, temp[:maxSize])
+		if err == nil {
+			return b, producers, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		return bytes.NewBuffer(temp[0:0]), nil, nil
+	}
+	return nil, nil, firstErr
+}
+
+type chunkLocation struct {
+	addr  string
+	limit uint64
+}
+
+func (c *Client) chunkLocations(p *position) []chunkLocation {
+	locations := []chunkLocation{{addr: c.addr}}
+	if p.currChunk.OwnerAddr != "" {
+		locations[0].addr = c.peerAddr(p.currChunk.OwnerAddr)
+	}
+	for _, r := range p.currChunk.Replicas {
+		if r.Addr == "" {
+			continue
+		}
+		locations = append(locations, chunkLocation{addr: c.peerAddr(r.Addr), limit: r.Size})
+	}
+	return locations
+}
+
+// peerAddr turns the address a peer has registered with into a url using the same scheme as the client
+func (c *Client) peerAddr(addr string) string {
+	u, err := url.Parse(c.addr)
+	if err != nil || u.Scheme == "" {
+		return "http://" + addr
+	}
+	return u.Scheme + "://" + addr
+}
+
+// readFrom reads the next batch from the instance, together with links to the writes which produced it
+func (c *Client) readFrom(addr, category string, p *position, temp []byte) (b *bytes.Buffer, producers []trace.Link, err error) {
+	ctx, span := tracing.Tracer().Start(context.Background(), "EventBus.Read",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("category", category),
+			attribute.String("chunk", p.currChunk.Name),
+			attribute.Int64("offset", int64(p.offset)),
+		))
+	defer func() { tracing.End(span, err) }()
+
+	u := url.Values{}
+	u.Add("category", category)
+	u.Add("offset", strconv.Itoa(int(p.offset)))
+	u.Add("chunk", p.currChunk.Name)
+	u.Add("maxSize", strconv.Itoa(len(temp)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/read?%s", addr, u.Encode()), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	tracing.Propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := c.httpCli.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error while reading %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var b bytes.Buffer
+		_, _ = io.Copy(&b, resp.Body)
+		return nil, nil, fmt.Errorf("process: status code:: %d - error::%s ", resp.StatusCode, b.String())
+	}
+
+	b = bytes.NewBuffer(temp[0:0])
+	if _, err := io.Copy(b, resp.Body); err != nil {
+		return nil, nil, fmt.Errorf("error while copying resp %v", err)
+	}
+	return b, tracing.ParseProducers(resp.Header.Get(tracing.ProducersHeader)), nil
+}
+
+// ReadChunk reads the messages of the chunk stored from the offset, at most len(temp) bytes, without
+// changing the position of the client. The chunk comes from a listing so that its replicas are read
+// when its owner is unavailable.
+func (c *Client) ReadChunk(category string, ch chunk.Chunk, offset uint64, temp []byte) ([]byte, error) {
+	b, _, err := c.read(category, &position{offset: offset, currChunk: ch}, temp)
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
 }
 
 // Ack acks the current chunk
 func (c *Client) Ack(category string, addr string) error {
+	p := c.position(category)
+	return c.ackChunk(addr, category, p.currChunk.Name, p.offset)
+}
+
+// AckChunk acks the chunk once its first size bytes have been processed, the chunk must be complete
+// and no larger than size
+func (c *Client) AckChunk(category, chunk string, size uint64) error {
+	return c.ackChunk(c.addr, category, chunk, size)
+}
+
+func (c *Client) ackChunk(addr, category, chunk string, size uint64) error {
 	u := url.Values{}
 	u.Add("category", category)
-	u.Add("chunk", c.currChunk.Name)
-	u.Add("size", strconv.Itoa(int(c.offset)))
+	u.Add("chunk", chunk)
+	u.Add("size", strconv.FormatUint(size, 10))
+	if c.clusterView {
+		u.Add("scope", "cluster")
+	}
 	resp, err := c.httpCli.Get(fmt.Sprintf("%s/ack?%s", addr, u.Encode()))
 	if err != nil {
 		return err
@@ -143,7 +460,8 @@ func (c *Client) Ack(category string, addr string) error {
 }
 
 func (c *Client) updateCurrChunk(category string) error {
-	if c.currChunk.Name != "" {
+	p := c.position(category)
+	if p.currChunk.Name != "" {
 		return nil
 	}
 	chunks, err := c.ListChunks(category)
@@ -153,7 +471,7 @@ func (c *Client) updateCurrChunk(category string) error {
 	if len(chunks) == 0 {
 		return io.EOF
 	}
-	c.currChunk = chunks[0]
+	p.currChunk = chunks[0]
 	return nil
 }
 
@@ -161,6 +479,9 @@ func (c *Client) updateCurrChunk(category string) error {
 func (c *Client) ListChunks(category string) ([]chunk.Chunk, error) {
 	u := url.Values{}
 	u.Add("category", category)
+	if c.clusterView {
+		u.Add("scope", "cluster")
+	}
 	resp, err := c.httpCli.Get(fmt.Sprintf("%s/listChunks?%s", c.addr, u.Encode()))
 	if err != nil {
 		return nil, err
@@ -180,13 +501,14 @@ func (c *Client) ListChunks(category string) ([]chunk.Chunk, error) {
 }
 
 func (c *Client) updateCurrChunkCompleteStatus(category string) error {
+	p := c.position(category)
 	chunks, err := c.ListChunks(category)
 	if err != nil {
 		return fmt.Errorf("error while listing chunks %v", err)
 	}
 	for _, ch := range chunks {
-		if ch.Name == c.currChunk.Name {
-			c.currChunk = ch
+		if ch.Name == p.currChunk.Name {
+			p.currChunk = ch
 			return nil
 		}
 	}
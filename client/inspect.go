@@ -0,0 +1,83 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/Vignesh-Rajarajan/event-bus/diskspace"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ConsumerLag is how far behind the head of a category a consumer is
+type ConsumerLag struct {
+	Category    string    `json:"category"`
+	Consumer    string    `json:"consumer"`
+	Chunk       string    `json:"chunk"`
+	Offset      uint64    `json:"offset"`
+	CommittedAt time.Time `json:"committedAt"`
+	Bytes       uint64    `json:"lagBytes"`
+	Messages    uint64    `json:"lagMessages"`
+	// Seconds is the age of the oldest chunk the consumer has not fully processed
+	Seconds float64 `json:"lagSeconds"`
+}
+
+// InstanceStatus describes an instance of the cluster
+type InstanceStatus struct {
+	Instance      string           `json:"instance"`
+	Cluster       string           `json:"cluster,omitempty"`
+	StartedAt     time.Time        `json:"startedAt"`
+	UptimeSeconds float64          `json:"uptimeSeconds"`
+	Draining      bool             `json:"draining"`
+	Categories    []string         `json:"categories"`
+	Disk          *diskspace.Usage `json:"disk,omitempty"`
+}
+
+// PeerStatus is a peer of the cluster with the status it reports, Error tells why it could not be reached
+type PeerStatus struct {
+	Name   string          `json:"name"`
+	Addr   string          `json:"addr"`
+	Zone   string          `json:"zone,omitempty"`
+	Status *InstanceStatus `json:"status,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Lag returns how far behind every consumer of the category is, or the given consumer only
+func (c *Client) Lag(category, consumer string) ([]ConsumerLag, error) {
+	u := url.Values{}
+	u.Add("category", category)
+	if consumer != "" {
+		u.Add("consumer", consumer)
+	}
+	var lags []ConsumerLag
+	if err := c.getJSON("/lag", u, &lags); err != nil {
+		return nil, err
+	}
+	return lags, nil
+}
+
+// Peers lists the instances of the cluster with their status
+func (c *Client) Peers() ([]PeerStatus, error) {
+	var peers []PeerStatus
+	if err := c.getJSON("/peers", url.Values{}, &peers); err != nil {
+		return nil, err
+	}
+	return peers, nil
+}
+
+// getJSON decodes the response of the endpoint into v
+func (c *Client) getJSON(path string, u url.Values, v any) error {
+	resp, err := c.httpCli.Get(fmt.Sprintf("%s%s?%s", c.addr, path, u.Encode()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var b bytes.Buffer
+		_, _ = io.Copy(&b, resp.Body)
+		return fmt.Errorf("status code:: %d - error::%s ", resp.StatusCode, b.String())
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,122 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"time"
+)
+
+// defaultPatternRefresh is how often the categories matching a pattern are listed again by default
+const defaultPatternRefresh = 5 * time.Second
+
+// WithPatternRefresh sets how often ProcessPattern lists the categories matching its pattern again,
+// the categories created in the meantime are consumed after at most that long
+func WithPatternRefresh(d time.Duration) Option {
+	return func(c *Client) {
+		c.patternRefresh = d
+	}
+}
+
+// subscription is the consumption of the categories matching a pattern
+type subscription struct {
+	categories []string
+	listed     time.Time
+	// next is the index of the category to consume first at the next call
+	next int
+}
+
+// Categories lists the categories the client may consume, only the ones matching the glob pattern
+// when it is not empty
+func (c *Client) Categories(pattern string) ([]string, error) {
+	u := url.Values{}
+	if pattern != "" {
+		u.Add("pattern", pattern)
+	}
+	if c.clusterView {
+		u.Add("scope", "cluster")
+	}
+	var categories []string
+	if err := c.getJSON("/categories", u, &categories); err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+// ProcessPattern receives messages from every category matching the glob pattern, such as orders.*,
+// and hands them to processFn together with their category. The categories are consumed in turn,
+// each from its own position, and are listed again periodically so that the ones created later are
+// picked up. Like Process it returns once a batch has been processed, and io.EOF when none of the
+// categories has new messages. A pattern must be consumed by a single goroutine.
+func (c *Client) ProcessPattern(pattern string, temp []byte, processFn func(category string, batch []byte) error) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %s %v", pattern, err)
+	}
+	if temp == nil {
+		temp = make([]byte, 1024*1024)
+	}
+	sub, err := c.subscription(pattern)
+	if err != nil {
+		return err
+	}
+	for i := range sub.categories {
+		idx := (sub.next + i) % len(sub.categories)
+		category := sub.categories[idx]
+		err := c.Process(category, temp, func(batch []byte) error {
+			return processFn(category, batch)
+		})
+		if errors.Is(err, io.EOF) {
+			continue
+		}
+		if err != nil {
+			// the category is tried first again at the next call
+			sub.next = idx
+			return fmt.Errorf("error processing category %s %w", category, err)
+		}
+		// a busy category must not starve the others
+		sub.next = idx + 1
+		return nil
+	}
+	return io.EOF
+}
+
+// subscription returns the subscription to the pattern, listing the matching categories when they
+// have not been listed for a while. The positions in the categories which no longer match are forgotten.
+func (c *Client) subscription(pattern string) (*subscription, error) {
+	c.mu.Lock()
+	if c.subscriptions == nil {
+		c.subscriptions = make(map[string]*subscription)
+	}
+	sub, ok := c.subscriptions[pattern]
+	if !ok {
+		sub = &subscription{}
+		c.subscriptions[pattern] = sub
+	}
+	c.mu.Unlock()
+	if ok && time.Since(sub.listed) < c.patternRefresh {
+		return sub, nil
+	}
+
+	categories, err := c.Categories(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("error listing categories matching %s %v", pattern, err)
+	}
+	matching := make(map[string]bool, len(categories))
+	for _, category := range categories {
+		matching[category] = true
+	}
+	c.mu.Lock()
+	for _, category := range sub.categories {
+		if !matching[category] {
+			delete(c.positions, category)
+		}
+	}
+	c.mu.Unlock()
+	sub.categories, sub.listed = categories, time.Now()
+	if len(categories) > 0 {
+		sub.next %= len(categories)
+	}
+	return sub, nil
+}
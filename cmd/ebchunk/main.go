@@ -0,0 +1,224 @@
+// Command ebchunk inspects, verifies and repairs the chunk files of a category directory offline,
+// the instance owning the directory must be stopped while they are repaired.
+//
+//	ebchunk verify [-json] <category directory or chunk file>...
+//	ebchunk dump [-from <record>] [-count <records>] [-offsets] <chunk file>
+//	ebchunk repair [-dry-run] <chunk file>...
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/Vignesh-Rajarajan/event-bus/manager"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var (
+	errUsage = errors.New("invalid usage")
+	// errProblems is returned when chunks have problems, they have already been reported
+	errProblems = errors.New("problems found")
+)
+
+func main() {
+	err := run(os.Args[1:], os.Stdout, os.Stderr)
+	switch {
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	case errors.Is(err, errProblems):
+		os.Exit(1)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "ebchunk: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage(w io.Writer) {
+	_, _ = fmt.Fprint(w, `usage:
+  ebchunk verify [-json] <category directory or chunk file>...
+  ebchunk dump [-from <record>] [-count <records>] [-offsets] <chunk file>
+  ebchunk repair [-dry-run] <chunk file>...
+`)
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		usage(stderr)
+		return errUsage
+	}
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { usage(stderr) }
+	switch args[0] {
+	case "verify":
+		asJSON := fs.Bool("json", false, "print the reports as JSON, one per line")
+		if err := parseArgs(fs, args[1:], 1); err != nil {
+			return err
+		}
+		return verify(fs.Args(), *asJSON, stdout)
+	case "dump":
+		from := fs.Uint64("from", 0, "index of the first record printed")
+		count := fs.Uint64("count", 0, "number of records printed, 0 prints them all")
+		offsets := fs.Bool("offsets", false, "prefix every record with its offset in the chunk")
+		if err := parseArgs(fs, args[1:], 1); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			usage(stderr)
+			return errUsage
+		}
+		return dump(fs.Arg(0), *from, *count, *offsets, stdout)
+	case "repair":
+		dryRun := fs.Bool("dry-run", false, "only report what would be truncated")
+		if err := parseArgs(fs, args[1:], 1); err != nil {
+			return err
+		}
+		return repair(fs.Args(), *dryRun, stdout)
+	default:
+		usage(stderr)
+		return errUsage
+	}
+}
+
+// parseArgs parses the flags of a subcommand which expects at least minArgs arguments
+func parseArgs(fs *flag.FlagSet, args []string, minArgs int) error {
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() < minArgs {
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+// chunkPaths expands the category directories into the chunk files they hold
+func chunkPaths(paths []string) ([]string, error) {
+	var chunks []string
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			chunks = append(chunks, path)
+			continue
+		}
+		names, err := manager.ChunkFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			chunks = append(chunks, filepath.Join(path, name))
+		}
+	}
+	return chunks, nil
+}
+
+func verify(paths []string, asJSON bool, w io.Writer) error {
+	chunks, err := chunkPaths(paths)
+	if err != nil {
+		return err
+	}
+	problems := 0
+	for _, path := range chunks {
+		report, err := manager.InspectChunk(path)
+		if err != nil {
+			return err
+		}
+		if !report.OK() {
+			problems++
+		}
+		if asJSON {
+			if err := json.NewEncoder(w).Encode(report); err != nil {
+				return err
+			}
+			continue
+		}
+		checksum := "no manifest"
+		if report.ChecksumValid != nil {
+			checksum = "checksum verified"
+			if !*report.ChecksumValid {
+				checksum = "checksum mismatch"
+			}
+		}
+		status := "ok"
+		if !report.OK() {
+			status = "FAILED"
+		}
+		_, _ = fmt.Fprintf(w, "%s: %s, %d records, %d bytes, %s\n", path, status, report.Records, report.Size, checksum)
+		for _, p := range report.Problems {
+			_, _ = fmt.Fprintf(w, "  %s\n", p)
+		}
+	}
+	if problems > 0 {
+		if !asJSON {
+			_, _ = fmt.Fprintf(w, "%d of %d chunks have problems\n", problems, len(chunks))
+		}
+		return errProblems
+	}
+	return nil
+}
+
+// errDumped stops the scan once the requested records have been printed
+var errDumped = errors.New("records dumped")
+
+func dump(path string, from, count uint64, offsets bool, w io.Writer) error {
+	fp, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	var idx uint64
+	_, err = manager.ScanRecords(fp, func(offset uint64, record []byte) error {
+		defer func() { idx++ }()
+		if idx < from {
+			return nil
+		}
+		if count > 0 && idx >= from+count {
+			return errDumped
+		}
+		if offsets {
+			if _, err := fmt.Fprintf(w, "%d\t", offset); err != nil {
+				return err
+			}
+		}
+		_, err := w.Write(record)
+		return err
+	})
+	if err != nil && !errors.Is(err, errDumped) {
+		return err
+	}
+	return nil
+}
+
+func repair(paths []string, dryRun bool, w io.Writer) error {
+	failed := false
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		size, err := manager.RepairChunk(path, dryRun)
+		switch {
+		case errors.Is(err, manager.ErrNotRepairable):
+			failed = true
+			_, _ = fmt.Fprintf(w, "%s: %v, restore it from a replica\n", path, err)
+		case err != nil:
+			return err
+		case size == uint64(fi.Size()):
+			_, _ = fmt.Fprintf(w, "%s: nothing to repair\n", path)
+		case dryRun:
+			_, _ = fmt.Fprintf(w, "%s: would truncate from %d to %d bytes\n", path, fi.Size(), size)
+		default:
+			_, _ = fmt.Fprintf(w, "%s: truncated from %d to %d bytes\n", path, fi.Size(), size)
+		}
+	}
+	if failed {
+		return errProblems
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEbchunk(t *testing.T) {
+	dir := t.TempDir()
+	chunk := filepath.Join(dir, "luffy-chunk000000000")
+	if err := os.WriteFile(chunk, []byte("1\n2\n3\n4"), 0666); err != nil {
+		t.Fatalf("error writing chunk %v", err)
+	}
+	ebchunk := func(args ...string) (string, error) {
+		t.Helper()
+		var stdout, stderr bytes.Buffer
+		err := run(args, &stdout, &stderr)
+		return stdout.String(), err
+	}
+
+	out, err := ebchunk("verify", dir)
+	if !errors.Is(err, errProblems) || !strings.Contains(out, "FAILED, 3 records, 7 bytes, no manifest") {
+		t.Errorf("got verify %q err %v", out, err)
+	}
+	out, err = ebchunk("dump", "-from", "1", "-count", "1", "-offsets", chunk)
+	if err != nil || out != "2\t2\n" {
+		t.Errorf("got dump %q err %v", out, err)
+	}
+	out, err = ebchunk("repair", chunk)
+	if err != nil || !strings.Contains(out, "truncated from 7 to 6 bytes") {
+		t.Errorf("got repair %q err %v", out, err)
+	}
+	out, err = ebchunk("verify", chunk)
+	if err != nil || !strings.Contains(out, ": ok, 3 records, 6 bytes") {
+		t.Errorf("got verify %q err %v after the repair", out, err)
+	}
+	if _, err := ebchunk("dump"); !errors.Is(err, errUsage) {
+		t.Errorf("got err %v without a chunk", err)
+	}
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/Vignesh-Rajarajan/event-bus/chunk"
+	"github.com/Vignesh-Rajarajan/event-bus/client"
+	"github.com/Vignesh-Rajarajan/event-bus/manager"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultBatchSize = 1024 * 1024
+	defaultPoll      = 500 * time.Millisecond
+)
+
+type produceResult struct {
+	Category string `json:"category"`
+	Messages int    `json:"messages"`
+	Bytes    int    `json:"bytes"`
+}
+
+// produceCmd sends every line as a message, the lines are grouped into requests of at most -batch-size bytes
+func produceCmd(ctx context.Context, e *env, args []string) error {
+	fs := flag.NewFlagSet("produce", flag.ContinueOnError)
+	category := fs.String("category", "", "category the messages are sent to")
+	batchSize := fs.Int("batch-size", defaultBatchSize, "bytes sent at most per request, a longer line is sent on its own")
+	if err := parseFlags(e, fs, "-category <name> [file...]", args); err != nil {
+		return err
+	}
+	if err := required(fs, "category"); err != nil {
+		return err
+	}
+
+	inputs := []io.Reader{e.stdin}
+	if fs.NArg() > 0 {
+		inputs = inputs[:0]
+		for _, name := range fs.Args() {
+			fp, err := os.Open(name)
+			if err != nil {
+				return err
+			}
+			defer fp.Close()
+			inputs = append(inputs, fp)
+		}
+	}
+
+	c := e.client()
+	result := produceResult{Category: *category}
+	var batch []byte
+	pending := 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := c.Send(*category, batch); err != nil {
+			return fmt.Errorf("error sending messages after %d sent %v", result.Messages, err)
+		}
+		result.Messages += pending
+		result.Bytes += len(batch)
+		batch, pending = batch[:0], 0
+		return nil
+	}
+	for _, input := range inputs {
+		r := bufio.NewReader(input)
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			line, readErr := r.ReadBytes('\n')
+			if len(bytes.TrimSpace(line)) > 0 {
+				if line[len(line)-1] != '\n' {
+					line = append(line, '\n')
+				}
+				if len(batch)+len(line) > *batchSize {
+					if err := flush(); err != nil {
+						return err
+					}
+				}
+				batch = append(batch, line...)
+				pending++
+			}
+			if readErr == io.EOF {
+				break
+			}
+			if readErr != nil {
+				return readErr
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	return e.print(result, func(w io.Writer) {
+		_, _ = fmt.Fprintf(w, "sent %d messages (%d bytes) to %s\n", result.Messages, result.Bytes, result.Category)
+	})
+}
+
+type message struct {
+	Category string `json:"category"`
+	Message  string `json:"message"`
+}
+
+// printMessages writes every message of the batch on its own line, prefixed with the category
+// when several categories are consumed
+func (e *env) printMessages(category string, batch []byte, withCategory bool) error {
+	for _, msg := range strings.SplitAfter(string(batch), "\n") {
+		if msg == "" {
+			continue
+		}
+		var err error
+		switch {
+		case e.json:
+			err = e.print(message{Category: category, Message: strings.TrimSuffix(msg, "\n")}, nil)
+		case withCategory:
+			_, err = fmt.Fprintf(e.stdout, "%s\t%s", category, msg)
+		default:
+			_, err = io.WriteString(e.stdout, msg)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// consumeCmd processes the messages of a category, or of every category matching a pattern, and acks
+// the chunks once they are read. It stops when there is nothing left to read unless -follow is set.
+func consumeCmd(ctx context.Context, e *env, args []string) error {
+	fs := flag.NewFlagSet("consume", flag.ContinueOnError)
+	category := fs.String("category", "", "category to consume")
+	pattern := fs.String("pattern", "", "glob pattern of the categories to consume, e.g. orders.*, instead of -category")
+	consumer := fs.String("consumer", "", "name the position is committed under, so that the lag of the consumer can be reported")
+	follow := fs.Bool("follow", false, "wait for new messages instead of stopping once everything has been read")
+	poll := fs.Duration("poll", defaultPoll, "interval between reads while waiting for new messages")
+	maxSize := fs.Int("max-size", defaultBatchSize, "bytes read at most per request, it must hold the longest message")
+	if err := parseFlags(e, fs, "(-category <name> | -pattern <glob>) [-consumer <name>] [-follow]", args); err != nil {
+		return err
+	}
+	if (*category == "") == (*pattern == "") {
+		fs.Usage()
+		return errUsage
+	}
+
+	var opts []client.Option
+	if *consumer != "" {
+		opts = append(opts, client.WithConsumerName(*consumer))
+	}
+	c := e.client(opts...)
+	buf := make([]byte, *maxSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var err error
+		if *pattern != "" {
+			err = c.ProcessPattern(*pattern, buf, func(category string, batch []byte) error {
+				return e.printMessages(category, batch, true)
+			})
+		} else {
+			err = c.Process(*category, buf, func(batch []byte) error {
+				return e.printMessages(*category, batch, false)
+			})
+		}
+		if errors.Is(err, io.EOF) {
+			if !*follow {
+				return nil
+			}
+			if err := sleep(ctx, *poll); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// tailCmd follows the messages written into a category from its newest chunk, or from its oldest
+// one with -from-start, without acking anything
+func tailCmd(ctx context.Context, e *env, args []string) error {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	category := fs.String("category", "", "category to follow")
+	fromStart := fs.Bool("from-start", false, "print the messages of every chunk stored instead of only the new ones")
+	poll := fs.Duration("poll", defaultPoll, "interval between reads while waiting for new messages")
+	maxSize := fs.Int("max-size", defaultBatchSize, "bytes read at most per request, it must hold the longest message")
+	if err := parseFlags(e, fs, "-category <name> [-from-start]", args); err != nil {
+		return err
+	}
+	if err := required(fs, "category"); err != nil {
+		return err
+	}
+
+	c := e.client()
+	chunks, err := c.ListChunks(*category)
+	if err != nil {
+		return err
+	}
+	var curr chunk.Chunk
+	var offset uint64
+	// the index of the last chunk read from every instance
+	read := make(map[string]uint64)
+	if *fromStart {
+		curr, _ = chunkAfter(chunks, read)
+	} else if len(chunks) > 0 {
+		for _, ch := range chunks {
+			markRead(read, ch.Name)
+		}
+		curr = chunks[len(chunks)-1]
+		offset = curr.Size
+	}
+	markRead(read, curr.Name)
+	buf := make([]byte, *maxSize)
+	for {
+		var readErr error
+		if curr.Name != "" {
+			b, err := c.ReadChunk(*category, curr, offset, buf)
+			if err == nil && len(b) > 0 {
+				if err := e.printMessages(*category, b, false); err != nil {
+					return err
+				}
+				offset += uint64(len(b))
+				continue
+			}
+			readErr = err
+		}
+
+		// nothing new in the current chunk, move on to the next one once it has been read entirely
+		// or when it is gone because it was acked
+		chunks, err := c.ListChunks(*category)
+		if err != nil {
+			return err
+		}
+		listed, found := findChunk(chunks, curr.Name)
+		if found && readErr != nil {
+			return readErr
+		}
+		if found && (!listed.Complete || offset < listed.Size) {
+			curr = listed
+		} else if next, ok := chunkAfter(chunks, read); ok {
+			curr, offset = next, 0
+			markRead(read, curr.Name)
+			continue
+		}
+		if err := sleep(ctx, *poll); err != nil {
+			return err
+		}
+	}
+}
+
+func findChunk(chunks []chunk.Chunk, name string) (chunk.Chunk, bool) {
+	for _, ch := range chunks {
+		if ch.Name == name {
+			return ch, true
+		}
+	}
+	return chunk.Chunk{}, false
+}
+
+// chunkAfter returns the first chunk of the listing, which is sorted by name, coming after the last
+// chunk read from the same instance. The chunks of an instance are written in the order of their
+// index but the names of the chunks of different instances interleave.
+func chunkAfter(chunks []chunk.Chunk, read map[string]uint64) (chunk.Chunk, bool) {
+	for _, ch := range chunks {
+		owner, idx, ok := manager.ParseChunkName(ch.Name)
+		if !ok {
+			continue
+		}
+		if last, seen := read[owner]; !seen || idx > last {
+			return ch, true
+		}
+	}
+	return chunk.Chunk{}, false
+}
+
+// markRead records the chunk as the last one read from its instance unless a later one was
+func markRead(read map[string]uint64, name string) {
+	owner, idx, ok := manager.ParseChunkName(name)
+	if !ok {
+		return
+	}
+	if last, seen := read[owner]; !seen || idx > last {
+		read[owner] = idx
+	}
+}
+
+func categoriesCmd(ctx context.Context, e *env, args []string) error {
+	fs := flag.NewFlagSet("categories", flag.ContinueOnError)
+	pattern := fs.String("pattern", "", "glob pattern the categories must match, e.g. orders.*")
+	if err := parseFlags(e, fs, "[-pattern <glob>]", args); err != nil {
+		return err
+	}
+	categories, err := e.client().Categories(*pattern)
+	if err != nil {
+		return err
+	}
+	return e.print(categories, func(w io.Writer) {
+		for _, category := range categories {
+			_, _ = fmt.Fprintln(w, category)
+		}
+	})
+}
+
+func chunksCmd(ctx context.Context, e *env, args []string) error {
+	fs := flag.NewFlagSet("chunks", flag.ContinueOnError)
+	category := fs.String("category", "", "category whose chunks are listed")
+	if err := parseFlags(e, fs, "-category <name>", args); err != nil {
+		return err
+	}
+	if err := required(fs, "category"); err != nil {
+		return err
+	}
+	chunks, err := e.client().ListChunks(*category)
+	if err != nil {
+		return err
+	}
+	return e.print(chunks, func(w io.Writer) {
+		rows := make([][]string, 0, len(chunks))
+		for _, ch := range chunks {
+			var replicas []string
+			for _, r := range ch.Replicas {
+				replicas = append(replicas, fmt.Sprintf("%s:%d", r.Instance, r.Size))
+			}
+			rows = append(rows, []string{ch.Name, ch.Owner, strconv.FormatUint(ch.Size, 10), strconv.FormatBool(ch.Complete), strings.Join(replicas, ",")})
+		}
+		table(w, []string{"CHUNK", "OWNER", "SIZE", "COMPLETE", "REPLICAS"}, rows)
+	})
+}
+
+type ackResult struct {
+	Category string `json:"category"`
+	Chunk    string `json:"chunk"`
+	Size     uint64 `json:"size"`
+}
+
+func ackCmd(ctx context.Context, e *env, args []string) error {
+	fs := flag.NewFlagSet("ack", flag.ContinueOnError)
+	category := fs.String("category", "", "category of the chunk")
+	name := fs.String("chunk", "", "chunk to ack")
+	size := fs.Uint64("size", 0, "bytes of the chunk processed, defaults to the size of the chunk as listed")
+	if err := parseFlags(e, fs, "-category <name> -chunk <chunk> [-size <bytes>]", args); err != nil {
+		return err
+	}
+	if err := required(fs, "category", "chunk"); err != nil {
+		return err
+	}
+	c := e.client()
+	if *size == 0 {
+		chunks, err := c.ListChunks(*category)
+		if err != nil {
+			return err
+		}
+		ch, found := findChunk(chunks, *name)
+		if !found {
+			return fmt.Errorf("chunk %s not found in category %s", *name, *category)
+		}
+		*size = ch.Size
+	}
+	if err := c.AckChunk(*category, *name, *size); err != nil {
+		return err
+	}
+	result := ackResult{Category: *category, Chunk: *name, Size: *size}
+	return e.print(result, func(w io.Writer) {
+		_, _ = fmt.Fprintf(w, "acked chunk %s of %s (%d bytes)\n", result.Chunk, result.Category, result.Size)
+	})
+}
+
+func lagCmd(ctx context.Context, e *env, args []string) error {
+	fs := flag.NewFlagSet("lag", flag.ContinueOnError)
+	category := fs.String("category", "", "category whose consumers are reported")
+	consumer := fs.String("consumer", "", "only report the given consumer")
+	if err := parseFlags(e, fs, "-category <name> [-consumer <name>]", args); err != nil {
+		return err
+	}
+	if err := required(fs, "category"); err != nil {
+		return err
+	}
+	lags, err := e.client().Lag(*category, *consumer)
+	if err != nil {
+		return err
+	}
+	return e.print(lags, func(w io.Writer) {
+		rows := make([][]string, 0, len(lags))
+		for _, l := range lags {
+			rows = append(rows, []string{
+				l.Consumer, l.Chunk, strconv.FormatUint(l.Offset, 10), strconv.FormatUint(l.Bytes, 10),
+				strconv.FormatUint(l.Messages, 10), (time.Duration(l.Seconds) * time.Second).String(),
+				l.CommittedAt.Local().Format(time.DateTime),
+			})
+		}
+		table(w, []string{"CONSUMER", "CHUNK", "OFFSET", "LAG BYTES", "LAG MESSAGES", "LAG", "COMMITTED"}, rows)
+	})
+}
+
+func peersCmd(ctx context.Context, e *env, args []string) error {
+	fs := flag.NewFlagSet("peers", flag.ContinueOnError)
+	if err := parseFlags(e, fs, "", args); err != nil {
+		return err
+	}
+	peers, err := e.client().Peers()
+	if err != nil {
+		return err
+	}
+	return e.print(peers, func(w io.Writer) {
+		rows := make([][]string, 0, len(peers))
+		for _, p := range peers {
+			row := []string{p.Name, p.Addr, p.Zone, "unreachable", "", "", p.Error}
+			if s := p.Status; s != nil {
+				row[3] = "up"
+				if s.Draining {
+					row[3] = "draining"
+				}
+				row[4] = (time.Duration(s.UptimeSeconds) * time.Second).String()
+				row[5] = strconv.Itoa(len(s.Categories))
+			}
+			rows = append(rows, row)
+		}
+		table(w, []string{"NAME", "ADDR", "ZONE", "STATE", "UPTIME", "CATEGORIES", "ERROR"}, rows)
+	})
+}
+
+// sleep waits for d unless the context is done first
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
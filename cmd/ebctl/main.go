@@ -0,0 +1,187 @@
+// Command ebctl produces, consumes and inspects the events of the bus.
+//
+//	ebctl [flags] <command> [command flags] [arguments]
+//
+// The instance is given by -addr or $EBCTL_ADDR and the token by -token or $EVENT_BUS_TOKEN.
+// Every command prints a human-readable output by default and JSON with -output json.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/Vignesh-Rajarajan/event-bus/client"
+	"github.com/Vignesh-Rajarajan/event-bus/tlsconfig"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+)
+
+const defaultAddr = "http://127.0.0.1:8080"
+
+// errUsage is returned when the command line is invalid, the usage has already been printed
+var errUsage = errors.New("invalid usage")
+
+// command is a subcommand of ebctl, run parses its own flags from args
+type command struct {
+	summary string
+	run     func(ctx context.Context, env *env, args []string) error
+}
+
+var commands = map[string]command{
+	"produce":    {summary: "send the lines of stdin or of the given files to a category", run: produceCmd},
+	"consume":    {summary: "process the messages of a category or of a pattern, acking the chunks read", run: consumeCmd},
+	"tail":       {summary: "follow the new messages of a category without acking them", run: tailCmd},
+	"categories": {summary: "list the categories", run: categoriesCmd},
+	"chunks":     {summary: "list the chunks of a category", run: chunksCmd},
+	"ack":        {summary: "ack a complete chunk of a category", run: ackCmd},
+	"lag":        {summary: "show how far behind the consumers of a category are", run: lagCmd},
+	"peers":      {summary: "show the instances of the cluster and their status", run: peersCmd},
+}
+
+// env is what the commands share: the options of the client and where to print
+type env struct {
+	addr   string
+	opts   []client.Option
+	json   bool
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// client returns a client of the instance, with the extra options of the command
+func (e *env) client(opts ...client.Option) *client.Client {
+	return client.NewClient(e.addr, append(append([]client.Option{}, e.opts...), opts...)...)
+}
+
+// print writes v as JSON with -output json, otherwise it lets text write the human-readable form
+func (e *env) print(v any, text func(w io.Writer)) error {
+	if e.json {
+		return json.NewEncoder(e.stdout).Encode(v)
+	}
+	text(e.stdout)
+	return nil
+}
+
+// table writes the rows aligned in columns under the header
+func table(w io.Writer, header []string, rows [][]string) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		_, _ = fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	_ = tw.Flush()
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	stop()
+	switch {
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	case err != nil && !errors.Is(err, context.Canceled):
+		fmt.Fprintf(os.Stderr, "ebctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("ebctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	addr := fs.String("addr", envOr("EBCTL_ADDR", defaultAddr), "url of the instance, defaults to $EBCTL_ADDR")
+	token := fs.String("token", os.Getenv("EVENT_BUS_TOKEN"), "token authenticating the requests, defaults to $EVENT_BUS_TOKEN")
+	cluster := fs.Bool("cluster", false, "see the chunks stored on every instance instead of only the ones of -addr")
+	output := fs.String("output", "text", "output format: text or json")
+	tlsCA := fs.String("tls-ca", "", "CA verifying the certificate of the instance, defaults to the system roots")
+	tlsCert := fs.String("tls-cert", "", "client certificate presented to the instance")
+	tlsKey := fs.String("tls-key", "", "private key of -tls-cert")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(stderr, "usage: ebctl [flags] <command> [command flags] [arguments]\n\ncommands:\n")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			_, _ = fmt.Fprintf(stderr, "  %-11s %s\n", name, commands[name].summary)
+		}
+		_, _ = fmt.Fprintf(stderr, "\nflags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return errUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errUsage
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		_, _ = fmt.Fprintf(stderr, "unknown command %q\n", fs.Arg(0))
+		fs.Usage()
+		return errUsage
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+
+	e := &env{addr: strings.TrimRight(*addr, "/"), json: *output == "json", stdin: stdin, stdout: stdout, stderr: stderr}
+	if !strings.Contains(e.addr, "://") {
+		e.addr = "http://" + e.addr
+	}
+	if *token != "" {
+		e.opts = append(e.opts, client.WithToken(*token))
+	}
+	if *cluster {
+		e.opts = append(e.opts, client.WithClusterView())
+	}
+	if tlsCfg := (tlsconfig.Config{CertFile: *tlsCert, KeyFile: *tlsKey, CAFile: *tlsCA}); !tlsCfg.IsZero() {
+		cfg, err := tlsCfg.Client()
+		if err != nil {
+			return err
+		}
+		e.opts = append(e.opts, client.WithTLS(cfg))
+	}
+	return cmd.run(ctx, e, fs.Args()[1:])
+}
+
+// parseFlags parses the flags of a command, the usage lists them after the synopsis
+func parseFlags(e *env, fs *flag.FlagSet, synopsis string, args []string) error {
+	fs.SetOutput(e.stderr)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(e.stderr, "usage: ebctl %s %s\n", fs.Name(), synopsis)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	return nil
+}
+
+// required reports an error when one of the flags was left empty
+func required(fs *flag.FlagSet, names ...string) error {
+	for _, name := range names {
+		if fs.Lookup(name).Value.String() == "" {
+			fs.Usage()
+			return errUsage
+		}
+	}
+	return nil
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Vignesh-Rajarajan/event-bus/chunk"
+	"github.com/Vignesh-Rajarajan/event-bus/client"
+	"github.com/Vignesh-Rajarajan/event-bus/integration"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"github.com/phayes/freeport"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startInstance(t *testing.T) string {
+	t.Helper()
+	port, err := freeport.GetFreePort()
+	if err != nil {
+		t.Fatalf("error getting a port %v", err)
+	}
+	addr := fmt.Sprintf("localhost:%d", port)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- integration.InitAndServer(ctx, integration.InitArgs{
+			Backend:      replication.NewMemoryBackend(),
+			Dirname:      t.TempDir(),
+			Instance:     "luffy",
+			ListenerAddr: addr,
+			ClusterName:  "test",
+		})
+	}()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		select {
+		case err := <-errCh:
+			t.Fatalf("instance stopped %v", err)
+		default:
+		}
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			_ = conn.Close()
+			return addr
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("instance did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEbctl(t *testing.T) {
+	addr := startInstance(t)
+	ebctl := func(ctx context.Context, stdin string, args ...string) (string, error) {
+		t.Helper()
+		var stdout, stderr bytes.Buffer
+		err := run(ctx, append([]string{"-addr", addr}, args...), strings.NewReader(stdin), &stdout, &stderr)
+		return stdout.String(), err
+	}
+	expect := func(got string, err error, want string) {
+		t.Helper()
+		if err != nil || got != want {
+			t.Errorf("got %q error %v want %q", got, err, want)
+		}
+	}
+
+	out, err := ebctl(context.Background(), "1\n2\n\n3", "produce", "-category", "orders.eu", "-batch-size", "4")
+	expect(out, err, "sent 3 messages (6 bytes) to orders.eu\n")
+	out, err = ebctl(context.Background(), "", "produce", "-category", "payments")
+	expect(out, err, "sent 0 messages (0 bytes) to payments\n")
+	out, err = ebctl(context.Background(), "", "categories", "-pattern", "orders.*")
+	expect(out, err, "orders.eu\n")
+
+	out, err = ebctl(context.Background(), "", "-output", "json", "chunks", "-category", "orders.eu")
+	var chunks []chunk.Chunk
+	if err != nil || json.Unmarshal([]byte(out), &chunks) != nil || len(chunks) != 1 || chunks[0].Size != 6 {
+		t.Errorf("got chunks %s error %v", out, err)
+	}
+
+	out, err = ebctl(context.Background(), "", "consume", "-category", "orders.eu", "-consumer", "audit")
+	expect(out, err, "1\n2\n3\n")
+	out, err = ebctl(context.Background(), "", "-output", "json", "lag", "-category", "orders.eu")
+	var lags []client.ConsumerLag
+	if err != nil || json.Unmarshal([]byte(out), &lags) != nil || len(lags) != 1 || lags[0].Consumer != "audit" || lags[0].Bytes != 0 {
+		t.Errorf("got lag %s error %v", out, err)
+	}
+
+	// tail does not ack, it still sees what was consumed
+	_, err = ebctl(context.Background(), "4\n", "produce", "-category", "orders.eu")
+	expect("", err, "")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	out, err = ebctl(ctx, "", "-output", "json", "tail", "-category", "orders.eu", "-from-start", "-poll", "10ms")
+	want := ""
+	for _, msg := range []string{"1", "2", "3", "4"} {
+		want += fmt.Sprintf(`{"category":"orders.eu","message":"%s"}`+"\n", msg)
+	}
+	if out != want || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got tail %q error %v want %q", out, err, want)
+	}
+
+	out, err = ebctl(context.Background(), "", "-output", "json", "peers")
+	var peers []client.PeerStatus
+	if err != nil || json.Unmarshal([]byte(out), &peers) != nil || len(peers) != 1 || peers[0].Name != "luffy" || peers[0].Status == nil {
+		t.Errorf("got peers %s error %v", out, err)
+	}
+
+	if _, err := ebctl(context.Background(), "", "bogus"); !errors.Is(err, errUsage) {
+		t.Errorf("got error %v for an unknown command", err)
+	}
+	if _, err := ebctl(context.Background(), "", "consume"); !errors.Is(err, errUsage) {
+		t.Errorf("got error %v without a category", err)
+	}
+}
+
+func TestChunkAfter(t *testing.T) {
+	chunks := []chunk.Chunk{
+		{Name: "luffy-chunk000000100"},
+		{Name: "luffy-chunk000000101"},
+		{Name: "zoro-chunk000000005"},
+	}
+	read := make(map[string]uint64)
+	markRead(read, "zoro-chunk000000005")
+	markRead(read, "luffy-chunk000000100")
+	// the next chunk of luffy is found although its name sorts before the chunk read from zoro
+	if next, ok := chunkAfter(chunks, read); !ok || next.Name != "luffy-chunk000000101" {
+		t.Errorf("got next chunk %q found %v", next.Name, ok)
+	}
+	markRead(read, "luffy-chunk000000101")
+	if next, ok := chunkAfter(chunks, read); ok {
+		t.Errorf("got next chunk %q once every chunk was read", next.Name)
+	}
+	// a new instance starts being read from its first listed chunk
+	chunks = append([]chunk.Chunk{{Name: "chopper-chunk000000003"}}, chunks...)
+	if next, ok := chunkAfter(chunks, read); !ok || next.Name != "chopper-chunk000000003" {
+		t.Errorf("got next chunk %q found %v", next.Name, ok)
+	}
+}
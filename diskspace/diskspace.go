@@ -0,0 +1,22 @@
+// Package diskspace reports how much space is left on the file system holding a directory
+package diskspace
+
+import "errors"
+
+// ErrUnsupported is returned on platforms where the free space cannot be queried
+var ErrUnsupported = errors.New("disk space is not supported on this platform")
+
+// Usage is the space of the file system holding a directory, Free only counts
+// the bytes available to unprivileged users
+type Usage struct {
+	Total uint64 `json:"total"`
+	Free  uint64 `json:"free"`
+}
+
+// UsedRatio returns the share of the file system which cannot be used anymore, between 0 and 1
+func (u Usage) UsedRatio() float64 {
+	if u.Total == 0 || u.Free >= u.Total {
+		return 0
+	}
+	return float64(u.Total-u.Free) / float64(u.Total)
+}
@@ -0,0 +1,19 @@
+package diskspace
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	usage, err := Get(t.TempDir())
+	if errors.Is(err, ErrUnsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatalf("error getting disk space %v", err)
+	}
+	if usage.Total == 0 || usage.Free > usage.Total {
+		t.Errorf("got implausible usage %+v", usage)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd)
+
+package diskspace
+
+// Get returns the space of the file system holding the path
+func Get(path string) (Usage, error) {
+	return Usage{}, ErrUnsupported
+}
@@ -2,11 +2,14 @@ package integration
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"github.com/Vignesh-Rajarajan/event-bus/tlsconfig"
+	"github.com/Vignesh-Rajarajan/event-bus/tracing"
 	"github.com/Vignesh-Rajarajan/event-bus/web"
-	"log"
-	"os"
+	"log/slog"
 	"path/filepath"
 	"time"
 )
@@ -17,34 +20,255 @@ type InitArgs struct {
 	Instance     string
 	ListenerAddr string
 	ClusterName  string
+	LeaderElect  bool
+	LeaderTTL    time.Duration
+	AntiEntropy  time.Duration
+	Zone         string
+	Replicas     int
+	// Coordination selects where the cluster state is kept, either CoordinationEtcd, CoordinationRaft
+	// or CoordinationLocal, it defaults to etcd when EtcdAddr is set and to a standalone instance otherwise
+	Coordination string
+	RaftAddr     string
+	RaftDir      string
+	RaftPeers    map[string]string
+	// Backend overrides Coordination, instances running in the same process can share it
+	Backend replication.Backend
+	// OTLPEndpoint is the OTLP/HTTP collector the spans are exported to, tracing is disabled when empty
+	OTLPEndpoint string
+	// ShutdownTimeout bounds the time given to the requests in flight once the server is asked to stop
+	ShutdownTimeout time.Duration
+	// TLS secures the API, the traffic between the peers and the raft transport, every instance of the
+	// cluster must use it when one does
+	TLS tlsconfig.Config
+	// EtcdTLS secures the connection to etcd
+	EtcdTLS tlsconfig.Config
+	// Auth requires the requests to be authenticated and allowed by the access control lists
+	Auth bool
+	// ClusterToken grants every permission, the instances authenticate to each other with it so it
+	// is required with Auth
+	ClusterToken string
+	// ClientQuota and CategoryQuota limit the requests of the clients and categories which have
+	// no quota of their own in the cluster state, the requests between the instances are only
+	// exempt with Auth
+	ClientQuota   replication.Quota
+	CategoryQuota replication.Quota
+	// DiskHighWatermark is the share of the disk holding Dirname above which the writes are rejected
+	// until it goes below DiskLowWatermark, the watermarks are disabled when it is 0
+	DiskHighWatermark float64
+	DiskLowWatermark  float64
+	// NoAutoCreate requires the categories to be created through the admin API before they are written into
+	NoAutoCreate bool
+	// DrainTimeout bounds the time given to the chunks of a draining instance to be copied to the
+	// other peers, it defaults to an hour
+	DrainTimeout time.Duration
+	// ConsumerLagExpiry is how long after their last commit the lag metrics of the consumers are
+	// published, it defaults to a day
+	ConsumerLagExpiry time.Duration
 }
 
-func InitAndServer(args InitArgs) error {
+const defaultShutdownTimeout = 30 * time.Second
 
-	etcdCli, err := replication.NewClient(args.EtcdAddr, args.ClusterName)
+const (
+	CoordinationEtcd = "etcd"
+	CoordinationRaft = "raft"
+	// CoordinationLocal runs a standalone instance which keeps its state in a local file
+	CoordinationLocal = "local"
+)
+
+// InitAndServer starts the instance and serves requests until the context is cancelled,
+// then shuts the instance down gracefully
+func InitAndServer(ctx context.Context, args InitArgs) error {
+	if args.DiskHighWatermark != 0 && (args.DiskHighWatermark > 1 || args.DiskLowWatermark < 0 || args.DiskLowWatermark > args.DiskHighWatermark) {
+		return fmt.Errorf("disk watermarks must satisfy 0 <= low %v <= high %v <= 1", args.DiskLowWatermark, args.DiskHighWatermark)
+	}
+	if args.OTLPEndpoint != "" {
+		shutdown, err := tracing.Setup(context.Background(), args.OTLPEndpoint, args.Instance)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = shutdown(context.Background()) }()
+	}
+
+	serverTLS, clientTLS, err := peerTLS(args.TLS)
 	if err != nil {
-		return fmt.Errorf("error creating etcd client %v", err)
+		return err
+	}
+	replicationClient, opts, err := newReplicationClient(args, serverTLS, clientTLS)
+	if err != nil {
+		return err
+	}
+	var replicatorOpts []replication.ReplicatorOption
+	if serverTLS != nil {
+		opts = append(opts, web.WithTLS(serverTLS, clientTLS))
+		replicatorOpts = append(replicatorOpts, replication.WithPeerTLS(clientTLS))
+	}
+	if args.Auth {
+		opts = append(opts, web.WithAuth(args.ClusterToken))
+		replicatorOpts = append(replicatorOpts, replication.WithPeerToken(args.ClusterToken))
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer func() { _ = replicationClient.Close() }()
+	registerCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	if err := etcdCli.RegisterPeer(ctx, replication.Peer{
+	if err := replicationClient.RegisterPeer(registerCtx, replication.Peer{
 		Addr: args.ListenerAddr,
 		Name: args.Instance,
+		Zone: args.Zone,
 	}); err != nil {
 		return fmt.Errorf("error registering peer %v", err)
 	}
 
-	fileName := filepath.Join(args.Dirname, "events")
-	fp, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, 0666)
+	if err := web.CheckDataDir(args.Dirname); err != nil {
+		return err
+	}
+
+	if args.LeaderElect {
+		opts = append(opts, web.WithLeadership(replication.NewLeadership(replicationClient, args.Instance, args.LeaderTTL)))
+	}
+	if args.DrainTimeout > 0 {
+		opts = append(opts, web.WithDrainTimeout(args.DrainTimeout))
+	}
+	if args.ConsumerLagExpiry > 0 {
+		opts = append(opts, web.WithConsumerLagExpiry(args.ConsumerLagExpiry))
+	}
+	storage := replication.NewStorage(replicationClient, args.Instance, replication.WithReplicationFactor(args.Replicas))
+	opts = append(opts, web.WithCluster(args.ClusterName), web.WithQuotas(args.ClientQuota, args.CategoryQuota),
+		web.WithDiskWatermarks(args.DiskHighWatermark, args.DiskLowWatermark),
+		web.WithAutoCreate(!args.NoAutoCreate))
+	s := web.NewServer(replicationClient, args.Instance, args.Dirname, args.ListenerAddr, storage, opts...)
+
+	// the background work stops before the server shuts down
+	bgCtx, stopBackground := context.WithCancel(ctx)
+	defer stopBackground()
+	go s.ReportDiskUsage(bgCtx, time.Minute)
+	go s.ReportConsumerLag(bgCtx, time.Minute)
+	go s.WatchDiskSpace(bgCtx, 10*time.Second)
+	go s.RunRetention(bgCtx, time.Minute)
+	go s.RunRollover(bgCtx, time.Second)
+
+	replicator := replication.NewReplicator(replicationClient, args.Instance, s, replicatorOpts...)
+	go func() {
+		if err := replicator.Loop(bgCtx); err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error("replication stopped", "instance", args.Instance, "error", err)
+		}
+	}()
+
+	if args.AntiEntropy > 0 {
+		go s.RunAntiEntropy(bgCtx, args.AntiEntropy)
+	}
+
+	if coordination(args) == CoordinationLocal && args.Coordination == "" {
+		// etcd used to be reached on 127.0.0.1:2379 by default
+		slog.Warn("no etcd address given, running as a standalone instance", "instance", args.Instance)
+	}
+	slog.Info("starting server", "instance", args.Instance, "addr", args.ListenerAddr, "dirname", args.Dirname, "coordination", coordination(args))
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.Start() }()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down server", "instance", args.Instance)
+	stopBackground()
+	timeout := args.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), timeout)
+	defer cancelShutdown()
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	return <-errCh
+}
+
+// coordination returns the name of the coordination backend selected by the arguments
+func coordination(args InitArgs) string {
+	switch {
+	case args.Backend != nil:
+		return "custom"
+	case args.Coordination != "":
+		return args.Coordination
+	case len(args.EtcdAddr) > 0:
+		return CoordinationEtcd
+	default:
+		return CoordinationLocal
+	}
+}
+
+// peerTLS returns the configuration of the listeners and of the connections to the peers,
+// both are nil when TLS is not configured
+func peerTLS(cfg tlsconfig.Config) (*tls.Config, *tls.Config, error) {
+	if cfg.IsZero() {
+		return nil, nil, nil
+	}
+	server, err := cfg.Server()
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := cfg.Client()
 	if err != nil {
-		log.Fatalf("error creating a test in provided directory %s file %v", args.Dirname, err)
+		return nil, nil, err
 	}
-	_ = fp.Close()
-	_ = os.Remove(fp.Name())
+	return server, client, nil
+}
 
-	s := web.NewServer(etcdCli, args.Instance, args.Dirname, args.ListenerAddr, replication.NewStorage(etcdCli, args.Instance))
+// raftToken returns the token authenticating the changes forwarded to the raft leader
+func raftToken(args InitArgs) string {
+	if !args.Auth {
+		return ""
+	}
+	return args.ClusterToken
+}
 
-	log.Default().Println("Starting server on addr ", args.ListenerAddr, " dirname ", args.Dirname, " ...", "etcd ", args.EtcdAddr)
-	return s.Start()
+// newReplicationClient connects to the coordination backend, the returned options let
+// the server take part in the backend
+func newReplicationClient(args InitArgs, serverTLS, clientTLS *tls.Config) (*replication.Client, []web.Option, error) {
+	switch coordination(args) {
+	case "custom":
+		return replication.NewClientWithBackend(args.Backend, args.ClusterName), nil, nil
+	case CoordinationEtcd:
+		var etcdOpts []replication.EtcdOption
+		if !args.EtcdTLS.IsZero() {
+			cfg, err := args.EtcdTLS.Client()
+			if err != nil {
+				return nil, nil, err
+			}
+			etcdOpts = append(etcdOpts, replication.WithEtcdTLS(cfg))
+		}
+		replicationClient, err := replication.NewClient(args.EtcdAddr, args.ClusterName, etcdOpts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error creating etcd client %v", err)
+		}
+		return replicationClient, nil, nil
+	case CoordinationRaft:
+		raftDir := args.RaftDir
+		if raftDir == "" {
+			raftDir = filepath.Join(args.Dirname, ".raft")
+		}
+		backend, err := replication.NewRaftBackend(replication.RaftConfig{
+			Instance:  args.Instance,
+			Addr:      args.RaftAddr,
+			HTTPAddr:  args.ListenerAddr,
+			Dir:       raftDir,
+			Peers:     args.RaftPeers,
+			ServerTLS: serverTLS,
+			ClientTLS: clientTLS,
+			Token:     raftToken(args),
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("error starting raft %v", err)
+		}
+		return replication.NewClientWithBackend(backend, args.ClusterName), []web.Option{web.WithRaft(backend)}, nil
+	case CoordinationLocal:
+		backend, err := replication.NewLocalBackend(filepath.Join(args.Dirname, ".local", "cluster.json"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("error loading local state %v", err)
+		}
+		return replication.NewClientWithBackend(backend, args.ClusterName), nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown coordination backend %s", args.Coordination)
+	}
 }
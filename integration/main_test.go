@@ -1,16 +1,19 @@
 package integration
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/Vignesh-Rajarajan/event-bus/client"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"github.com/Vignesh-Rajarajan/event-bus/tlsconfig/tlsconfigtest"
 	"github.com/phayes/freeport"
 	"github.com/stretchr/testify/assert"
 	"io"
 	"log"
 	"net"
+	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -43,22 +46,13 @@ func TestSimpleClientServer(t *testing.T) {
 func simpleClientAndServerTest(t *testing.T, concurrent bool) {
 	t.Helper()
 	log.SetFlags(log.Flags() | log.Lmicroseconds)
-	etcdPeerPort, err := freeport.GetFreePort()
-	assert.NoError(t, err)
-	etcdPort, err := freeport.GetFreePort()
-	assert.NoError(t, err)
 	port, err := freeport.GetFreePort()
 	assert.NoError(t, err)
 	dbPath, err := os.MkdirTemp(os.TempDir(), "event-bus-test")
 	assert.NoError(t, err)
-	etcdPath, err := os.MkdirTemp(os.TempDir(), "etcd")
-	assert.NoError(t, err)
 	t.Cleanup(func() {
 		assert.NoError(t, os.RemoveAll(dbPath))
 	})
-	t.Cleanup(func() {
-		assert.NoError(t, os.RemoveAll(etcdPath))
-	})
 
 	categoryPath := filepath.Join(dbPath, "numbers")
 	_ = os.Mkdir(categoryPath, 0777)
@@ -66,33 +60,11 @@ func simpleClientAndServerTest(t *testing.T, concurrent bool) {
 	_ = os.Mkdir(dbPath, 0777)
 	_ = os.WriteFile(filepath.Join(categoryPath, fmt.Sprintf("luffy-chunk%09d", 1)), []byte("12345\n"), 0666)
 
-	etcdArgs := []string{
-		"--data-dir", etcdPath,
-		"--listen-client-urls", fmt.Sprintf("http://localhost:%d", etcdPort),
-		"--advertise-client-urls", fmt.Sprintf("http://localhost:%d", etcdPort),
-		"--listen-peer-urls", fmt.Sprintf("http://localhost:%d", etcdPeerPort),
-	}
-	log.Printf("running etcd with etcdArgs %v", etcdArgs)
-
-	etcdCmd := exec.Command("etcd", etcdArgs...)
-	etcdCmd.Env = append(os.Environ(), "ETCD_UNSUPPORTED_ARCH=arm64")
-	if err := etcdCmd.Start(); err != nil {
-		t.Fatalf("error while starting etcd %v", err)
-	}
-	t.Cleanup(func() {
-		if err := etcdCmd.Process.Kill(); err != nil {
-			log.Printf("error while killing etcd process %v", err)
-		}
-	})
-
-	log.Default().Printf("waiting etcd on port %d", etcdPort)
-	waitForPort(t, etcdPort, make(chan error, 1))
-
 	log.Default().Printf("starting server on port %d", port)
 	errChan := make(chan error, 1)
 	go func() {
-		errChan <- InitAndServer(InitArgs{
-			EtcdAddr:     []string{fmt.Sprintf("localhost:%d", etcdPort)},
+		errChan <- InitAndServer(context.Background(), InitArgs{
+			Backend:      replication.NewMemoryBackend(),
 			Dirname:      dbPath,
 			Instance:     "luffy",
 			ListenerAddr: fmt.Sprintf("localhost:%d", port),
@@ -263,3 +235,252 @@ func receive(c *client.Client, sendCompleted chan bool) (sum int64, err error) {
 		}
 	}
 }
+
+func TestReplicationBetweenInstances(t *testing.T) {
+	addrs, dirs := startInstances(t, func(instance string) InitArgs { return InitArgs{} })
+	c := client.NewClient("http://" + addrs["luffy"])
+	assert.NoError(t, c.Send("numbers", []byte("1\n2\n3\n")))
+	waitForReplica(t, filepath.Join(dirs["zoro"], "numbers", fmt.Sprintf("luffy-chunk%09d", 0)), "1\n2\n3\n")
+}
+
+func TestWritesProxiedToLeader(t *testing.T) {
+	addrs, dirs := startInstances(t, func(instance string) InitArgs { return InitArgs{LeaderElect: true} })
+	// the first instance asked about the category leads it
+	assert.NoError(t, client.NewClient("http://"+addrs["luffy"]).Send("numbers", []byte("1\n")))
+	assert.NoError(t, client.NewClient("http://"+addrs["zoro"]).Send("numbers", []byte("2\n")))
+
+	contents, err := os.ReadFile(filepath.Join(dirs["luffy"], "numbers", fmt.Sprintf("luffy-chunk%09d", 0)))
+	assert.NoError(t, err)
+	assert.Equal(t, "1\n2\n", string(contents))
+	if _, err := os.Stat(filepath.Join(dirs["zoro"], "numbers", fmt.Sprintf("zoro-chunk%09d", 0))); !os.IsNotExist(err) {
+		t.Errorf("zoro wrote a chunk of a category it does not lead %v", err)
+	}
+}
+
+// startInstances starts luffy and zoro sharing a coordination backend, the arguments returned
+// by args are completed with the ones making up the cluster
+func startInstances(t *testing.T, args func(instance string) InitArgs) (addrs, dirs map[string]string) {
+	t.Helper()
+	backend := replication.NewMemoryBackend()
+	addrs = make(map[string]string)
+	dirs = make(map[string]string)
+	for _, instance := range []string{"luffy", "zoro"} {
+		port, err := freeport.GetFreePort()
+		assert.NoError(t, err)
+		addrs[instance] = fmt.Sprintf("localhost:%d", port)
+		dirs[instance] = t.TempDir()
+		a := args(instance)
+		a.Backend = backend
+		a.Dirname = dirs[instance]
+		a.Instance = instance
+		a.ListenerAddr = addrs[instance]
+		a.ClusterName = "test"
+		errChan := make(chan error, 1)
+		go func() {
+			errChan <- InitAndServer(context.Background(), a)
+		}()
+		waitForPort(t, port, errChan)
+	}
+	return addrs, dirs
+}
+
+func waitForReplica(t *testing.T, replica, want string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		contents, err := os.ReadFile(replica)
+		if err == nil && string(contents) == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("chunk was not replicated, got %q %v", contents, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	backend := replication.NewMemoryBackend()
+	port, err := freeport.GetFreePort()
+	assert.NoError(t, err)
+	addr := fmt.Sprintf("localhost:%d", port)
+	dir := t.TempDir()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- InitAndServer(ctx, InitArgs{
+			Backend:      backend,
+			Dirname:      dir,
+			Instance:     "luffy",
+			ListenerAddr: addr,
+			ClusterName:  "test",
+		})
+	}()
+	waitForPort(t, port, errChan)
+
+	c := client.NewClient("http://" + addr)
+	assert.NoError(t, c.Send("numbers", []byte("1\n2\n3\n")))
+
+	cancel()
+	select {
+	case err := <-errChan:
+		assert.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatalf("server did not shut down")
+	}
+
+	contents, err := os.ReadFile(filepath.Join(dir, "numbers", fmt.Sprintf("luffy-chunk%09d", 0)))
+	assert.NoError(t, err)
+	assert.Equal(t, "1\n2\n3\n", string(contents))
+	peers, err := replication.NewClientWithBackend(backend, "test").ListPeers(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, peers)
+	_, err = net.DialTimeout("tcp", addr, 100*time.Millisecond)
+	assert.Error(t, err)
+}
+
+func TestReplicationOverMutualTLS(t *testing.T) {
+	ca := tlsconfigtest.NewCA(t)
+	addrs, dirs := startInstances(t, func(instance string) InitArgs { return InitArgs{TLS: ca.Issue(instance)} })
+
+	assert.Error(t, client.NewClient("https://"+addrs["luffy"]).Send("numbers", []byte("0\n")))
+
+	clientTLS, err := ca.Issue("client").Client()
+	assert.NoError(t, err)
+	c := client.NewClient("https://"+addrs["luffy"], client.WithTLS(clientTLS))
+	assert.NoError(t, c.Send("numbers", []byte("1\n2\n3\n")))
+	waitForReplica(t, filepath.Join(dirs["zoro"], "numbers", fmt.Sprintf("luffy-chunk%09d", 0)), "1\n2\n3\n")
+}
+
+func TestReplicationWithAuth(t *testing.T) {
+	addrs, dirs := startInstances(t, func(instance string) InitArgs { return InitArgs{Auth: true, ClusterToken: "secret"} })
+
+	assert.Error(t, client.NewClient("http://"+addrs["luffy"]).Send("numbers", []byte("0\n")))
+
+	c := client.NewClient("http://"+addrs["luffy"], client.WithToken("secret"))
+	assert.NoError(t, c.Send("numbers", []byte("1\n2\n3\n")))
+	waitForReplica(t, filepath.Join(dirs["zoro"], "numbers", fmt.Sprintf("luffy-chunk%09d", 0)), "1\n2\n3\n")
+}
+
+func TestClientBacksOffWhenOverQuota(t *testing.T) {
+	port, err := freeport.GetFreePort()
+	assert.NoError(t, err)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- InitAndServer(context.Background(), InitArgs{
+			Backend:       replication.NewMemoryBackend(),
+			Dirname:       t.TempDir(),
+			Instance:      "luffy",
+			ListenerAddr:  fmt.Sprintf("localhost:%d", port),
+			ClusterName:   "test",
+			CategoryQuota: replication.Quota{BytesPerSecond: 4},
+		})
+	}()
+	waitForPort(t, port, errChan)
+
+	addr := fmt.Sprintf("http://localhost:%d", port)
+	assert.NoError(t, client.NewClient(addr).Send("numbers", []byte("1\n2\n3\n4\n")))
+	assert.Error(t, client.NewClient(addr, client.WithMaxBackoff(0)).Send("numbers", []byte("5\n")))
+
+	start := time.Now()
+	assert.NoError(t, client.NewClient(addr).Send("numbers", []byte("5\n")))
+	if waited := time.Since(start); waited < 500*time.Millisecond {
+		t.Errorf("the write went through after %s, before the quota let it", waited)
+	}
+}
+
+func TestDeleteCategoryEverywhere(t *testing.T) {
+	addrs, dirs := startInstances(t, func(instance string) InitArgs { return InitArgs{} })
+	c := client.NewClient("http://" + addrs["luffy"])
+	assert.NoError(t, c.Send("numbers", []byte("1\n2\n3\n")))
+	waitForReplica(t, filepath.Join(dirs["zoro"], "numbers", fmt.Sprintf("luffy-chunk%09d", 0)), "1\n2\n3\n")
+
+	req, err := http.NewRequest(http.MethodDelete, "http://"+addrs["luffy"]+"/admin/categories?category=numbers", nil)
+	assert.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	// the copy of the chunk being written into was still followed, it must not come back
+	time.Sleep(time.Second)
+	for instance, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, "numbers")); !os.IsNotExist(err) {
+			t.Errorf("category is still stored on %s %v", instance, err)
+		}
+	}
+}
+
+func TestClusterAckStartsWithOwner(t *testing.T) {
+	addrs, dirs := startInstances(t, func(instance string) InitArgs { return InitArgs{} })
+	resp, err := http.Post("http://"+addrs["luffy"]+"/admin/categories?category=numbers", "application/json", strings.NewReader(`{"maxChunkSize":2}`))
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	c := client.NewClient("http://"+addrs["zoro"], client.WithClusterView())
+	assert.NoError(t, client.NewClient("http://"+addrs["luffy"]).Send("numbers", []byte("1\n")))
+	assert.NoError(t, client.NewClient("http://"+addrs["luffy"]).Send("numbers", []byte("2\n")))
+
+	name := fmt.Sprintf("luffy-chunk%09d", 0)
+	deadline := time.Now().Add(10 * time.Second)
+	for replicated := false; !replicated; {
+		chunks, err := c.ListChunks("numbers")
+		assert.NoError(t, err)
+		for _, ch := range chunks {
+			replicated = replicated || (ch.Name == name && len(ch.Replicas) == 1 && ch.Replicas[0].Complete)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("chunk was not replicated, got %v", chunks)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// the owner refuses an ack which does not cover the whole chunk, the replica must be kept
+	assert.Error(t, c.AckChunk("numbers", name, 1))
+	for instance, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, "numbers", name)); err != nil {
+			t.Errorf("chunk was dropped on %s %v", instance, err)
+		}
+	}
+	assert.NoError(t, c.AckChunk("numbers", name, 2))
+	for instance, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, "numbers", name)); !os.IsNotExist(err) {
+			t.Errorf("chunk is still stored on %s %v", instance, err)
+		}
+	}
+}
+
+func TestProcessPattern(t *testing.T) {
+	addrs, _ := startInstances(t, func(instance string) InitArgs { return InitArgs{} })
+	assert.NoError(t, client.NewClient("http://"+addrs["luffy"]).Send("orders.eu", []byte("1\n2\n")))
+	assert.NoError(t, client.NewClient("http://"+addrs["zoro"]).Send("orders.us", []byte("3\n")))
+	assert.NoError(t, client.NewClient("http://"+addrs["luffy"]).Send("payments", []byte("4\n")))
+
+	c := client.NewClient("http://"+addrs["luffy"], client.WithClusterView(), client.WithPatternRefresh(0))
+	categories, err := c.Categories("orders.*")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"orders.eu", "orders.us"}, categories)
+
+	consume := func() map[string]string {
+		got := make(map[string]string)
+		for {
+			err := c.ProcessPattern("orders.*", nil, func(category string, batch []byte) error {
+				got[category] += string(batch)
+				return nil
+			})
+			if errors.Is(err, io.EOF) {
+				return got
+			}
+			if !assert.NoError(t, err) {
+				return got
+			}
+		}
+	}
+	assert.Equal(t, map[string]string{"orders.eu": "1\n2\n", "orders.us": "3\n"}, consume())
+
+	// the categories created later are picked up and the others resume from their position
+	assert.NoError(t, client.NewClient("http://"+addrs["luffy"]).Send("orders.asia", []byte("5\n")))
+	assert.NoError(t, client.NewClient("http://"+addrs["luffy"]).Send("orders.eu", []byte("6\n")))
+	assert.Equal(t, map[string]string{"orders.asia": "5\n", "orders.eu": "6\n"}, consume())
+}
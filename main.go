@@ -1,22 +1,68 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"github.com/Vignesh-Rajarajan/event-bus/integration"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"github.com/Vignesh-Rajarajan/event-bus/tlsconfig"
 	"log"
+	"log/slog"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 )
 
 var (
-	dirname      = flag.String("dirname", "/tmp", "File name to use for file based event bus")
-	etcdAddr     = flag.String("etcd", "http://127.0.0.1:2379", "etcd address")
-	instanceName = flag.String("instance", "op", "unique instance name")
-	listenAddr   = flag.String("listen", "127.0.0.1:8080", "network listen address")
-	clusterName  = flag.String("cluster", "default", "cluster name")
+	dirname             = flag.String("dirname", "/tmp", "File name to use for file based event bus")
+	etcdAddr            = flag.String("etcd", "", "comma separated etcd addresses, e.g. http://127.0.0.1:2379. It used to default to http://127.0.0.1:2379, without it the instance now runs standalone unless -coordination is set")
+	instanceName        = flag.String("instance", "op", "unique instance name")
+	listenAddr          = flag.String("listen", "127.0.0.1:8080", "network listen address")
+	clusterName         = flag.String("cluster", "default", "cluster name")
+	leaderElect         = flag.Bool("leader-election", false, "elect a single leader per category which accepts all the writes for it")
+	leaderTTL           = flag.Duration("leader-ttl", replication.DefaultLeaderTTL, "time after which the leadership of an unresponsive instance expires")
+	zone                = flag.String("zone", "", "zone or rack of the instance, copies of a chunk are spread across zones")
+	replicas            = flag.Int("replication-factor", 0, "number of copies of every chunk including the owner's one, 0 keeps a copy on every instance")
+	coordination        = flag.String("coordination", "", "where the cluster state is kept: etcd, raft to run an embedded raft group among the instances or local for a standalone instance, defaults to etcd when -etcd is set and local otherwise")
+	raftAddr            = flag.String("raft-addr", "127.0.0.1:9080", "raft transport address used with -coordination=raft")
+	raftDir             = flag.String("raft-dir", "", "directory of the raft log and snapshots, defaults to .raft inside dirname")
+	raftPeers           = flag.String("raft-peers", "", "comma separated instance=raft-addr members used to bootstrap the raft group, the current instance is always included")
+	otlpEndpoint        = flag.String("otlp-endpoint", "", "OTLP/HTTP collector the traces are exported to, e.g. http://127.0.0.1:4318, tracing is disabled when empty")
+	antiEntropy         = flag.Duration("anti-entropy-interval", time.Minute, "interval between comparisons of the chunks stored on every peer, 0 disables it")
+	shutdownTimeout     = flag.Duration("shutdown-timeout", 30*time.Second, "time given to the requests in flight to complete once the server is asked to stop")
+	tlsCert             = flag.String("tls-cert", "", "certificate served over TLS and presented to the peers, it is reloaded when the file changes, every instance of the cluster must use TLS when one does")
+	tlsKey              = flag.String("tls-key", "", "private key of -tls-cert")
+	tlsCA               = flag.String("tls-ca", "", "CA verifying the certificates of the peers, defaults to the system roots")
+	tlsClientCA         = flag.String("tls-client-ca", "", "CA verifying the client certificates, clients must present a certificate when it is set")
+	etcdCert            = flag.String("etcd-cert", "", "client certificate presented to etcd")
+	etcdKey             = flag.String("etcd-key", "", "private key of -etcd-cert")
+	etcdCA              = flag.String("etcd-ca", "", "CA verifying the certificate of etcd, etcd is reached over TLS when any of the -etcd-ca, -etcd-cert or -etcd-key flags is set")
+	auth                = flag.Bool("auth", false, "require the requests to be authenticated with a token or a client certificate and allowed by the access control lists, -cluster-token must be set")
+	clusterToken        = flag.String("cluster-token", os.Getenv("EVENT_BUS_CLUSTER_TOKEN"), "token granting every permission which the instances use to talk to each other, defaults to $EVENT_BUS_CLUSTER_TOKEN")
+	clientByteRate      = flag.Float64("client-byte-rate", 0, "bytes per second each client can write through an instance, 0 is unlimited, /admin/quotas overrides it per client")
+	clientRequestRate   = flag.Float64("client-request-rate", 0, "requests per second each client can send to an instance, 0 is unlimited, /admin/quotas overrides it per client")
+	categoryByteRate    = flag.Float64("category-byte-rate", 0, "bytes per second written into each category through an instance, 0 is unlimited, /admin/quotas overrides it per category")
+	categoryRequestRate = flag.Float64("category-request-rate", 0, "requests per second on each category received by an instance, 0 is unlimited, /admin/quotas overrides it per category")
+	diskHighWatermark   = flag.Float64("disk-high-watermark", 0, "share of the disk holding -dirname above which writes are rejected and the chunks processed by every consumer are removed, e.g. 0.9, 0 disables it")
+	diskLowWatermark    = flag.Float64("disk-low-watermark", 0, "share of the disk holding -dirname below which writes are accepted again, defaults to 0.1 below -disk-high-watermark")
+	drainTimeout        = flag.Duration("drain-timeout", time.Hour, "time given to the chunks of a draining instance to be copied to the other instances before the drain gives up")
+	consumerLagExpiry   = flag.Duration("consumer-lag-expiry", 24*time.Hour, "time after their last commit when the lag metrics of a consumer are no longer published, /lag still reports it")
+	autoCreate          = flag.Bool("auto-create", true, "create the categories on their first write, otherwise they must be created through /admin/categories")
+	logLevel            = flag.String("log-level", "info", "minimum level of the logged records: debug, info, warn or error")
+	logFormat           = flag.String("log-format", "text", "format of the logged records: text or json")
 )
 
 func main() {
 	flag.Parse()
+	logger, err := newLogger(*logLevel, *logFormat)
+	if err != nil {
+		log.Fatalf("invalid logging configuration %v", err)
+	}
+	slog.SetDefault(logger)
+
 	if *dirname == "" {
 		log.Fatalf("dirname cannot be empty")
 	}
@@ -26,21 +72,109 @@ func main() {
 	if *listenAddr == "" {
 		log.Fatalf("listen address cannot be empty")
 	}
-	if *etcdAddr == "" {
+	if *coordination == integration.CoordinationEtcd && *etcdAddr == "" {
 		log.Fatalf("etcd address cannot be empty")
 	}
 	if *clusterName == "" {
 		log.Fatalf("cluster name cannot be empty")
 
 	}
+	if *auth && *clusterToken == "" {
+		// the instances authenticate to each other with it, a client certificate has no grants of its own
+		log.Fatalf("authentication requires a cluster token for the instances to talk to each other")
+	}
+	if *diskHighWatermark > 0 && *diskLowWatermark == 0 {
+		*diskLowWatermark = max(0, *diskHighWatermark-0.1)
+	}
+	peers, err := parseRaftPeers(*raftPeers)
+	if err != nil {
+		log.Fatalf("invalid raft peers %v", err)
+	}
+
+	var etcdAddrs []string
+	if *etcdAddr != "" {
+		etcdAddrs = strings.Split(*etcdAddr, ",")
+	}
 
-	if err := integration.InitAndServer(integration.InitArgs{
-		EtcdAddr:     strings.Split(*etcdAddr, ","),
-		Dirname:      *dirname,
-		Instance:     *instanceName,
-		ListenerAddr: *listenAddr,
-		ClusterName:  *clusterName,
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := integration.InitAndServer(ctx, integration.InitArgs{
+		EtcdAddr:        etcdAddrs,
+		Dirname:         *dirname,
+		Instance:        *instanceName,
+		ListenerAddr:    *listenAddr,
+		ClusterName:     *clusterName,
+		LeaderElect:     *leaderElect,
+		LeaderTTL:       *leaderTTL,
+		AntiEntropy:     *antiEntropy,
+		Zone:            *zone,
+		Replicas:        *replicas,
+		Coordination:    *coordination,
+		RaftAddr:        *raftAddr,
+		RaftDir:         *raftDir,
+		RaftPeers:       peers,
+		OTLPEndpoint:    *otlpEndpoint,
+		ShutdownTimeout: *shutdownTimeout,
+		TLS: tlsconfig.Config{
+			CertFile:     *tlsCert,
+			KeyFile:      *tlsKey,
+			CAFile:       *tlsCA,
+			ClientCAFile: *tlsClientCA,
+		},
+		EtcdTLS: tlsconfig.Config{
+			CertFile: *etcdCert,
+			KeyFile:  *etcdKey,
+			CAFile:   *etcdCA,
+		},
+		Auth:         *auth,
+		ClusterToken: *clusterToken,
+		ClientQuota: replication.Quota{
+			BytesPerSecond:    *clientByteRate,
+			RequestsPerSecond: *clientRequestRate,
+		},
+		CategoryQuota: replication.Quota{
+			BytesPerSecond:    *categoryByteRate,
+			RequestsPerSecond: *categoryRequestRate,
+		},
+		DiskHighWatermark: *diskHighWatermark,
+		DiskLowWatermark:  *diskLowWatermark,
+		NoAutoCreate:      !*autoCreate,
+		DrainTimeout:      *drainTimeout,
+		ConsumerLagExpiry: *consumerLagExpiry,
 	}); err != nil {
 		log.Fatalf("error starting server %v", err)
 	}
 }
+
+// newLogger creates the logger writing the records of the given level and above to stderr
+func newLogger(level, format string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: l}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %s", format)
+	}
+}
+
+// parseRaftPeers parses a comma separated list of instance=addr pairs
+func parseRaftPeers(s string) (map[string]string, error) {
+	peers := make(map[string]string)
+	if s == "" {
+		return peers, nil
+	}
+	for _, p := range strings.Split(s, ",") {
+		instance, addr, ok := strings.Cut(p, "=")
+		if !ok || instance == "" || addr == "" {
+			return nil, fmt.Errorf("expected instance=addr, got %q", p)
+		}
+		peers[instance] = addr
+	}
+	return peers, nil
+}
@@ -1,27 +1,112 @@
 package manager
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"github.com/Vignesh-Rajarajan/event-bus/chunk"
+	"github.com/Vignesh-Rajarajan/event-bus/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"hash/crc32"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
-const maxOnDiskChunkSize = 20 * 1024 * 1024
+const (
+	// DefaultMaxChunkSize is the size above which a new chunk is started unless configured otherwise
+	DefaultMaxChunkSize = 20 * 1024 * 1024
+	// maxProducersPerChunk bounds the trace contexts remembered for the writes into a chunk
+	maxProducersPerChunk = 1024
+)
 
 var chunkRegex = regexp.MustCompile("^chunk([0-9]+)$")
 
+// ChunkOwner returns the instance which created the chunk, chunk names are in the form of `<instance>-chunkNNN`
+func ChunkOwner(name string) (string, bool) {
+	owner, _, ok := ParseChunkName(name)
+	return owner, ok
+}
+
+// ParseChunkName returns the instance which created the chunk and the index of the chunk among the
+// chunks of that instance, the chunks of an instance are written in the order of their index
+func ParseChunkName(name string) (owner string, idx uint64, ok bool) {
+	i := strings.LastIndex(name, "-chunk")
+	if i <= 0 {
+		return "", 0, false
+	}
+	res := chunkRegex.FindStringSubmatch(name[i+1:])
+	if len(res) == 0 {
+		return "", 0, false
+	}
+	idx, err := strconv.ParseUint(res[1], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return name[:i], idx, true
+}
+
+// FsyncPolicy tells when the writes are flushed to stable storage
+type FsyncPolicy string
+
+const (
+	// FsyncNever leaves flushing to the operating system, the last acknowledged writes can be lost
+	// if the machine crashes
+	FsyncNever FsyncPolicy = "never"
+	// FsyncOnSeal flushes a chunk once it is complete
+	FsyncOnSeal FsyncPolicy = "seal"
+	// FsyncAlways flushes every write before acknowledging it
+	FsyncAlways FsyncPolicy = "always"
+)
+
+// Settings configures how the events of a category are stored, zero values keep the defaults
+type Settings struct {
+	MaxChunkSize uint64
+	// MaxChunkAge completes a chunk once it has been written into for that long, even if it is small,
+	// so that the events of quiet categories can be acked
+	MaxChunkAge time.Duration
+	// MaxChunkMessages bounds the messages of a chunk, a write which would take the chunk over it
+	// goes into a new chunk
+	MaxChunkMessages uint64
+	Fsync            FsyncPolicy
+	// ReplicationFactor is the number of copies of every chunk including the owner's one, zero
+	// keeps the default of the instance
+	ReplicationFactor int
+}
+
+// rollover tells whether the chunk being written into, holding size bytes in messages messages
+// since created, must be completed before writing a batch of n bytes in batchMessages messages into
+// it. A chunk is never completed while it is empty, so a batch larger than the limits still gets stored.
+func (s Settings) rollover(defaultMaxSize, size, messages, n, batchMessages uint64, created, now time.Time) bool {
+	if size == 0 {
+		return false
+	}
+	maxSize := s.MaxChunkSize
+	if maxSize == 0 {
+		maxSize = defaultMaxSize
+	}
+	return size+n > maxSize ||
+		(s.MaxChunkMessages > 0 && messages+batchMessages > s.MaxChunkMessages) ||
+		(s.MaxChunkAge > 0 && now.Sub(created) >= s.MaxChunkAge)
+}
+
+// StorageHooks is told about every chunk before it is created, with the replication factor of
+// its category
 type StorageHooks interface {
-	Init(ctx context.Context, category, fileName string) error
+	Init(ctx context.Context, category, fileName string, replicationFactor int) error
 }
 
+// ErrDeleted is returned by the writes into the storage of a category which has been deleted
+var ErrDeleted = errors.New("category has been deleted")
+
 // EventBusOnDisk is an implementation of EventManager which stores the events on disk
 type EventBusOnDisk struct {
 	dirname            string
@@ -32,19 +117,69 @@ type EventBusOnDisk struct {
 	lastChunk          string
 	lastChunkSize      uint64
 	lastChunkIdx       uint64
+	lastChunkMessages  uint64
+	lastChunkCreated   time.Time
+	lastChunkCRC       uint32
 	filePointers       map[string]*os.File
+	producers          map[string][]producer
+	logger             *slog.Logger
+	settings           Settings
+	deleted            bool
+}
+
+// Option configures optional behaviour of EventBusOnDisk and EventBusInMemory
+type Option func(*options)
+
+type options struct {
+	logger   *slog.Logger
+	settings Settings
+}
+
+// WithLogger replaces the default logger, the category is added to every record. EventBusInMemory
+// logs nothing.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithSettings configures how the events are stored, the fsync policy does not apply to EventBusInMemory
+func WithSettings(settings Settings) Option {
+	return func(o *options) {
+		o.settings = settings
+	}
+}
+
+// SetSettings changes how the next events are stored
+func (c *EventBusOnDisk) SetSettings(settings Settings) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.settings = settings
+}
+
+// producer is the trace context of a write into a chunk starting at offset
+type producer struct {
+	offset uint64
+	span   trace.SpanContext
 }
 
 var _ EventManager = (*EventBusOnDisk)(nil)
 
 // NewEventBusOnDisk creates a new event bus on disk
-func NewEventBusOnDisk(dirname, category, instanceName string, replicationStorage StorageHooks) (*EventBusOnDisk, error) {
+func NewEventBusOnDisk(dirname, category, instanceName string, replicationStorage StorageHooks, opts ...Option) (*EventBusOnDisk, error) {
+	o := options{logger: slog.Default()}
+	for _, opt := range opts {
+		opt(&o)
+	}
 	e := &EventBusOnDisk{
 		dirname:            dirname,
 		category:           category,
 		instanceName:       instanceName,
 		replicationStorage: replicationStorage,
 		filePointers:       make(map[string]*os.File),
+		producers:          make(map[string][]producer),
+		logger:             o.logger.With("category", category),
+		settings:           o.settings,
 	}
 	if err := e.initLastChunkIdx(); err != nil {
 		return nil, err
@@ -53,39 +188,116 @@ func NewEventBusOnDisk(dirname, category, instanceName string, replicationStorag
 }
 
 // Write writes the message to the last chunk
-func (c *EventBusOnDisk) Write(ctx context.Context, msg []byte) error {
+func (c *EventBusOnDisk) Write(ctx context.Context, msg []byte) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "EventBusOnDisk.Write", trace.WithAttributes(
+		attribute.String("category", c.category),
+		attribute.Int("bytes", len(msg)),
+	))
+	defer func() { tracing.End(span, err) }()
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	if c.deleted {
+		return ErrDeleted
+	}
 
-	if c.lastChunk == "" || (c.lastChunkSize+uint64(len(msg)) > maxOnDiskChunkSize) {
-		c.lastChunk = fmt.Sprintf("%s-chunk%09d", c.instanceName, c.lastChunkIdx)
-		c.lastChunkIdx++
-		c.lastChunkSize = 0
-
-		if err := c.replicationStorage.Init(ctx, c.category, c.lastChunk); err != nil {
-			return fmt.Errorf("error before creating chunk %s, err %v", c.lastChunk, err)
+	now := time.Now()
+	messages := uint64(bytes.Count(msg, []byte{'\n'}))
+	for c.lastChunk == "" || c.settings.rollover(DefaultMaxChunkSize, c.lastChunkSize, c.lastChunkMessages, uint64(len(msg)), messages, c.lastChunkCreated, now) {
+		// the cluster is told about the next chunk without holding the lock, the reads and the
+		// other writes are not held up by the round trips to the coordination backend
+		idx := c.lastChunkIdx
+		next := fmt.Sprintf("%s-chunk%09d", c.instanceName, idx)
+		factor := c.settings.ReplicationFactor
+		c.mu.Unlock()
+		err := c.replicationStorage.Init(ctx, c.category, next, factor)
+		c.mu.Lock()
+		if c.deleted {
+			return ErrDeleted
 		}
+		if err != nil {
+			return fmt.Errorf("error before creating chunk %s, err %v", next, err)
+		}
+		if c.lastChunkIdx != idx {
+			// another write created the chunk in the meantime
+			continue
+		}
+		if err := c.sealLocked(); err != nil {
+			return err
+		}
+		c.lastChunk = next
+		c.lastChunkIdx++
+		c.lastChunkCreated = now
+		c.logger.Debug("created chunk", "chunk", c.lastChunk)
+		break
 	}
 
+	span.SetAttributes(attribute.String("chunk", c.lastChunk))
+
 	fp, err := c.getFilePointer(c.lastChunk, true)
 	if err != nil {
 		return fmt.Errorf("error while getting file pointer %v for chunk %s while writing", err, c.lastChunk)
 	}
-	_, err = fp.Write(msg)
-	if err != nil {
+	if n, err := fp.Write(msg); err != nil {
+		if n > 0 {
+			// do not leave a partial record behind, e.g. when the disk is full
+			if err := fp.Truncate(int64(c.lastChunkSize)); err == nil {
+				_, _ = fp.Seek(int64(c.lastChunkSize), io.SeekStart)
+			}
+		}
 		return fmt.Errorf("error while writing to file %v for chunk %s", err, c.lastChunk)
 	}
+	if c.settings.Fsync == FsyncAlways {
+		if err := fp.Sync(); err != nil {
+			return fmt.Errorf("error while syncing chunk %s, err %v", c.lastChunk, err)
+		}
+	}
+	c.recordProducer(c.lastChunk, c.lastChunkSize, span.SpanContext())
+	c.lastChunkCRC = crc32.Update(c.lastChunkCRC, crc32.IEEETable, msg)
 	c.lastChunkSize += uint64(len(msg))
+	c.lastChunkMessages += messages
 	return nil
 }
 
+// recordProducer remembers the trace context of the write at offset, it must be called with the lock held
+func (c *EventBusOnDisk) recordProducer(chunk string, offset uint64, sc trace.SpanContext) {
+	if !sc.IsValid() {
+		return
+	}
+	producers := append(c.producers[chunk], producer{offset: offset, span: sc})
+	if len(producers) > maxProducersPerChunk {
+		producers = producers[len(producers)-maxProducersPerChunk:]
+	}
+	c.producers[chunk] = producers
+}
+
+// Producers returns the trace context of the writes stored between the from and to offsets of the chunk,
+// only the writes handled by the current instance since it started are known, so the copies of the
+// chunks written by other instances have none
+func (c *EventBusOnDisk) Producers(chunk string, from, to uint64) []trace.SpanContext {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var spans []trace.SpanContext
+	for _, p := range c.producers[filepath.Clean(chunk)] {
+		if p.offset >= from && p.offset < to {
+			spans = append(spans, p.span)
+		}
+	}
+	return spans
+}
+
 // Read reads the chunk from the offset and writes to the writer
-func (c *EventBusOnDisk) Read(chunk string, offset, maxSize uint64, w io.Writer) error {
+func (c *EventBusOnDisk) Read(ctx context.Context, chunk string, offset, maxSize uint64, w io.Writer) (err error) {
+	_, span := tracing.Tracer().Start(ctx, "EventBusOnDisk.Read", trace.WithAttributes(
+		attribute.String("category", c.category),
+		attribute.String("chunk", chunk),
+		attribute.Int64("offset", int64(offset)),
+	))
+	defer func() { tracing.End(span, err) }()
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	chunk = filepath.Clean(chunk)
 
-	_, err := os.Stat(filepath.Join(c.dirname, chunk))
+	_, err = os.Stat(filepath.Join(c.dirname, chunk))
 	if err != nil {
 		return fmt.Errorf("chunk %s not found, err %v", chunk, err)
 	}
@@ -135,6 +347,7 @@ func (c *EventBusOnDisk) Ack(chunk string, size uint64) error {
 	if err := os.Remove(chunkFile); err != nil {
 		return fmt.Errorf("error while removing chunk %s, err %v", chunk, err)
 	}
+	c.removeManifest(chunk)
 	fp, ok := c.filePointers[chunk]
 	if ok {
 		if err := fp.Close(); err != nil {
@@ -142,6 +355,8 @@ func (c *EventBusOnDisk) Ack(chunk string, size uint64) error {
 		}
 	}
 	delete(c.filePointers, chunk)
+	delete(c.producers, chunk)
+	c.logger.Info("acked chunk", "chunk", chunk, "size", size)
 	return nil
 }
 
@@ -155,6 +370,9 @@ func (c *EventBusOnDisk) ListChunks() ([]chunk.Chunk, error) {
 		return nil, err
 	}
 	for _, file := range files {
+		if isMetadata(file.Name()) {
+			continue
+		}
 		file, err := file.Info()
 		if errors.Is(err, os.ErrNotExist) {
 			continue
@@ -167,6 +385,298 @@ func (c *EventBusOnDisk) ListChunks() ([]chunk.Chunk, error) {
 	return chunks, nil
 }
 
+// Stats describes the chunks of a category stored on disk
+type Stats struct {
+	Chunks          int
+	Bytes           uint64
+	ActiveChunkSize uint64
+	OpenFiles       int
+}
+
+// Stats returns the number and total size of the chunks, the size of the chunk currently written into
+// and the number of open file pointers
+func (c *EventBusOnDisk) Stats() (Stats, error) {
+	chunks, err := c.ListChunks()
+	if err != nil {
+		return Stats{}, err
+	}
+	stats := Stats{Chunks: len(chunks)}
+	for _, ch := range chunks {
+		stats.Bytes += ch.Size
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	stats.ActiveChunkSize = c.lastChunkSize
+	stats.OpenFiles = len(c.filePointers)
+	return stats, nil
+}
+
+// Close flushes the chunks to disk, closes their files and seals the chunk being written into,
+// the storage can still be used afterwards and reopens the files it needs
+func (c *EventBusOnDisk) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var firstErr error
+	if c.lastChunk != "" {
+		c.writeManifest()
+	}
+	for name, fp := range c.filePointers {
+		if err := fp.Sync(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("error while syncing chunk %s, err %v", name, err)
+		}
+		if err := fp.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("error while closing chunk %s, err %v", name, err)
+		}
+		delete(c.filePointers, name)
+	}
+	c.lastChunk = ""
+	c.lastChunkSize = 0
+	c.lastChunkMessages = 0
+	c.lastChunkCRC = 0
+	return firstErr
+}
+
+// Seal marks the chunk currently written into as complete, the next write starts a new chunk
+func (c *EventBusOnDisk) Seal() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.sealLocked(); err != nil {
+		c.logger.Error("error sealing chunk", "chunk", c.lastChunk, "error", err)
+	}
+}
+
+// sealLocked completes the chunk being written into, flushing it when the policy asks for it
+// and recording its manifest, it must be called with the lock held
+func (c *EventBusOnDisk) sealLocked() error {
+	if c.lastChunk != "" && c.settings.Fsync == FsyncOnSeal {
+		if fp, ok := c.filePointers[c.lastChunk]; ok {
+			if err := fp.Sync(); err != nil {
+				return fmt.Errorf("error while syncing chunk %s, err %v", c.lastChunk, err)
+			}
+		}
+	}
+	if c.lastChunk != "" {
+		c.writeManifest()
+	}
+	c.lastChunk = ""
+	c.lastChunkSize = 0
+	c.lastChunkMessages = 0
+	c.lastChunkCRC = 0
+	return nil
+}
+
+// RollOver completes the chunk being written into when it is older than the maximum age, which
+// writes only check when they come, it returns the completed chunk if any
+func (c *EventBusOnDisk) RollOver(now time.Time) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lastChunk == "" || c.settings.MaxChunkAge <= 0 || c.lastChunkSize == 0 || now.Sub(c.lastChunkCreated) < c.settings.MaxChunkAge {
+		return "", nil
+	}
+	chunk := c.lastChunk
+	if err := c.sealLocked(); err != nil {
+		return "", err
+	}
+	c.logger.Debug("rolled chunk over", "chunk", chunk, "reason", "age")
+	return chunk, nil
+}
+
+// Expire removes the complete chunks sealed before the given time whether they have been acked or
+// not, it returns the removed chunks. The time a chunk was sealed is recorded in its manifest, the
+// chunks without one, e.g. copies still in progress, count from when they were last written into.
+func (c *EventBusOnDisk) Expire(before time.Time) ([]chunk.Chunk, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	files, err := os.ReadDir(c.dirname)
+	if err != nil {
+		return nil, err
+	}
+	var expired []chunk.Chunk
+	for _, file := range files {
+		if file.Name() == c.lastChunk || isMetadata(file.Name()) {
+			continue
+		}
+		info, err := file.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return expired, fmt.Errorf("error while reading file/dir info %v", err)
+		}
+		sealedAt := info.ModTime()
+		if m, found, err := ReadManifest(c.dirname, file.Name()); err != nil {
+			c.logger.Warn("error reading manifest, using the modification time", "chunk", file.Name(), "error", err)
+		} else if found && !m.SealedAt.IsZero() {
+			sealedAt = m.SealedAt
+		}
+		if !sealedAt.Before(before) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.dirname, file.Name())); err != nil {
+			return expired, fmt.Errorf("error while removing chunk %s, err %v", file.Name(), err)
+		}
+		if fp, ok := c.filePointers[file.Name()]; ok {
+			_ = fp.Close()
+			delete(c.filePointers, file.Name())
+		}
+		delete(c.producers, file.Name())
+		c.removeManifest(file.Name())
+		expired = append(expired, chunk.Chunk{Name: file.Name(), Complete: true, Size: uint64(info.Size())})
+	}
+	return expired, nil
+}
+
+// Delete closes the storage once the writes in flight are done and removes the directory of the
+// category, the writes fail with ErrDeleted from then on
+func (c *EventBusOnDisk) Delete() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deleted = true
+	for name, fp := range c.filePointers {
+		_ = fp.Close()
+		delete(c.filePointers, name)
+	}
+	c.lastChunk = ""
+	c.lastChunkSize = 0
+	c.lastChunkMessages = 0
+	c.lastChunkCRC = 0
+	if err := os.RemoveAll(c.dirname); err != nil {
+		return fmt.Errorf("error while removing directory %s, err %v", c.dirname, err)
+	}
+	return nil
+}
+
+// Stat returns the size of the chunk on disk
+func (c *EventBusOnDisk) Stat(chunk string) (size uint64, exists bool, err error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	chunk = filepath.Clean(chunk)
+	fi, err := os.Stat(filepath.Join(c.dirname, chunk))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("error while getting stats of chunk %s, err %v", chunk, err)
+	}
+	return uint64(fi.Size()), true, nil
+}
+
+// WriteDirect appends the contents to the chunk as is, it is used to store chunks which are owned by other instances
+func (c *EventBusOnDisk) WriteDirect(chunk string, contents []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.deleted {
+		return ErrDeleted
+	}
+	chunk = filepath.Clean(chunk)
+	if owner, ok := ChunkOwner(chunk); !ok || owner == c.instanceName {
+		return fmt.Errorf("chunk %s cannot be written directly", chunk)
+	}
+	fp, err := os.OpenFile(filepath.Join(c.dirname, chunk), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return fmt.Errorf("error while opening file %s, err %v", chunk, err)
+	}
+	if _, err := fp.Write(contents); err != nil {
+		_ = fp.Close()
+		return fmt.Errorf("error while writing to file %v for chunk %s", err, chunk)
+	}
+	return fp.Close()
+}
+
+// CompleteCopy records the manifest of a chunk owned by another instance once it has been copied
+// completely, the time it was first completed is kept when the copy is checked again
+func (c *EventBusOnDisk) CompleteCopy(chunk string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	chunk = filepath.Clean(chunk)
+	if owner, ok := ChunkOwner(chunk); !ok || owner == c.instanceName {
+		return fmt.Errorf("chunk %s is not a copy", chunk)
+	}
+	if _, found, err := ReadManifest(c.dirname, chunk); err != nil || found {
+		return err
+	}
+	fp, err := os.Open(filepath.Join(c.dirname, chunk))
+	if errors.Is(err, os.ErrNotExist) {
+		// nothing was copied from an empty chunk
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("chunk %s not found, err %v", chunk, err)
+	}
+	defer fp.Close()
+	h := crc32.NewIEEE()
+	m := Manifest{SealedAt: time.Now().UTC()}
+	buff := make([]byte, 64*1024)
+	for {
+		n, err := fp.Read(buff)
+		h.Write(buff[:n])
+		m.Size += uint64(n)
+		m.Messages += uint64(bytes.Count(buff[:n], []byte{'\n'}))
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error while reading chunk %s, err %v", chunk, err)
+		}
+	}
+	m.CRC32 = h.Sum32()
+	if err := c.writeManifestFile(chunk, m); err != nil {
+		return fmt.Errorf("error while writing manifest of chunk %s, err %v", chunk, err)
+	}
+	return nil
+}
+
+// Checksum returns the crc32 checksum of the first size bytes of the chunk
+func (c *EventBusOnDisk) Checksum(chunk string, size uint64) (uint32, error) {
+	chunk = filepath.Clean(chunk)
+	// the file is opened separately so that a long read does not block writers
+	fp, err := os.Open(filepath.Join(c.dirname, chunk))
+	if err != nil {
+		return 0, fmt.Errorf("chunk %s not found, err %v", chunk, err)
+	}
+	defer fp.Close()
+	h := crc32.NewIEEE()
+	n, err := io.Copy(h, io.LimitReader(fp, int64(size)))
+	if err != nil {
+		return 0, fmt.Errorf("error while reading chunk %s, err %v", chunk, err)
+	}
+	if uint64(n) < size {
+		return 0, fmt.Errorf("chunk %s has only %d bytes, wanted %d", chunk, n, size)
+	}
+	return h.Sum32(), nil
+}
+
+// CountMessages counts the messages stored between the from and to offsets of the chunk and
+// returns when the chunk was last written into
+func (c *EventBusOnDisk) CountMessages(chunk string, from, to uint64) (uint64, time.Time, error) {
+	chunk = filepath.Clean(chunk)
+	fp, err := os.Open(filepath.Join(c.dirname, chunk))
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("chunk %s not found, err %v", chunk, err)
+	}
+	defer fp.Close()
+	fi, err := fp.Stat()
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("error while getting stats of chunk %s, err %v", chunk, err)
+	}
+	if to < from {
+		return 0, fi.ModTime(), nil
+	}
+	buff := make([]byte, 64*1024)
+	r := io.NewSectionReader(fp, int64(from), int64(to-from))
+	var messages uint64
+	for {
+		n, err := r.Read(buff)
+		messages += uint64(bytes.Count(buff[:n], []byte{'\n'}))
+		if err == io.EOF {
+			return messages, fi.ModTime(), nil
+		}
+		if err != nil {
+			return 0, time.Time{}, fmt.Errorf("error while reading chunk %s, err %v", chunk, err)
+		}
+	}
+}
+
 func (c *EventBusOnDisk) getFilePointer(chunk string, write bool) (*os.File, error) {
 	fp, ok := c.filePointers[chunk]
 	if ok {
@@ -3,9 +3,13 @@ package manager
 import (
 	"bytes"
 	"context"
+	"errors"
+	"hash/crc32"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestInitialiseOnDisk(t *testing.T) {
@@ -91,7 +95,7 @@ func TestReadAndWriteOnDisk(t *testing.T) {
 	}
 	chunk := chunks[0].Name
 	var b bytes.Buffer
-	if err := onDisk.Read(chunk, 0, 100, &b); err != nil {
+	if err := onDisk.Read(context.Background(), chunk, 0, 100, &b); err != nil {
 		t.Fatalf("error while reading %v", err)
 	}
 	got := b.String()
@@ -131,10 +135,54 @@ func TestAckLastChunk(t *testing.T) {
 
 type nilHook struct{}
 
-func (n *nilHook) Init(ctx context.Context, category, fileName string) error {
+func (n *nilHook) Init(ctx context.Context, category, fileName string, replicationFactor int) error {
 	return nil
 }
 
+// blockingHook holds up the creation of the chunks until release is closed
+type blockingHook struct {
+	started chan string
+	release chan struct{}
+}
+
+func (b *blockingHook) Init(ctx context.Context, category, fileName string, replicationFactor int) error {
+	b.started <- fileName
+	<-b.release
+	return nil
+}
+
+func TestInitOutsideOfLock(t *testing.T) {
+	hook := &blockingHook{started: make(chan string, 2), release: make(chan struct{})}
+	onDisk, err := NewEventBusOnDisk(getTempDir(t), "test", "luffy", hook)
+	if err != nil {
+		t.Fatalf("error while creating on disk %v", err)
+	}
+	errs := make(chan error, 2)
+	for _, msg := range []string{"one\n", "two\n"} {
+		go func(msg string) { errs <- onDisk.Write(context.Background(), []byte(msg)) }(msg)
+	}
+	if name := <-hook.started; name != "luffy-chunk000000000" {
+		t.Errorf("got chunk %s initialised", name)
+	}
+	// the storage is not locked while the cluster is told about the chunk
+	if _, err := onDisk.ListChunks(); err != nil {
+		t.Fatalf("error while listing chunks %v", err)
+	}
+	close(hook.release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("error while writing %v", err)
+		}
+	}
+	chunks, err := onDisk.ListChunks()
+	if err != nil {
+		t.Fatalf("error while listing chunks %v", err)
+	}
+	if len(chunks) != 1 || chunks[0].Name != "luffy-chunk000000000" || chunks[0].Size != 8 {
+		t.Errorf("got chunks %+v, want both writes in one chunk", chunks)
+	}
+}
+
 func testNewOnDisk(t *testing.T, dir string) *EventBusOnDisk {
 	t.Helper()
 	onDisk, err := NewEventBusOnDisk(dir, "test", "luffy", &nilHook{})
@@ -155,3 +203,353 @@ func getTempDir(t *testing.T) string {
 	})
 	return dir
 }
+
+func TestChunkOwner(t *testing.T) {
+	testCases := []struct {
+		name  string
+		owner string
+		valid bool
+	}{
+		{name: "luffy-chunk000000001", owner: "luffy", valid: true},
+		{name: "straw-hat-chunk000000001", owner: "straw-hat", valid: true},
+		{name: "luffy-chunk", valid: false},
+		{name: "chunk000000001", valid: false},
+		{name: "luffy-chunk0001.tmp", valid: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			owner, ok := ChunkOwner(tc.name)
+			if ok != tc.valid || owner != tc.owner {
+				t.Errorf("got (%q, %v) want (%q, %v)", owner, ok, tc.owner, tc.valid)
+			}
+		})
+	}
+}
+
+func TestWriteDirect(t *testing.T) {
+	dir := getTempDir(t)
+	onDisk := testNewOnDisk(t, dir)
+
+	if err := onDisk.WriteDirect("luffy-chunk000000001", []byte("one\n")); err == nil {
+		t.Fatalf("no error while writing directly to an owned chunk")
+	}
+
+	for _, part := range []string{"one\n", "two\n"} {
+		if err := onDisk.WriteDirect("zoro-chunk000000001", []byte(part)); err != nil {
+			t.Fatalf("error while writing directly %v", err)
+		}
+	}
+	size, exists, err := onDisk.Stat("zoro-chunk000000001")
+	if err != nil {
+		t.Fatalf("error while getting stats %v", err)
+	}
+	if !exists || size != uint64(len("one\ntwo\n")) {
+		t.Errorf("got size %d exists %v want size %d", size, exists, len("one\ntwo\n"))
+	}
+	if onDisk.lastChunkIdx != 0 {
+		t.Errorf("replicated chunk changed lastChunkIdx to %d", onDisk.lastChunkIdx)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	dir := getTempDir(t)
+	onDisk := testNewOnDisk(t, dir)
+	if err := onDisk.WriteDirect("zoro-chunk000000001", []byte("one\ntwo\n")); err != nil {
+		t.Fatalf("error while writing directly %v", err)
+	}
+
+	full, err := onDisk.Checksum("zoro-chunk000000001", 8)
+	if err != nil {
+		t.Fatalf("error while computing checksum %v", err)
+	}
+	prefix, err := onDisk.Checksum("zoro-chunk000000001", 4)
+	if err != nil {
+		t.Fatalf("error while computing checksum %v", err)
+	}
+	if full == prefix {
+		t.Errorf("checksum of the whole chunk equals the checksum of its prefix")
+	}
+	if _, err := onDisk.Checksum("zoro-chunk000000001", 9); err == nil {
+		t.Errorf("no error while computing checksum beyond the end of the chunk")
+	}
+}
+
+func TestSealLastChunk(t *testing.T) {
+	onDisk := testNewOnDisk(t, getTempDir(t))
+	if err := onDisk.Write(context.Background(), []byte("one\n")); err != nil {
+		t.Fatalf("error while writing %v", err)
+	}
+	onDisk.Seal()
+
+	chunks, err := onDisk.ListChunks()
+	if err != nil {
+		t.Fatalf("error while listing chunks %v", err)
+	}
+	if len(chunks) != 1 || !chunks[0].Complete {
+		t.Fatalf("sealed chunk is not complete %+v", chunks)
+	}
+	if err := onDisk.Ack(chunks[0].Name, chunks[0].Size); err != nil {
+		t.Errorf("error while acking sealed chunk %v", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	onDisk := testNewOnDisk(t, getTempDir(t))
+	if err := onDisk.Write(context.Background(), []byte("one\n")); err != nil {
+		t.Fatalf("error while writing %v", err)
+	}
+	onDisk.Seal()
+	if err := onDisk.Write(context.Background(), []byte("two\nthree\n")); err != nil {
+		t.Fatalf("error while writing %v", err)
+	}
+
+	stats, err := onDisk.Stats()
+	if err != nil {
+		t.Fatalf("error while getting stats %v", err)
+	}
+	want := Stats{Chunks: 2, Bytes: 14, ActiveChunkSize: 10, OpenFiles: 2}
+	if stats != want {
+		t.Errorf("Stats() = %+v, want %+v", stats, want)
+	}
+}
+
+func TestCountMessages(t *testing.T) {
+	onDisk := testNewOnDisk(t, getTempDir(t))
+	if err := onDisk.Write(context.Background(), []byte("one\ntwo\nthree\n")); err != nil {
+		t.Fatalf("error while writing %v", err)
+	}
+	chunks, err := onDisk.ListChunks()
+	if err != nil {
+		t.Fatalf("error while listing chunks %v", err)
+	}
+
+	testCases := []struct {
+		from, to uint64
+		want     uint64
+	}{
+		{from: 0, to: 14, want: 3},
+		{from: 4, to: 14, want: 2},
+		{from: 0, to: 5, want: 1},
+		{from: 0, to: 100, want: 3},
+		{from: 14, to: 14, want: 0},
+	}
+	for _, tc := range testCases {
+		got, modTime, err := onDisk.CountMessages(chunks[0].Name, tc.from, tc.to)
+		if err != nil {
+			t.Fatalf("error while counting messages %v", err)
+		}
+		if got != tc.want {
+			t.Errorf("CountMessages(%d, %d) = %d, want %d", tc.from, tc.to, got, tc.want)
+		}
+		if modTime.IsZero() {
+			t.Errorf("CountMessages(%d, %d) returned no modification time", tc.from, tc.to)
+		}
+	}
+}
+
+func TestClose(t *testing.T) {
+	onDisk := testNewOnDisk(t, getTempDir(t))
+	if err := onDisk.Write(context.Background(), []byte("one\n")); err != nil {
+		t.Fatalf("error while writing %v", err)
+	}
+	if err := onDisk.Close(); err != nil {
+		t.Fatalf("error while closing %v", err)
+	}
+
+	stats, err := onDisk.Stats()
+	if err != nil {
+		t.Fatalf("error while getting stats %v", err)
+	}
+	want := Stats{Chunks: 1, Bytes: 4, ActiveChunkSize: 0, OpenFiles: 0}
+	if stats != want {
+		t.Errorf("Stats() = %+v, want %+v", stats, want)
+	}
+	chunks, err := onDisk.ListChunks()
+	if err != nil {
+		t.Fatalf("error while listing chunks %v", err)
+	}
+	if len(chunks) != 1 || !chunks[0].Complete {
+		t.Errorf("chunk written before closing is not complete: %+v", chunks)
+	}
+}
+
+func TestMaxChunkSize(t *testing.T) {
+	dir := getTempDir(t)
+	onDisk, err := NewEventBusOnDisk(dir, "test", "luffy", &nilHook{}, WithSettings(Settings{MaxChunkSize: 8, Fsync: FsyncAlways}))
+	if err != nil {
+		t.Fatalf("error while creating on disk %v", err)
+	}
+	for _, msg := range []string{"one\n", "two\n", "three\n"} {
+		if err := onDisk.Write(context.Background(), []byte(msg)); err != nil {
+			t.Fatalf("error while writing %v", err)
+		}
+	}
+	chunks, err := onDisk.ListChunks()
+	if err != nil {
+		t.Fatalf("error while listing chunks %v", err)
+	}
+	if len(chunks) != 2 || chunks[0].Size != 8 || !chunks[0].Complete || chunks[1].Size != 6 {
+		t.Errorf("got chunks %+v", chunks)
+	}
+}
+
+func TestRollOver(t *testing.T) {
+	dir := getTempDir(t)
+	onDisk, err := NewEventBusOnDisk(dir, "test", "luffy", &nilHook{}, WithSettings(Settings{MaxChunkMessages: 3, MaxChunkAge: time.Minute}))
+	if err != nil {
+		t.Fatalf("error while creating on disk %v", err)
+	}
+	for _, msg := range []string{"one\ntwo\n", "three\n", "four\n"} {
+		if err := onDisk.Write(context.Background(), []byte(msg)); err != nil {
+			t.Fatalf("error while writing %v", err)
+		}
+	}
+	chunks, err := onDisk.ListChunks()
+	if err != nil {
+		t.Fatalf("error while listing chunks %v", err)
+	}
+	if len(chunks) != 2 || chunks[0].Size != 14 || !chunks[0].Complete || chunks[1].Complete {
+		t.Fatalf("got chunks %+v", chunks)
+	}
+
+	if chunk, err := onDisk.RollOver(time.Now()); err != nil || chunk != "" {
+		t.Errorf("rolled over a new chunk %q, err %v", chunk, err)
+	}
+	chunk, err := onDisk.RollOver(time.Now().Add(time.Minute))
+	if err != nil || chunk != chunks[1].Name {
+		t.Fatalf("rolled over %q instead of %s, err %v", chunk, chunks[1].Name, err)
+	}
+	if err := onDisk.Ack(chunk, 5); err != nil {
+		t.Errorf("error while acking the chunk rolled over %v", err)
+	}
+	if chunk, err := onDisk.RollOver(time.Now().Add(time.Hour)); err != nil || chunk != "" {
+		t.Errorf("rolled over %q without an active chunk, err %v", chunk, err)
+	}
+}
+
+func TestRollOverBeforeBatch(t *testing.T) {
+	dir := getTempDir(t)
+	onDisk, err := NewEventBusOnDisk(dir, "test", "luffy", &nilHook{}, WithSettings(Settings{MaxChunkMessages: 3}))
+	if err != nil {
+		t.Fatalf("error while creating on disk %v", err)
+	}
+	// the second batch would take the chunk over the limit, the third one is larger than the limit
+	for _, msg := range []string{"one\ntwo\n", "three\nfour\n", "five\nsix\nseven\neight\n"} {
+		if err := onDisk.Write(context.Background(), []byte(msg)); err != nil {
+			t.Fatalf("error while writing %v", err)
+		}
+	}
+	chunks, err := onDisk.ListChunks()
+	if err != nil {
+		t.Fatalf("error while listing chunks %v", err)
+	}
+	var sizes []uint64
+	for _, ch := range chunks {
+		sizes = append(sizes, ch.Size)
+	}
+	if want := []uint64{8, 11, 21}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("got chunk sizes %v want %v", sizes, want)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	dir := getTempDir(t)
+	onDisk := testNewOnDisk(t, dir)
+	for _, msg := range []string{"one\n", "two\n"} {
+		if err := onDisk.Write(context.Background(), []byte(msg)); err != nil {
+			t.Fatalf("error while writing %v", err)
+		}
+		onDisk.Seal()
+	}
+	if err := onDisk.Write(context.Background(), []byte("three\n")); err != nil {
+		t.Fatalf("error while writing %v", err)
+	}
+	if err := onDisk.WriteDirect("zoro-chunk000000000", []byte("four\n")); err != nil {
+		t.Fatalf("error while writing copy %v", err)
+	}
+	old := time.Now().Add(-time.Hour)
+	m, _, err := ReadManifest(dir, "luffy-chunk000000000")
+	if err != nil {
+		t.Fatalf("error while reading manifest %v", err)
+	}
+	m.SealedAt = old
+	if err := onDisk.writeManifestFile("luffy-chunk000000000", m); err != nil {
+		t.Fatalf("error while writing manifest %v", err)
+	}
+	// the time a chunk was sealed wins over the time it was last modified, which the chunks
+	// without a manifest fall back to
+	for _, name := range []string{"luffy-chunk000000001", "luffy-chunk000000002", "zoro-chunk000000000"} {
+		if err := os.Chtimes(filepath.Join(dir, name), old, old); err != nil {
+			t.Fatalf("error while changing times %v", err)
+		}
+	}
+
+	expired, err := onDisk.Expire(time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("error while expiring %v", err)
+	}
+	// the chunk being written into is kept however old it is
+	var names []string
+	for _, ch := range expired {
+		names = append(names, ch.Name)
+	}
+	if want := []string{"luffy-chunk000000000", "zoro-chunk000000000"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got expired chunks %v want %v", names, want)
+	}
+	chunks, err := onDisk.ListChunks()
+	if err != nil {
+		t.Fatalf("error while listing chunks %v", err)
+	}
+	if len(chunks) != 2 {
+		t.Errorf("got chunks %+v", chunks)
+	}
+}
+
+func TestCompleteCopy(t *testing.T) {
+	dir := getTempDir(t)
+	onDisk := testNewOnDisk(t, dir)
+	if err := onDisk.WriteDirect("zoro-chunk000000000", []byte("one\ntwo\n")); err != nil {
+		t.Fatalf("error while writing copy %v", err)
+	}
+	if err := onDisk.CompleteCopy("zoro-chunk000000000"); err != nil {
+		t.Fatalf("error while completing copy %v", err)
+	}
+	m, found, err := ReadManifest(dir, "zoro-chunk000000000")
+	if err != nil || !found {
+		t.Fatalf("got manifest found %v error %v", found, err)
+	}
+	if m.Size != 8 || m.Messages != 2 || m.CRC32 != crc32.ChecksumIEEE([]byte("one\ntwo\n")) || m.SealedAt.IsZero() {
+		t.Errorf("got manifest %+v", m)
+	}
+
+	// checking the copy again keeps the time it was completed
+	if err := onDisk.CompleteCopy("zoro-chunk000000000"); err != nil {
+		t.Fatalf("error while completing copy %v", err)
+	}
+	if again, _, _ := ReadManifest(dir, "zoro-chunk000000000"); !again.SealedAt.Equal(m.SealedAt) {
+		t.Errorf("got sealed at %v want %v", again.SealedAt, m.SealedAt)
+	}
+	if err := onDisk.CompleteCopy("luffy-chunk000000000"); err == nil {
+		t.Errorf("expected an error completing a chunk of the instance")
+	}
+}
+
+func TestDelete(t *testing.T) {
+	dir := getTempDir(t)
+	onDisk := testNewOnDisk(t, dir)
+	if err := onDisk.Write(context.Background(), []byte("one\n")); err != nil {
+		t.Fatalf("error while writing %v", err)
+	}
+	if err := onDisk.Delete(); err != nil {
+		t.Fatalf("error while deleting %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("directory still exists %v", err)
+	}
+	if err := onDisk.Write(context.Background(), []byte("two\n")); !errors.Is(err, ErrDeleted) {
+		t.Errorf("Write() = %v, want %v", err, ErrDeleted)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("directory created again by a write %v", err)
+	}
+}
@@ -1,46 +1,66 @@
 package manager
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"github.com/Vignesh-Rajarajan/event-bus/chunk"
 	"io"
 	"sync"
+	"time"
 )
 
+// maxInMemChunkSize is the size above which a new chunk is started unless configured otherwise
 const maxInMemChunkSize = 10 * 1024 * 1024
 
 // EventBusInMemory is an implementation of EventManager which stores the events in memory
 type EventBusInMemory struct {
-	mu            sync.RWMutex
-	lastChunkName string
-	lastChunkSize uint64
-	lastChunkIdx  uint64
-	buffs         map[string][]byte
+	settings          Settings
+	mu                sync.RWMutex
+	lastChunkName     string
+	lastChunkSize     uint64
+	lastChunkIdx      uint64
+	lastChunkMessages uint64
+	lastChunkCreated  time.Time
+	buffs             map[string][]byte
 }
 
 var _ EventManager = (*EventBusInMemory)(nil)
 
+// NewEventBusInMemory creates a new event bus in memory, WithSettings configures when a new chunk is started
+func NewEventBusInMemory(opts ...Option) *EventBusInMemory {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &EventBusInMemory{settings: o.settings, buffs: make(map[string][]byte)}
+}
+
 // Write writes the message to the last chunk
 func (c *EventBusInMemory) Write(ctx context.Context, msg []byte) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.lastChunkName == "" || (c.lastChunkSize+uint64(len(msg)) > maxInMemChunkSize) {
+	now := time.Now()
+	messages := uint64(bytes.Count(msg, []byte{'\n'}))
+	if c.lastChunkName == "" || c.settings.rollover(maxInMemChunkSize, c.lastChunkSize, c.lastChunkMessages, uint64(len(msg)), messages, c.lastChunkCreated, now) {
 		c.lastChunkName = fmt.Sprintf("chunk-%d", c.lastChunkIdx)
 		c.lastChunkIdx++
 		c.lastChunkSize = 0
+		c.lastChunkMessages = 0
+		c.lastChunkCreated = now
 	}
 	if c.buffs == nil {
 		c.buffs = make(map[string][]byte)
 	}
 	c.buffs[c.lastChunkName] = append(c.buffs[c.lastChunkName], msg...)
 	c.lastChunkSize += uint64(len(msg))
+	c.lastChunkMessages += messages
 	return nil
 }
 
 // Read reads the message from the chunk
-func (c *EventBusInMemory) Read(chunk string, offset, maxSize uint64, w io.Writer) error {
+func (c *EventBusInMemory) Read(ctx context.Context, chunk string, offset, maxSize uint64, w io.Writer) error {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
@@ -85,6 +105,16 @@ func (c *EventBusInMemory) Ack(chunk string, size uint64) error {
 	return nil
 }
 
+// Close completes the chunk being written into, the events stay in memory
+func (c *EventBusInMemory) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastChunkName = ""
+	c.lastChunkSize = 0
+	c.lastChunkMessages = 0
+	return nil
+}
+
 // ListChunks lists all the chunks
 func (c *EventBusInMemory) ListChunks() ([]chunk.Chunk, error) {
 	c.mu.RLock()
@@ -0,0 +1,167 @@
+package manager
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// The functions of this file work on the chunk files directly, they are meant to be used while
+// the instance owning the directory is stopped.
+
+// ChunkReport is the outcome of the offline verification of a chunk file
+type ChunkReport struct {
+	Name string `json:"name"`
+	// Owner is the instance which wrote the chunk, it is empty when the name is not valid
+	Owner   string `json:"owner,omitempty"`
+	Size    uint64 `json:"size"`
+	Records uint64 `json:"records"`
+	// ValidSize is where the last valid record ends, the bytes after it are torn or corrupted
+	ValidSize uint64    `json:"validSize"`
+	Manifest  *Manifest `json:"manifest,omitempty"`
+	// ChecksumValid tells whether the chunk still holds what its manifest describes, it is nil
+	// without a manifest
+	ChecksumValid *bool    `json:"checksumValid,omitempty"`
+	Problems      []string `json:"problems,omitempty"`
+}
+
+// OK tells whether no problem was found
+func (r ChunkReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *ChunkReport) problem(format string, args ...any) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// ChunkFiles lists the chunk files of a category directory sorted by name, leaving the manifests out
+func ChunkFiles(dirname string) ([]string, error) {
+	entries, err := os.ReadDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && !isMetadata(e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ScanRecords calls fn with the offset and the contents of every record read from r. A record ends
+// with a newline and may hold any other byte, so only an incomplete final record, e.g. a write
+// interrupted by a crash, is invalid. It stops at the final incomplete record, or when fn fails,
+// and returns the offset where the complete records end.
+func ScanRecords(r io.Reader, fn func(offset uint64, record []byte) error) (uint64, error) {
+	br := bufio.NewReader(r)
+	var offset uint64
+	for {
+		record, err := br.ReadBytes('\n')
+		if err == nil {
+			if err := fn(offset, record); err != nil {
+				return offset, err
+			}
+			offset += uint64(len(record))
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			return offset, nil
+		}
+		return offset, fmt.Errorf("error while reading records at offset %d, err %v", offset, err)
+	}
+}
+
+// InspectChunk verifies the name of the chunk file, counts its records, looks for a torn or corrupted
+// trailing record and checks the chunk against its manifest when it has one
+func InspectChunk(path string) (ChunkReport, error) {
+	report := ChunkReport{Name: filepath.Base(path)}
+	if owner, ok := ChunkOwner(report.Name); ok {
+		report.Owner = owner
+	} else {
+		report.problem("name does not match <instance>-chunk<number>")
+	}
+
+	fp, err := os.Open(path)
+	if err != nil {
+		return report, err
+	}
+	defer fp.Close()
+	fi, err := fp.Stat()
+	if err != nil {
+		return report, err
+	}
+	report.Size = uint64(fi.Size())
+	report.ValidSize, err = ScanRecords(fp, func(uint64, []byte) error {
+		report.Records++
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	if report.ValidSize < report.Size {
+		report.problem("%d bytes after offset %d are not a valid record", report.Size-report.ValidSize, report.ValidSize)
+	}
+
+	m, found, err := ReadManifest(filepath.Dir(path), report.Name)
+	if err != nil {
+		report.problem("%v", err)
+		return report, nil
+	}
+	if !found {
+		return report, nil
+	}
+	report.Manifest = &m
+	valid := false
+	if report.Size < m.Size {
+		report.problem("chunk has %d bytes but had %d when it was completed", report.Size, m.Size)
+	} else {
+		h := crc32.NewIEEE()
+		if _, err := io.Copy(h, io.NewSectionReader(fp, 0, int64(m.Size))); err != nil {
+			return report, fmt.Errorf("error while reading chunk %s, err %v", report.Name, err)
+		}
+		valid = h.Sum32() == m.CRC32
+		switch {
+		case !valid:
+			report.problem("checksum %08x of the first %d bytes does not match %08x recorded when the chunk was completed", h.Sum32(), m.Size, m.CRC32)
+		case report.Size > m.Size:
+			report.problem("%d bytes were added after the chunk was completed", report.Size-m.Size)
+		}
+	}
+	report.ChecksumValid = &valid
+	return report, nil
+}
+
+// ErrNotRepairable is returned when the chunk is corrupted before its end, it must then be
+// restored from a replica
+var ErrNotRepairable = errors.New("chunk is corrupted before its end")
+
+// RepairChunk truncates the chunk to its last valid record, or to the size recorded in its manifest
+// when it has one and still matches it. It returns the size of the chunk once repaired and only
+// reports it with dryRun.
+func RepairChunk(path string, dryRun bool) (uint64, error) {
+	report, err := InspectChunk(path)
+	if err != nil {
+		return 0, err
+	}
+	size := report.ValidSize
+	if report.Manifest != nil {
+		if !*report.ChecksumValid {
+			return report.Size, fmt.Errorf("%w %s, it does not match its manifest anymore", ErrNotRepairable, report.Name)
+		}
+		size = report.Manifest.Size
+	}
+	if size >= report.Size || dryRun {
+		return min(size, report.Size), nil
+	}
+	if err := os.Truncate(path, int64(size)); err != nil {
+		return report.Size, fmt.Errorf("error while truncating chunk %s, err %v", report.Name, err)
+	}
+	return size, nil
+}
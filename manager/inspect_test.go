@@ -0,0 +1,105 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInspectAndRepair(t *testing.T) {
+	dir := getTempDir(t)
+	onDisk := testNewOnDisk(t, dir)
+	for _, msg := range []string{"1\n2\n", "3\n"} {
+		if err := onDisk.Write(context.Background(), []byte(msg)); err != nil {
+			t.Fatalf("error while writing %v", err)
+		}
+	}
+	onDisk.Seal()
+	sealed := filepath.Join(dir, "luffy-chunk000000000")
+	m, found, err := ReadManifest(dir, "luffy-chunk000000000")
+	if err != nil || !found || m.Size != 6 || m.Messages != 3 {
+		t.Fatalf("got manifest %+v found %v err %v", m, found, err)
+	}
+	if chunks, err := onDisk.ListChunks(); err != nil || len(chunks) != 1 {
+		t.Errorf("manifest listed as a chunk %+v, err %v", chunks, err)
+	}
+	if report, err := InspectChunk(sealed); err != nil || !report.OK() || report.Records != 3 || !*report.ChecksumValid {
+		t.Errorf("got report %+v err %v for a sealed chunk", report, err)
+	}
+
+	// a crash extended the chunk without writing into it
+	appendFile(t, sealed, "4\x00\x00")
+	report, err := InspectChunk(sealed)
+	if err != nil || report.OK() || report.ValidSize != 6 || !*report.ChecksumValid {
+		t.Errorf("got report %+v err %v for a torn chunk", report, err)
+	}
+	if size, err := RepairChunk(sealed, true); err != nil || size != 6 {
+		t.Errorf("dry run repaired to %d err %v", size, err)
+	}
+	if size, err := RepairChunk(sealed, false); err != nil || size != 6 {
+		t.Errorf("repaired to %d err %v", size, err)
+	}
+	if report, err := InspectChunk(sealed); err != nil || !report.OK() {
+		t.Errorf("got report %+v err %v after the repair", report, err)
+	}
+
+	// without a manifest the chunk is truncated to its last complete record
+	torn := filepath.Join(dir, "zoro-chunk000000000")
+	appendFile(t, torn, "7\n8")
+	if size, err := RepairChunk(torn, false); err != nil || size != 2 {
+		t.Errorf("repaired to %d err %v", size, err)
+	}
+
+	if err := os.WriteFile(sealed, []byte("1\n9\n3\n"), 0666); err != nil {
+		t.Fatalf("error while corrupting chunk %v", err)
+	}
+	if _, err := RepairChunk(sealed, false); !errors.Is(err, ErrNotRepairable) {
+		t.Errorf("repaired a chunk corrupted in its middle, err %v", err)
+	}
+	if report, err := InspectChunk(filepath.Join(dir, "chunk1")); err == nil || report.Owner != "" || report.OK() {
+		t.Errorf("got report %+v err %v for a missing chunk with an invalid name", report, err)
+	}
+
+	if err := onDisk.Ack("luffy-chunk000000000", 6); err != nil {
+		t.Fatalf("error while acking %v", err)
+	}
+	if _, found, _ := ReadManifest(dir, "luffy-chunk000000000"); found {
+		t.Errorf("manifest of the acked chunk is still there")
+	}
+}
+
+func TestRecordsHoldingNUL(t *testing.T) {
+	dir := getTempDir(t)
+	onDisk := testNewOnDisk(t, dir)
+	// a copy has no manifest until it is complete, the records are all the repair goes by
+	copied := filepath.Join(dir, "zoro-chunk000000000")
+	if err := onDisk.WriteDirect("zoro-chunk000000000", []byte("1\n\x00\x002\n3\x00\n")); err != nil {
+		t.Fatalf("error while writing copy %v", err)
+	}
+	if report, err := InspectChunk(copied); err != nil || !report.OK() || report.Records != 3 || report.ValidSize != 9 {
+		t.Errorf("got report %+v err %v for records holding NUL bytes", report, err)
+	}
+	if size, err := RepairChunk(copied, false); err != nil || size != 9 {
+		t.Errorf("repaired to %d err %v", size, err)
+	}
+
+	// only the final record without a newline is torn
+	appendFile(t, copied, "4\x00")
+	if report, err := InspectChunk(copied); err != nil || report.OK() || report.ValidSize != 9 {
+		t.Errorf("got report %+v err %v for a torn chunk", report, err)
+	}
+}
+
+func appendFile(t *testing.T, path, contents string) {
+	t.Helper()
+	fp, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("error while opening %s %v", path, err)
+	}
+	defer fp.Close()
+	if _, err := fp.WriteString(contents); err != nil {
+		t.Fatalf("error while writing %s %v", path, err)
+	}
+}
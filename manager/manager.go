@@ -9,12 +9,17 @@ import (
 )
 
 type EventManager interface {
-	Read(chunk string, offset, maxSize uint64, w io.Writer) error
+	Read(ctx context.Context, chunk string, offset, maxSize uint64, w io.Writer) error
 	Write(ctx context.Context, body []byte) error
 	Ack(chunk string, size uint64) error
 	ListChunks() ([]chunk.Chunk, error)
+	// Close makes the stored events durable and completes the chunk being written into
+	Close() error
 }
 
+// ErrBufferTooSmall is returned by the reads whose maximum size cannot hold the next message
+var ErrBufferTooSmall = errors.New("buffer too small")
+
 func getTillLastDelimiter(temp []byte) (truncated []byte, rest []byte, err error) {
 	n := len(temp)
 	if n == 0 {
@@ -27,7 +32,7 @@ func getTillLastDelimiter(temp []byte) (truncated []byte, rest []byte, err error
 
 	lastIdx := bytes.LastIndexByte(temp, '\n')
 	if lastIdx < 0 {
-		return nil, nil, errors.New("buffer too small")
+		return nil, nil, ErrBufferTooSmall
 	}
 	return temp[:lastIdx+1], temp[lastIdx+1:], nil
 }
@@ -2,6 +2,7 @@ package manager
 
 import (
 	"bytes"
+	"context"
 	"testing"
 )
 
@@ -23,3 +24,21 @@ func TestInvalidMessage(t *testing.T) {
 		t.Fatalf("want error got nil")
 	}
 }
+
+func TestInMemoryRollOver(t *testing.T) {
+	inMem := NewEventBusInMemory(WithSettings(Settings{MaxChunkMessages: 2}))
+	for _, msg := range []string{"1\n", "2\n3\n", "4\n"} {
+		if err := inMem.Write(context.Background(), []byte(msg)); err != nil {
+			t.Fatalf("error while writing %v", err)
+		}
+	}
+	for chunk, want := range map[string]string{"chunk-0": "1\n", "chunk-1": "2\n3\n", "chunk-2": "4\n"} {
+		var buf bytes.Buffer
+		if err := inMem.Read(context.Background(), chunk, 0, 1024, &buf); err != nil {
+			t.Fatalf("error while reading %s %v", chunk, err)
+		}
+		if buf.String() != want {
+			t.Errorf("got %q in %s want %q", buf.String(), chunk, want)
+		}
+	}
+}
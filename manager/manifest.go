@@ -0,0 +1,107 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// manifestSuffix ends the name of the manifests, which start with a dot so that they are not
+// listed as chunks
+const manifestSuffix = ".manifest"
+
+// Manifest describes a chunk when it was completed, it is stored next to the chunk so that the chunk
+// can be verified offline. The copies of the chunks written by other instances get one once they
+// are completely copied.
+type Manifest struct {
+	Size     uint64 `json:"size"`
+	Messages uint64 `json:"messages"`
+	// CRC32 is the IEEE checksum of the Size bytes of the chunk
+	CRC32 uint32 `json:"crc32"`
+	// SealedAt is when the chunk, or its copy, was completed, the retention counts from it
+	SealedAt time.Time `json:"sealedAt,omitempty"`
+}
+
+// ManifestName returns the name of the file holding the manifest of the chunk
+func ManifestName(chunk string) string {
+	return "." + chunk + manifestSuffix
+}
+
+// isMetadata tells whether the file of the category directory is not a chunk, the manifests
+// and their temporary files start with a dot
+func isMetadata(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// ReadManifest returns the manifest of the chunk stored in the directory, if it has one
+func ReadManifest(dirname, chunk string) (Manifest, bool, error) {
+	b, err := os.ReadFile(filepath.Join(dirname, ManifestName(chunk)))
+	if errors.Is(err, os.ErrNotExist) {
+		return Manifest{}, false, nil
+	}
+	if err != nil {
+		return Manifest{}, false, fmt.Errorf("error while reading manifest of chunk %s, err %v", chunk, err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return Manifest{}, false, fmt.Errorf("invalid manifest of chunk %s, err %v", chunk, err)
+	}
+	return m, true, nil
+}
+
+// writeManifest records the manifest of the chunk being written into, it must be called with the
+// lock held. The manifest is only an aid to verify the chunk, an error is logged and ignored.
+func (c *EventBusOnDisk) writeManifest() {
+	m := Manifest{Size: c.lastChunkSize, Messages: c.lastChunkMessages, CRC32: c.lastChunkCRC, SealedAt: time.Now().UTC()}
+	if err := c.writeManifestFile(c.lastChunk, m); err != nil {
+		c.logger.Warn("error writing manifest", "chunk", c.lastChunk, "error", err)
+	}
+}
+
+func (c *EventBusOnDisk) writeManifestFile(chunk string, m Manifest) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	// written aside, flushed and renamed so that a crash never leaves a partial manifest
+	tmp := filepath.Join(c.dirname, ManifestName(chunk)+".tmp")
+	fp, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := fp.Write(b); err != nil {
+		_ = fp.Close()
+		return err
+	}
+	if err := fp.Sync(); err != nil {
+		_ = fp.Close()
+		return err
+	}
+	if err := fp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(c.dirname, ManifestName(chunk))); err != nil {
+		return err
+	}
+	return syncDir(c.dirname)
+}
+
+// syncDir flushes the entries of the directory, so that a file renamed into it survives a crash
+func syncDir(dirname string) error {
+	dir, err := os.Open(dirname)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+func (c *EventBusOnDisk) removeManifest(chunk string) {
+	if err := os.Remove(filepath.Join(c.dirname, ManifestName(chunk))); err != nil && !errors.Is(err, os.ErrNotExist) {
+		c.logger.Warn("error removing manifest", "chunk", chunk, "error", err)
+	}
+}
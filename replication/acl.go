@@ -0,0 +1,138 @@
+package replication
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Permission is an operation a principal can be allowed to perform on a category
+type Permission string
+
+const (
+	// Produce allows writing into the category
+	Produce Permission = "produce"
+	// Consume allows reading, acking and committing the position of a consumer
+	Consume Permission = "consume"
+	// Admin allows managing the instance, the grants and the tokens, it implies the other permissions
+	Admin Permission = "admin"
+)
+
+// Grant allows the permissions on a category, a category ending with * grants them on every category
+// starting with what precedes it and * alone on every category including the operations on the whole instance
+type Grant struct {
+	Category    string       `json:"category"`
+	Permissions []Permission `json:"permissions"`
+}
+
+// Allows reports whether the grant covers the permission on the category, the empty category
+// stands for the operations which are not tied to a category
+func (g Grant) Allows(category string, permission Permission) bool {
+	if prefix, ok := strings.CutSuffix(g.Category, "*"); ok {
+		if !strings.HasPrefix(category, prefix) {
+			return false
+		}
+	} else if g.Category != category {
+		return false
+	}
+	for _, p := range g.Permissions {
+		if p == permission || p == Admin {
+			return true
+		}
+	}
+	return false
+}
+
+// HashToken returns the form under which tokens are stored, so that reading the cluster state
+// does not reveal them
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// SetToken records the principal the token with the given hash authenticates
+func (c *Client) SetToken(ctx context.Context, hash, principal string) error {
+	return c.backend.Put(ctx, c.prefix+"tokens/"+hash, principal)
+}
+
+// LookupToken returns the principal of the token with the given hash
+func (c *Client) LookupToken(ctx context.Context, hash string) (string, bool, error) {
+	resp, err := c.backend.Get(ctx, c.prefix+"tokens/"+hash, false)
+	if err != nil {
+		return "", false, fmt.Errorf("error getting token %w", err)
+	}
+	if len(resp) == 0 {
+		return "", false, nil
+	}
+	return resp[0].Value, true, nil
+}
+
+// ListTokens returns the principal of every token by the hash of the token
+func (c *Client) ListTokens(ctx context.Context) (map[string]string, error) {
+	prefix := c.prefix + "tokens/"
+	resp, err := c.backend.Get(ctx, prefix, true)
+	if err != nil {
+		return nil, fmt.Errorf("error getting tokens %w", err)
+	}
+	tokens := make(map[string]string, len(resp))
+	for _, kv := range resp {
+		tokens[strings.TrimPrefix(kv.Key, prefix)] = kv.Value
+	}
+	return tokens, nil
+}
+
+// DeleteToken revokes the token with the given hash
+func (c *Client) DeleteToken(ctx context.Context, hash string) error {
+	return c.backend.Delete(ctx, c.prefix+"tokens/"+hash, false)
+}
+
+// SetGrants replaces the grants of the principal
+func (c *Client) SetGrants(ctx context.Context, principal string, grants []Grant) error {
+	b, err := json.Marshal(grants)
+	if err != nil {
+		return err
+	}
+	return c.backend.Put(ctx, c.prefix+"acls/"+principal, string(b))
+}
+
+// GetGrants returns the grants of the principal
+func (c *Client) GetGrants(ctx context.Context, principal string) ([]Grant, error) {
+	resp, err := c.backend.Get(ctx, c.prefix+"acls/"+principal, false)
+	if err != nil {
+		return nil, fmt.Errorf("error getting grants %w", err)
+	}
+	if len(resp) == 0 {
+		return nil, nil
+	}
+	var grants []Grant
+	if err := json.Unmarshal([]byte(resp[0].Value), &grants); err != nil {
+		return nil, fmt.Errorf("error decoding grants of %s %w", principal, err)
+	}
+	return grants, nil
+}
+
+// ListGrants returns the grants of every principal
+func (c *Client) ListGrants(ctx context.Context) (map[string][]Grant, error) {
+	prefix := c.prefix + "acls/"
+	resp, err := c.backend.Get(ctx, prefix, true)
+	if err != nil {
+		return nil, fmt.Errorf("error getting grants %w", err)
+	}
+	acls := make(map[string][]Grant, len(resp))
+	for _, kv := range resp {
+		var grants []Grant
+		if err := json.Unmarshal([]byte(kv.Value), &grants); err != nil {
+			return nil, fmt.Errorf("error decoding grants %s %w", kv.Key, err)
+		}
+		acls[strings.TrimPrefix(kv.Key, prefix)] = grants
+	}
+	return acls, nil
+}
+
+// DeleteGrants removes every grant of the principal
+func (c *Client) DeleteGrants(ctx context.Context, principal string) error {
+	return c.backend.Delete(ctx, c.prefix+"acls/"+principal, false)
+}
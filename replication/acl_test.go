@@ -0,0 +1,68 @@
+package replication
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestGrantAllows(t *testing.T) {
+	tests := []struct {
+		grant      Grant
+		category   string
+		permission Permission
+		want       bool
+	}{
+		{Grant{"orders", []Permission{Produce}}, "orders", Produce, true},
+		{Grant{"orders", []Permission{Produce}}, "orders", Consume, false},
+		{Grant{"orders", []Permission{Produce}}, "orders-eu", Produce, false},
+		{Grant{"orders*", []Permission{Consume}}, "orders-eu", Consume, true},
+		{Grant{"orders*", []Permission{Consume}}, "payments", Consume, false},
+		{Grant{"orders*", []Permission{Admin}}, "orders", Produce, true},
+		{Grant{"orders*", []Permission{Admin}}, "", Admin, false},
+		{Grant{"*", []Permission{Admin}}, "", Admin, true},
+		{Grant{"*", []Permission{Consume}}, "payments", Consume, true},
+	}
+	for _, tt := range tests {
+		if got := tt.grant.Allows(tt.category, tt.permission); got != tt.want {
+			t.Errorf("%+v allows %s on %q: got %v want %v", tt.grant, tt.permission, tt.category, got, tt.want)
+		}
+	}
+}
+
+func TestTokensAndGrants(t *testing.T) {
+	ctx := context.Background()
+	client := NewClientWithBackend(NewMemoryBackend(), "test")
+
+	hash := HashToken("s3cr3t")
+	if err := client.SetToken(ctx, hash, "alice"); err != nil {
+		t.Fatalf("error setting token %v", err)
+	}
+	if principal, found, err := client.LookupToken(ctx, hash); err != nil || !found || principal != "alice" {
+		t.Errorf("got principal %q found %v error %v", principal, found, err)
+	}
+	if err := client.DeleteToken(ctx, hash); err != nil {
+		t.Fatalf("error deleting token %v", err)
+	}
+	if _, found, err := client.LookupToken(ctx, hash); err != nil || found {
+		t.Errorf("revoked token: got found %v error %v", found, err)
+	}
+
+	grants := []Grant{{Category: "orders*", Permissions: []Permission{Produce, Consume}}}
+	if err := client.SetGrants(ctx, "alice", grants); err != nil {
+		t.Fatalf("error setting grants %v", err)
+	}
+	acls, err := client.ListGrants(ctx)
+	if err != nil {
+		t.Fatalf("error listing grants %v", err)
+	}
+	if want := map[string][]Grant{"alice": grants}; !reflect.DeepEqual(acls, want) {
+		t.Errorf("got acls %+v want %+v", acls, want)
+	}
+	if err := client.DeleteGrants(ctx, "alice"); err != nil {
+		t.Fatalf("error deleting grants %v", err)
+	}
+	if got, err := client.GetGrants(ctx, "alice"); err != nil || len(got) != 0 {
+		t.Errorf("got grants %+v error %v", got, err)
+	}
+}
@@ -0,0 +1,24 @@
+package replication
+
+import (
+	"context"
+	"time"
+)
+
+// Backend is the coordination service shared by the instances of a cluster, it stores the peers,
+// the replication queues and the replica states and runs the leader elections.
+// The keys passed to a backend already contain the cluster prefix.
+type Backend interface {
+	Put(ctx context.Context, key, value string) error
+	// Get returns the entry stored under key, or every entry whose key starts with it when prefix is set
+	Get(ctx context.Context, key string, prefix bool) ([]Result, error)
+	// Delete removes the entry stored under key, or every entry whose key starts with it when prefix is set
+	Delete(ctx context.Context, key string, prefix bool) error
+	// Watch sends every entry stored under the prefix followed by the entries put later on,
+	// until the context is cancelled
+	Watch(ctx context.Context, prefix string) (<-chan Result, error)
+	// Leadership returns the elections of the current instance, every election is stored under the prefix
+	Leadership(prefix, currentInstance string, ttl time.Duration) Leadership
+	// Close releases the connections and files held by the backend
+	Close() error
+}
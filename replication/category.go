@@ -0,0 +1,96 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CategoryConfig holds the settings of a category which was created explicitly,
+// zero values keep the defaults of the instances
+type CategoryConfig struct {
+	// MaxChunkSize is the size above which a new chunk is started
+	MaxChunkSize uint64 `json:"maxChunkSize,omitempty"`
+	// MaxChunkAgeSeconds is how long a chunk is written into before a new one is started, so that
+	// the chunks of quiet categories are completed and can be acked regularly
+	MaxChunkAgeSeconds int64 `json:"maxChunkAgeSeconds,omitempty"`
+	// MaxChunkMessages is the number of messages after which a new chunk is started
+	MaxChunkMessages uint64 `json:"maxChunkMessages,omitempty"`
+	// RetentionSeconds is how long complete chunks are kept after they were sealed, or after their
+	// copy was completed, whether they have been acked or not
+	RetentionSeconds int64 `json:"retentionSeconds,omitempty"`
+	// Fsync is when the writes are flushed to stable storage: never, seal or always
+	Fsync string `json:"fsync,omitempty"`
+	// ReplicationFactor is the number of copies of every chunk including the owner's one
+	ReplicationFactor int       `json:"replicationFactor,omitempty"`
+	CreatedAt         time.Time `json:"createdAt"`
+}
+
+// SetCategory records the settings of the category
+func (c *Client) SetCategory(ctx context.Context, category string, cfg CategoryConfig) error {
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	return c.backend.Put(ctx, c.prefix+"categories/"+category, string(b))
+}
+
+// GetCategory returns the settings of the category, it is not found when the category was created implicitly
+func (c *Client) GetCategory(ctx context.Context, category string) (CategoryConfig, bool, error) {
+	resp, err := c.backend.Get(ctx, c.prefix+"categories/"+category, false)
+	if err != nil {
+		return CategoryConfig{}, false, fmt.Errorf("error getting category %w", err)
+	}
+	if len(resp) == 0 {
+		return CategoryConfig{}, false, nil
+	}
+	var cfg CategoryConfig
+	if err := json.Unmarshal([]byte(resp[0].Value), &cfg); err != nil {
+		return CategoryConfig{}, false, fmt.Errorf("error decoding category %s %w", category, err)
+	}
+	return cfg, true, nil
+}
+
+// ListCategories returns the settings of every category created explicitly
+func (c *Client) ListCategories(ctx context.Context) (map[string]CategoryConfig, error) {
+	prefix := c.prefix + "categories/"
+	resp, err := c.backend.Get(ctx, prefix, true)
+	if err != nil {
+		return nil, fmt.Errorf("error getting categories %w", err)
+	}
+	categories := make(map[string]CategoryConfig, len(resp))
+	for _, kv := range resp {
+		var cfg CategoryConfig
+		if err := json.Unmarshal([]byte(kv.Value), &cfg); err != nil {
+			return nil, fmt.Errorf("error decoding category %s %w", kv.Key, err)
+		}
+		categories[strings.TrimPrefix(kv.Key, prefix)] = cfg
+	}
+	return categories, nil
+}
+
+// DeleteCategory forgets the settings of the category together with its replicas, the positions
+// of its consumers and the copies queued for every peer
+func (c *Client) DeleteCategory(ctx context.Context, category string) error {
+	if err := c.backend.Delete(ctx, c.prefix+"categories/"+category, false); err != nil {
+		return err
+	}
+	if err := c.backend.Delete(ctx, fmt.Sprintf("%sreplicas/%s/", c.prefix, category), true); err != nil {
+		return err
+	}
+	if err := c.backend.Delete(ctx, fmt.Sprintf("%soffsets/%s/", c.prefix, category), true); err != nil {
+		return err
+	}
+	peers, err := c.ListPeers(ctx)
+	if err != nil {
+		return err
+	}
+	for _, p := range peers {
+		if err := c.backend.Delete(ctx, fmt.Sprintf("%sreplication/%s/%s/", c.prefix, p.Name, category), true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,8 +2,9 @@ package replication
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	clientv3 "go.etcd.io/etcd/client/v3"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -11,8 +12,8 @@ import (
 const defaultTimeout = 10 * time.Second
 
 type Client struct {
-	cli    *clientv3.Client
-	prefix string
+	backend Backend
+	prefix  string
 }
 
 type Result struct {
@@ -21,75 +22,307 @@ type Result struct {
 }
 
 type Peer struct {
-	Addr string
-	Name string
+	Addr string `json:"addr"`
+	Name string `json:"-"`
+	Zone string `json:"zone,omitempty"`
 }
 
+// Chunk is an entry of the replication queue, the chunk is downloaded from the OwnedBy instance
 type Chunk struct {
 	OwnedBy  string
 	Category string
 	FileName string
 }
 
-type Option clientv3.OpOption
+// ReplicaState is the progress of an instance copying a chunk it does not own
+type ReplicaState struct {
+	Instance string `json:"-"`
+	FileName string `json:"-"`
+	Size     uint64 `json:"size"`
+	Complete bool   `json:"complete"`
+}
 
-func NewClient(addr []string, clusterName string) (*Client, error) {
-	etcdClient, err := clientv3.New(clientv3.Config{
-		Endpoints:   addr,
-		DialTimeout: defaultTimeout,
-	})
-	if err != nil {
-		return nil, err
+// ConsumerOffset is the position up to which a consumer has processed a category
+type ConsumerOffset struct {
+	Consumer    string    `json:"-"`
+	Chunk       string    `json:"chunk"`
+	Offset      uint64    `json:"offset"`
+	CommittedAt time.Time `json:"committedAt"`
+}
+
+// DrainState is the progress of decommissioning an instance
+type DrainState string
+
+const (
+	Draining DrainState = "draining"
+	Drained  DrainState = "drained"
+)
+
+// Option changes how keys are looked up
+type Option func(*getOptions)
+
+type getOptions struct {
+	prefix bool
+}
+
+// WithPrefix returns every entry whose key starts with the given key
+func WithPrefix() Option {
+	return func(o *getOptions) {
+		o.prefix = true
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
-	defer cancel()
-	_, err = etcdClient.Put(ctx, "test", "test")
+}
+
+// NewClient connects to the etcd cluster storing the state of the cluster
+func NewClient(addr []string, clusterName string, opts ...EtcdOption) (*Client, error) {
+	backend, err := NewEtcdBackend(addr, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("error putting test key in etcd %w", err)
+		return nil, err
 	}
-	return &Client{cli: etcdClient, prefix: fmt.Sprintf("events/%s/", clusterName)}, nil
+	return NewClientWithBackend(backend, clusterName), nil
+}
+
+// NewClientWithBackend keeps the state of the cluster in the given coordination backend
+func NewClientWithBackend(backend Backend, clusterName string) *Client {
+	return &Client{backend: instrumentedBackend{backend}, prefix: fmt.Sprintf("events/%s/", clusterName)}
+}
+
+// Close disconnects from the coordination backend
+func (c *Client) Close() error {
+	return c.backend.Close()
 }
 
 func (c *Client) Put(ctx context.Context, key, value string) error {
-	_, err := c.cli.Put(ctx, c.prefix+key, value)
-	return err
+	return c.backend.Put(ctx, c.prefix+key, value)
 }
 
 func (c *Client) Get(ctx context.Context, key string, opts ...Option) ([]Result, error) {
-	etcdOps := make([]clientv3.OpOption, len(opts))
+	var o getOptions
 	for _, opt := range opts {
-		etcdOps = append(etcdOps, clientv3.OpOption(opt))
+		opt(&o)
 	}
-	etcdResp, err := c.cli.Get(ctx, c.prefix+key, etcdOps...)
-	if err != nil {
-		return nil, err
-	}
-
-	var results []Result
-	for _, kv := range etcdResp.Kvs {
-		results = append(results, Result{Key: string(kv.Key), Value: string(kv.Value)})
-	}
-	return results, nil
+	return c.backend.Get(ctx, c.prefix+key, o.prefix)
 }
 
 func (c *Client) ListPeers(ctx context.Context) ([]Peer, error) {
-	resp, err := c.cli.Get(ctx, "peers/", clientv3.WithPrefix())
+	resp, err := c.backend.Get(ctx, c.prefix+"peers/", true)
 	if err != nil {
-		return nil, fmt.Errorf("error getting peers from etcd %w", err)
+		return nil, fmt.Errorf("error getting peers %w", err)
 	}
 	var peers []Peer
-	for _, kv := range resp.Kvs {
-		peers = append(peers, Peer{Addr: string(kv.Value), Name: strings.TrimPrefix(string(kv.Key), c.prefix+"peers/")})
+	for _, kv := range resp {
+		peers = append(peers, parsePeer(strings.TrimPrefix(kv.Key, c.prefix+"peers/"), []byte(kv.Value)))
 	}
 	return peers, nil
 }
 
+// RegisterPeer adds the instance to the peers, an instance registering again after it was drained
+// is back in the cluster and receives copies again
 func (c *Client) RegisterPeer(ctx context.Context, peer Peer) error {
-	_, err := c.cli.Put(ctx, "peers/"+peer.Name, peer.Addr)
-	return err
+	b, err := json.Marshal(peer)
+	if err != nil {
+		return err
+	}
+	if err := c.backend.Put(ctx, c.prefix+"peers/"+peer.Name, string(b)); err != nil {
+		return err
+	}
+	if err := c.backend.Delete(ctx, c.prefix+"draining/"+peer.Name, false); err != nil {
+		return fmt.Errorf("error clearing drain state %w", err)
+	}
+	return nil
+}
+
+// parsePeer decodes a peer registry entry, older instances registered only their address
+func parsePeer(name string, value []byte) Peer {
+	peer := Peer{Name: name}
+	if err := json.Unmarshal(value, &peer); err != nil {
+		peer.Addr = string(value)
+	}
+	return peer
+}
+
+// SetDiskUsage records how many bytes the instance stores
+func (c *Client) SetDiskUsage(ctx context.Context, instance string, bytes uint64) error {
+	return c.backend.Put(ctx, c.prefix+"usage/"+instance, strconv.FormatUint(bytes, 10))
+}
+
+// ListDiskUsage returns the number of bytes stored by every instance which reported it
+func (c *Client) ListDiskUsage(ctx context.Context) (map[string]uint64, error) {
+	resp, err := c.backend.Get(ctx, c.prefix+"usage/", true)
+	if err != nil {
+		return nil, fmt.Errorf("error getting disk usage %w", err)
+	}
+	usage := make(map[string]uint64, len(resp))
+	for _, kv := range resp {
+		bytes, err := strconv.ParseUint(kv.Value, 10, 64)
+		if err != nil {
+			continue
+		}
+		usage[strings.TrimPrefix(kv.Key, c.prefix+"usage/")] = bytes
+	}
+	return usage, nil
+}
+
+// DeletePeer removes the instance from the peer registry
+func (c *Client) DeletePeer(ctx context.Context, name string) error {
+	return c.backend.Delete(ctx, c.prefix+"peers/"+name, false)
+}
+
+// SetDrainState records the progress of decommissioning the instance
+func (c *Client) SetDrainState(ctx context.Context, instance string, state DrainState) error {
+	return c.backend.Put(ctx, c.prefix+"draining/"+instance, string(state))
+}
+
+// ListDrainStates returns the instances which are being or have been decommissioned
+func (c *Client) ListDrainStates(ctx context.Context) (map[string]DrainState, error) {
+	resp, err := c.backend.Get(ctx, c.prefix+"draining/", true)
+	if err != nil {
+		return nil, fmt.Errorf("error getting draining instances %w", err)
+	}
+	states := make(map[string]DrainState, len(resp))
+	for _, kv := range resp {
+		states[strings.TrimPrefix(kv.Key, c.prefix+"draining/")] = DrainState(kv.Value)
+	}
+	return states, nil
 }
 
 func (c *Client) AddChunkToReplicationQueue(ctx context.Context, targetInstance string, chunk Chunk) error {
-	_, err := c.cli.Put(ctx, fmt.Sprintf("replication/%s/%s/%s", targetInstance, chunk.Category, chunk.FileName), chunk.OwnedBy)
-	return err
+	return c.backend.Put(ctx, c.replicationQueueKey(targetInstance, chunk), chunk.OwnedBy)
+}
+
+// DeleteChunkFromReplicationQueue removes the chunk from the replication queue of the target instance
+func (c *Client) DeleteChunkFromReplicationQueue(ctx context.Context, targetInstance string, chunk Chunk) error {
+	return c.backend.Delete(ctx, c.replicationQueueKey(targetInstance, chunk), false)
+}
+
+// DeleteReplicationQueue drops every chunk queued for the target instance
+func (c *Client) DeleteReplicationQueue(ctx context.Context, targetInstance string) error {
+	return c.backend.Delete(ctx, fmt.Sprintf("%sreplication/%s/", c.prefix, targetInstance), true)
+}
+
+// WatchReplicationQueue sends every chunk which is already queued for the instance followed by
+// the chunks added to the queue later on, until the context is cancelled
+func (c *Client) WatchReplicationQueue(ctx context.Context, instance string) (<-chan Chunk, error) {
+	prefix := fmt.Sprintf("%sreplication/%s/", c.prefix, instance)
+	entries, err := c.backend.Watch(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("error watching replication queue %w", err)
+	}
+
+	ch := make(chan Chunk)
+	go func() {
+		defer close(ch)
+		for kv := range entries {
+			chunk, ok := parseReplicationQueueKey(prefix, kv.Key, kv.Value)
+			if !ok {
+				continue
+			}
+			select {
+			case ch <- chunk:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// ListQueuedCopies returns the instances whose replication queue holds each chunk of the category,
+// keyed by the chunk name
+func (c *Client) ListQueuedCopies(ctx context.Context, category string) (map[string][]string, error) {
+	prefix := c.prefix + "replication/"
+	resp, err := c.backend.Get(ctx, prefix, true)
+	if err != nil {
+		return nil, fmt.Errorf("error getting replication queues %w", err)
+	}
+	queued := make(map[string][]string)
+	for _, kv := range resp {
+		target, rest, ok := strings.Cut(strings.TrimPrefix(kv.Key, prefix), "/")
+		if !ok {
+			continue
+		}
+		chunk, ok := parseReplicationQueueKey("", rest, kv.Value)
+		if !ok || chunk.Category != category {
+			continue
+		}
+		queued[chunk.FileName] = append(queued[chunk.FileName], target)
+	}
+	return queued, nil
+}
+
+// SetReplicaState records how much of the chunk has been copied to the instance
+func (c *Client) SetReplicaState(ctx context.Context, category string, state ReplicaState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return c.backend.Put(ctx, fmt.Sprintf("%sreplicas/%s/%s/%s", c.prefix, category, state.FileName, state.Instance), string(b))
+}
+
+// DeleteReplicaState forgets that the instance holds a copy of the chunk
+func (c *Client) DeleteReplicaState(ctx context.Context, category string, state ReplicaState) error {
+	return c.backend.Delete(ctx, fmt.Sprintf("%sreplicas/%s/%s/%s", c.prefix, category, state.FileName, state.Instance), false)
+}
+
+// ListReplicas returns the replication progress of every chunk in the category
+func (c *Client) ListReplicas(ctx context.Context, category string) ([]ReplicaState, error) {
+	prefix := fmt.Sprintf("%sreplicas/%s/", c.prefix, category)
+	resp, err := c.backend.Get(ctx, prefix, true)
+	if err != nil {
+		return nil, fmt.Errorf("error getting replicas %w", err)
+	}
+	var replicas []ReplicaState
+	for _, kv := range resp {
+		fileName, instance, ok := strings.Cut(strings.TrimPrefix(kv.Key, prefix), "/")
+		if !ok {
+			continue
+		}
+		var state ReplicaState
+		if err := json.Unmarshal([]byte(kv.Value), &state); err != nil {
+			return nil, fmt.Errorf("error decoding replica state of %s %w", kv.Key, err)
+		}
+		state.FileName = fileName
+		state.Instance = instance
+		replicas = append(replicas, state)
+	}
+	return replicas, nil
+}
+
+// SetConsumerOffset records the position of the consumer in the category
+func (c *Client) SetConsumerOffset(ctx context.Context, category string, offset ConsumerOffset) error {
+	b, err := json.Marshal(offset)
+	if err != nil {
+		return err
+	}
+	return c.backend.Put(ctx, fmt.Sprintf("%soffsets/%s/%s", c.prefix, category, offset.Consumer), string(b))
+}
+
+// ListConsumerOffsets returns the position of every consumer of the category
+func (c *Client) ListConsumerOffsets(ctx context.Context, category string) ([]ConsumerOffset, error) {
+	prefix := fmt.Sprintf("%soffsets/%s/", c.prefix, category)
+	resp, err := c.backend.Get(ctx, prefix, true)
+	if err != nil {
+		return nil, fmt.Errorf("error getting consumer offsets %w", err)
+	}
+	var offsets []ConsumerOffset
+	for _, kv := range resp {
+		var offset ConsumerOffset
+		if err := json.Unmarshal([]byte(kv.Value), &offset); err != nil {
+			return nil, fmt.Errorf("error decoding consumer offset %s %w", kv.Key, err)
+		}
+		offset.Consumer = strings.TrimPrefix(kv.Key, prefix)
+		offsets = append(offsets, offset)
+	}
+	return offsets, nil
+}
+
+func (c *Client) replicationQueueKey(targetInstance string, chunk Chunk) string {
+	return fmt.Sprintf("%sreplication/%s/%s/%s", c.prefix, targetInstance, chunk.Category, chunk.FileName)
+}
+
+func parseReplicationQueueKey(prefix, key, ownedBy string) (Chunk, bool) {
+	category, fileName, ok := strings.Cut(strings.TrimPrefix(key, prefix), "/")
+	if !ok {
+		return Chunk{}, false
+	}
+	return Chunk{OwnedBy: ownedBy, Category: category, FileName: fileName}, true
 }
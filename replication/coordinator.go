@@ -0,0 +1,52 @@
+package replication
+
+import "context"
+
+// Coordinator is the cluster state shared by the instances: the peer registry, the replication
+// queues, the replica states, the categories, the access control lists, the quotas and arbitrary key/value entries
+type Coordinator interface {
+	Put(ctx context.Context, key, value string) error
+	Get(ctx context.Context, key string, opts ...Option) ([]Result, error)
+
+	ListPeers(ctx context.Context) ([]Peer, error)
+	RegisterPeer(ctx context.Context, peer Peer) error
+	DeletePeer(ctx context.Context, name string) error
+	SetDiskUsage(ctx context.Context, instance string, bytes uint64) error
+	ListDiskUsage(ctx context.Context) (map[string]uint64, error)
+	SetDrainState(ctx context.Context, instance string, state DrainState) error
+	ListDrainStates(ctx context.Context) (map[string]DrainState, error)
+
+	AddChunkToReplicationQueue(ctx context.Context, targetInstance string, chunk Chunk) error
+	DeleteChunkFromReplicationQueue(ctx context.Context, targetInstance string, chunk Chunk) error
+	DeleteReplicationQueue(ctx context.Context, targetInstance string) error
+	WatchReplicationQueue(ctx context.Context, instance string) (<-chan Chunk, error)
+	ListQueuedCopies(ctx context.Context, category string) (map[string][]string, error)
+
+	SetReplicaState(ctx context.Context, category string, state ReplicaState) error
+	DeleteReplicaState(ctx context.Context, category string, state ReplicaState) error
+	ListReplicas(ctx context.Context, category string) ([]ReplicaState, error)
+
+	SetConsumerOffset(ctx context.Context, category string, offset ConsumerOffset) error
+	ListConsumerOffsets(ctx context.Context, category string) ([]ConsumerOffset, error)
+
+	SetToken(ctx context.Context, hash, principal string) error
+	LookupToken(ctx context.Context, hash string) (string, bool, error)
+	ListTokens(ctx context.Context) (map[string]string, error)
+	DeleteToken(ctx context.Context, hash string) error
+	SetGrants(ctx context.Context, principal string, grants []Grant) error
+	GetGrants(ctx context.Context, principal string) ([]Grant, error)
+	ListGrants(ctx context.Context) (map[string][]Grant, error)
+	DeleteGrants(ctx context.Context, principal string) error
+
+	SetQuota(ctx context.Context, scope QuotaScope, name string, quota Quota) error
+	GetQuota(ctx context.Context, scope QuotaScope, name string) (Quota, bool, error)
+	ListQuotas(ctx context.Context, scope QuotaScope) (map[string]Quota, error)
+	DeleteQuota(ctx context.Context, scope QuotaScope, name string) error
+
+	SetCategory(ctx context.Context, category string, cfg CategoryConfig) error
+	GetCategory(ctx context.Context, category string) (CategoryConfig, bool, error)
+	ListCategories(ctx context.Context) (map[string]CategoryConfig, error)
+	DeleteCategory(ctx context.Context, category string) error
+}
+
+var _ Coordinator = (*Client)(nil)
@@ -0,0 +1,119 @@
+package replication
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"time"
+)
+
+// etcdBackend keeps the cluster state in an external etcd cluster
+type etcdBackend struct {
+	cli *clientv3.Client
+}
+
+// EtcdOption configures the connection to etcd
+type EtcdOption func(*clientv3.Config)
+
+// WithEtcdTLS connects to etcd over TLS, the configuration carries the client certificate
+// when etcd requires one
+func WithEtcdTLS(cfg *tls.Config) EtcdOption {
+	return func(c *clientv3.Config) {
+		c.TLS = cfg
+	}
+}
+
+// NewEtcdBackend connects to the etcd cluster and checks that it accepts writes
+func NewEtcdBackend(addr []string, opts ...EtcdOption) (Backend, error) {
+	cfg := clientv3.Config{
+		Endpoints:   addr,
+		DialTimeout: defaultTimeout,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	etcdClient, err := clientv3.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	_, err = etcdClient.Put(ctx, "test", "test")
+	if err != nil {
+		return nil, fmt.Errorf("error putting test key in etcd %w", err)
+	}
+	return &etcdBackend{cli: etcdClient}, nil
+}
+
+func (e *etcdBackend) Put(ctx context.Context, key, value string) error {
+	_, err := e.cli.Put(ctx, key, value)
+	return err
+}
+
+func (e *etcdBackend) Get(ctx context.Context, key string, prefix bool) ([]Result, error) {
+	var opts []clientv3.OpOption
+	if prefix {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+	resp, err := e.cli.Get(ctx, key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]Result, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		results = append(results, Result{Key: string(kv.Key), Value: string(kv.Value)})
+	}
+	return results, nil
+}
+
+func (e *etcdBackend) Delete(ctx context.Context, key string, prefix bool) error {
+	var opts []clientv3.OpOption
+	if prefix {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+	_, err := e.cli.Delete(ctx, key, opts...)
+	return err
+}
+
+func (e *etcdBackend) Watch(ctx context.Context, prefix string) (<-chan Result, error) {
+	resp, err := e.cli.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan Result)
+	go func() {
+		defer close(ch)
+		for _, kv := range resp.Kvs {
+			select {
+			case ch <- Result{Key: string(kv.Key), Value: string(kv.Value)}:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		watchCh := e.cli.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(resp.Header.Revision+1))
+		for watchResp := range watchCh {
+			for _, ev := range watchResp.Events {
+				if ev.Type != clientv3.EventTypePut {
+					continue
+				}
+				select {
+				case ch <- Result{Key: string(ev.Kv.Key), Value: string(ev.Kv.Value)}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (e *etcdBackend) Leadership(prefix, currentInstance string, ttl time.Duration) Leadership {
+	return newEtcdLeadership(e.cli, prefix, currentInstance, ttl)
+}
+
+func (e *etcdBackend) Close() error {
+	return e.cli.Close()
+}
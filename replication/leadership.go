@@ -0,0 +1,153 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultLeaderTTL   = 10 * time.Second
+	leaderPollInterval = 50 * time.Millisecond
+)
+
+// Leadership elects a single instance per category which accepts all the writes for that category
+type Leadership interface {
+	// Leader returns the instance leading the category, the current instance joins the election of the category on first use
+	Leader(ctx context.Context, category string) (string, error)
+	// Close gives up the leadership of every category so that other instances can take over immediately
+	Close() error
+}
+
+func NewLeadership(client *Client, currentInstance string, ttl time.Duration) Leadership {
+	if ttl <= 0 {
+		ttl = DefaultLeaderTTL
+	}
+	return client.backend.Leadership(client.prefix+"leaders/", currentInstance, ttl)
+}
+
+// etcdLeadership is tied to an etcd lease, so when the leader stops refreshing it another instance takes over
+type etcdLeadership struct {
+	cli             *clientv3.Client
+	prefix          string
+	currentInstance string
+	ttl             time.Duration
+	logger          *slog.Logger
+
+	mu        sync.Mutex
+	session   *concurrency.Session
+	campaigns map[string]bool
+}
+
+func newEtcdLeadership(cli *clientv3.Client, prefix, currentInstance string, ttl time.Duration) *etcdLeadership {
+	return &etcdLeadership{
+		cli:             cli,
+		prefix:          prefix,
+		currentInstance: currentInstance,
+		ttl:             ttl,
+		logger:          slog.Default().With("instance", currentInstance),
+		campaigns:       make(map[string]bool),
+	}
+}
+
+func (l *etcdLeadership) Leader(ctx context.Context, category string) (string, error) {
+	session, err := l.join(category)
+	if err != nil {
+		return "", err
+	}
+	election := concurrency.NewElection(session, l.electionPrefix(category))
+	for {
+		resp, err := election.Leader(ctx)
+		if err == nil {
+			return string(resp.Kvs[0].Value), nil
+		}
+		if !errors.Is(err, concurrency.ErrElectionNoLeader) {
+			return "", fmt.Errorf("error getting leader of category %s %w", category, err)
+		}
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("no leader elected for category %s %w", category, ctx.Err())
+		case <-time.After(leaderPollInterval):
+		}
+	}
+}
+
+func (l *etcdLeadership) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.session == nil {
+		return nil
+	}
+	err := l.session.Close()
+	l.session = nil
+	l.campaigns = make(map[string]bool)
+	return err
+}
+
+func (l *etcdLeadership) join(category string) (*concurrency.Session, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.session != nil {
+		select {
+		case <-l.session.Done():
+			// the lease has expired and with it the leadership of every category, campaign again
+			l.logger.Warn("leadership session expired, rejoining elections")
+			l.session = nil
+			l.campaigns = make(map[string]bool)
+		default:
+		}
+	}
+	if l.session == nil {
+		session, err := concurrency.NewSession(l.cli, concurrency.WithTTL(leaseSeconds(l.ttl)))
+		if err != nil {
+			return nil, fmt.Errorf("error creating etcd session %w", err)
+		}
+		l.session = session
+	}
+	if !l.campaigns[category] {
+		l.campaigns[category] = true
+		go l.campaign(l.session, category)
+	}
+	return l.session, nil
+}
+
+func (l *etcdLeadership) campaign(session *concurrency.Session, category string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-session.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	election := concurrency.NewElection(session, l.electionPrefix(category))
+	if err := election.Campaign(ctx, l.currentInstance); err != nil {
+		if ctx.Err() == nil {
+			l.logger.Error("error campaigning for leadership", "category", category, "error", err)
+		}
+		l.mu.Lock()
+		if l.session == session {
+			delete(l.campaigns, category)
+		}
+		l.mu.Unlock()
+		return
+	}
+	l.logger.Info("became the leader of category", "category", category)
+}
+
+// leaseSeconds converts the ttl to the whole seconds of an etcd lease, rounding up so that a
+// sub-second ttl does not become a lease without ttl
+func leaseSeconds(ttl time.Duration) int {
+	return int((ttl + time.Second - 1) / time.Second)
+}
+
+func (l *etcdLeadership) electionPrefix(category string) string {
+	return l.prefix + category
+}
@@ -0,0 +1,23 @@
+package replication
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeaseSeconds(t *testing.T) {
+	tests := []struct {
+		ttl  time.Duration
+		want int
+	}{
+		{ttl: 100 * time.Millisecond, want: 1},
+		{ttl: time.Second, want: 1},
+		{ttl: 1500 * time.Millisecond, want: 2},
+		{ttl: DefaultLeaderTTL, want: 10},
+	}
+	for _, tt := range tests {
+		if got := leaseSeconds(tt.ttl); got != tt.want {
+			t.Errorf("leaseSeconds(%v) = %d, want %d", tt.ttl, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LocalBackend keeps the state of a standalone instance in a local file, there is no other instance
+// to coordinate with so the current instance leads every category
+type LocalBackend struct {
+	path  string
+	store *memStore
+	mu    sync.Mutex
+}
+
+var _ Backend = (*LocalBackend)(nil)
+
+// NewLocalBackend loads the state saved in the file at path, if any
+func NewLocalBackend(path string) (*LocalBackend, error) {
+	b := &LocalBackend{path: path, store: newMemStore()}
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return b, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading local state %s %w", path, err)
+	}
+	data := make(map[string]string)
+	if err := json.Unmarshal(contents, &data); err != nil {
+		return nil, fmt.Errorf("error decoding local state %s %w", path, err)
+	}
+	b.store.restore(data)
+	return b, nil
+}
+
+func (b *LocalBackend) Put(ctx context.Context, key, value string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.store.put(key, value)
+	return b.save()
+}
+
+func (b *LocalBackend) Get(ctx context.Context, key string, prefix bool) ([]Result, error) {
+	return b.store.get(key, prefix), nil
+}
+
+func (b *LocalBackend) Delete(ctx context.Context, key string, prefix bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.store.delete(key, prefix)
+	return b.save()
+}
+
+func (b *LocalBackend) Watch(ctx context.Context, prefix string) (<-chan Result, error) {
+	return b.store.watch(ctx, prefix), nil
+}
+
+func (b *LocalBackend) Leadership(prefix, currentInstance string, ttl time.Duration) Leadership {
+	return localLeadership(currentInstance)
+}
+
+// Close does nothing, the state is saved on every change
+func (b *LocalBackend) Close() error {
+	return nil
+}
+
+// save writes the whole state to a temporary file and renames it over the previous one,
+// it must be called with the lock held
+func (b *LocalBackend) save() error {
+	contents, err := json.Marshal(b.store.snapshot())
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(b.path), 0777); err != nil {
+		return fmt.Errorf("error creating directory of local state %w", err)
+	}
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, contents, 0666); err != nil {
+		return fmt.Errorf("error writing local state %w", err)
+	}
+	return os.Rename(tmp, b.path)
+}
+
+// localLeadership makes the standalone instance the leader of every category
+type localLeadership string
+
+func (l localLeadership) Leader(ctx context.Context, category string) (string, error) {
+	return string(l), nil
+}
+
+func (l localLeadership) Close() error {
+	return nil
+}
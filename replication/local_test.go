@@ -0,0 +1,55 @@
+package replication
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalBackend(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cluster.json")
+	b, err := NewLocalBackend(path)
+	if err != nil {
+		t.Fatalf("error creating local backend %v", err)
+	}
+	client := NewClientWithBackend(b, "default")
+	if err := client.RegisterPeer(ctx, Peer{Name: "luffy", Addr: "127.0.0.1:8080"}); err != nil {
+		t.Fatalf("error registering peer %v", err)
+	}
+	if err := client.SetDrainState(ctx, "zoro", Drained); err != nil {
+		t.Fatalf("error setting drain state %v", err)
+	}
+	if err := client.SetDiskUsage(ctx, "zoro", 10); err != nil {
+		t.Fatalf("error setting disk usage %v", err)
+	}
+	if err := client.DeletePeer(ctx, "zoro"); err != nil {
+		t.Fatalf("error deleting peer %v", err)
+	}
+
+	// the state survives a restart
+	b, err = NewLocalBackend(path)
+	if err != nil {
+		t.Fatalf("error loading local backend %v", err)
+	}
+	client = NewClientWithBackend(b, "default")
+	peers, err := client.ListPeers(ctx)
+	if err != nil {
+		t.Fatalf("error listing peers %v", err)
+	}
+	if len(peers) != 1 || peers[0] != (Peer{Name: "luffy", Addr: "127.0.0.1:8080"}) {
+		t.Errorf("ListPeers() = %v, want luffy only", peers)
+	}
+	states, err := client.ListDrainStates(ctx)
+	if err != nil {
+		t.Fatalf("error listing drain states %v", err)
+	}
+	if states["zoro"] != Drained {
+		t.Errorf("drain state of zoro = %q, want %q", states["zoro"], Drained)
+	}
+
+	leader, err := NewLeadership(client, "luffy", 0).Leader(ctx, "numbers")
+	if err != nil || leader != "luffy" {
+		t.Errorf("Leader() = %s, %v, want luffy", leader, err)
+	}
+}
@@ -0,0 +1,79 @@
+package replication
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryBackend keeps the cluster state in memory, it is meant for tests where several instances
+// running in the same process share one backend. The first instance asking for the leader of
+// a category becomes its leader until it gives up its leadership.
+type MemoryBackend struct {
+	store *memStore
+
+	mu      sync.Mutex
+	leaders map[string]string
+}
+
+var _ Backend = (*MemoryBackend)(nil)
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{store: newMemStore(), leaders: make(map[string]string)}
+}
+
+func (b *MemoryBackend) Put(ctx context.Context, key, value string) error {
+	b.store.put(key, value)
+	return nil
+}
+
+func (b *MemoryBackend) Get(ctx context.Context, key string, prefix bool) ([]Result, error) {
+	return b.store.get(key, prefix), nil
+}
+
+func (b *MemoryBackend) Delete(ctx context.Context, key string, prefix bool) error {
+	b.store.delete(key, prefix)
+	return nil
+}
+
+func (b *MemoryBackend) Watch(ctx context.Context, prefix string) (<-chan Result, error) {
+	return b.store.watch(ctx, prefix), nil
+}
+
+func (b *MemoryBackend) Leadership(prefix, currentInstance string, ttl time.Duration) Leadership {
+	return &memoryLeadership{backend: b, prefix: prefix, currentInstance: currentInstance}
+}
+
+// Close does nothing, the backend is shared by the instances of the process
+func (b *MemoryBackend) Close() error {
+	return nil
+}
+
+type memoryLeadership struct {
+	backend         *MemoryBackend
+	prefix          string
+	currentInstance string
+}
+
+func (l *memoryLeadership) Leader(ctx context.Context, category string) (string, error) {
+	l.backend.mu.Lock()
+	defer l.backend.mu.Unlock()
+	leader, ok := l.backend.leaders[l.prefix+category]
+	if !ok {
+		leader = l.currentInstance
+		l.backend.leaders[l.prefix+category] = leader
+	}
+	return leader, nil
+}
+
+func (l *memoryLeadership) Close() error {
+	l.backend.mu.Lock()
+	defer l.backend.mu.Unlock()
+	for election, leader := range l.backend.leaders {
+		if leader == l.currentInstance && strings.HasPrefix(election, l.prefix) {
+			delete(l.backend.leaders, election)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package replication
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemoryBackendWatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client := NewClientWithBackend(NewMemoryBackend(), "default")
+
+	queued := Chunk{OwnedBy: "zoro", Category: "numbers", FileName: "zoro-chunk000000000"}
+	if err := client.AddChunkToReplicationQueue(ctx, "luffy", queued); err != nil {
+		t.Fatalf("error queueing chunk %v", err)
+	}
+	watch, err := client.WatchReplicationQueue(ctx, "luffy")
+	if err != nil {
+		t.Fatalf("error watching queue %v", err)
+	}
+	added := Chunk{OwnedBy: "nami", Category: "numbers", FileName: "nami-chunk000000000"}
+	if err := client.AddChunkToReplicationQueue(ctx, "luffy", added); err != nil {
+		t.Fatalf("error queueing chunk %v", err)
+	}
+	// chunks queued for other instances and deletions are not watched
+	if err := client.AddChunkToReplicationQueue(ctx, "zoro", added); err != nil {
+		t.Fatalf("error queueing chunk %v", err)
+	}
+	if err := client.DeleteChunkFromReplicationQueue(ctx, "luffy", queued); err != nil {
+		t.Fatalf("error deleting chunk %v", err)
+	}
+
+	var got []Chunk
+	for len(got) < 2 {
+		select {
+		case ch := <-watch:
+			got = append(got, ch)
+		case <-ctx.Done():
+			t.Fatalf("got only %v", got)
+		}
+	}
+	if want := []Chunk{queued, added}; !reflect.DeepEqual(got, want) {
+		t.Errorf("WatchReplicationQueue() = %v, want %v", got, want)
+	}
+	select {
+	case ch := <-watch:
+		t.Errorf("unexpected chunk %v", ch)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMemoryBackendLeadership(t *testing.T) {
+	ctx := context.Background()
+	client := NewClientWithBackend(NewMemoryBackend(), "default")
+	luffy := NewLeadership(client, "luffy", 0)
+	zoro := NewLeadership(client, "zoro", 0)
+
+	for _, l := range []Leadership{luffy, zoro} {
+		if leader, _ := l.Leader(ctx, "numbers"); leader != "luffy" {
+			t.Errorf("Leader() = %s, want luffy", leader)
+		}
+	}
+	if err := luffy.Close(); err != nil {
+		t.Fatalf("error giving up leadership %v", err)
+	}
+	if leader, _ := zoro.Leader(ctx, "numbers"); leader != "zoro" {
+		t.Errorf("Leader() after close = %s, want zoro", leader)
+	}
+}
@@ -0,0 +1,160 @@
+package replication
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// memStore is an in-memory key/value map whose changes can be watched
+type memStore struct {
+	mu       sync.Mutex
+	data     map[string]string
+	watchers map[*memWatcher]bool
+}
+
+// memWatcher buffers the entries put under its prefix so that a slow reader never blocks writers
+type memWatcher struct {
+	prefix  string
+	pending []Result
+	notify  chan struct{}
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		data:     make(map[string]string),
+		watchers: make(map[*memWatcher]bool),
+	}
+}
+
+func (m *memStore) put(key, value string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data[key] = value
+	m.notify(key, value)
+}
+
+// notify hands the entry put to the watchers of its prefix, it must be called with the lock held
+func (m *memStore) notify(key, value string) {
+	for w := range m.watchers {
+		if strings.HasPrefix(key, w.prefix) {
+			w.pending = append(w.pending, Result{Key: key, Value: value})
+			w.signal()
+		}
+	}
+}
+
+func (m *memStore) get(key string, prefix bool) []Result {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !prefix {
+		if v, ok := m.data[key]; ok {
+			return []Result{{Key: key, Value: v}}
+		}
+		return nil
+	}
+	return m.scan(key)
+}
+
+func (m *memStore) delete(key string, prefix bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !prefix {
+		delete(m.data, key)
+		return
+	}
+	for k := range m.data {
+		if strings.HasPrefix(k, key) {
+			delete(m.data, k)
+		}
+	}
+}
+
+// watch sends the entries stored under the prefix followed by the entries put later on until the context is cancelled
+func (m *memStore) watch(ctx context.Context, prefix string) <-chan Result {
+	w := &memWatcher{prefix: prefix, notify: make(chan struct{}, 1)}
+	m.mu.Lock()
+	w.pending = m.scan(prefix)
+	w.signal()
+	m.watchers[w] = true
+	m.mu.Unlock()
+
+	ch := make(chan Result)
+	go func() {
+		defer close(ch)
+		defer func() {
+			m.mu.Lock()
+			delete(m.watchers, w)
+			m.mu.Unlock()
+		}()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-w.notify:
+			}
+			m.mu.Lock()
+			pending := w.pending
+			w.pending = nil
+			m.mu.Unlock()
+			for _, kv := range pending {
+				select {
+				case ch <- kv:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// snapshot returns a copy of every entry
+func (m *memStore) snapshot() map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data := make(map[string]string, len(m.data))
+	for k, v := range m.data {
+		data[k] = v
+	}
+	return data
+}
+
+// restore replaces every entry, the watchers are sent the entries which were added or changed
+// like when they are put
+func (m *memStore) restore(data map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	previous := m.data
+	m.data = data
+	keys := make([]string, 0, len(data))
+	for k, v := range data {
+		if old, ok := previous[k]; !ok || old != v {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		m.notify(k, data[k])
+	}
+}
+
+// scan must be called with the lock held
+func (m *memStore) scan(prefix string) []Result {
+	var results []Result
+	for k, v := range m.data {
+		if strings.HasPrefix(k, prefix) {
+			results = append(results, Result{Key: k, Value: v})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Key < results[j].Key })
+	return results
+}
+
+func (w *memWatcher) signal() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,49 @@
+package replication
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"time"
+)
+
+var (
+	coordinationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "event_bus_coordination_request_duration_seconds",
+		Help:    "Latency of the calls to the coordination backend such as etcd.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"op"})
+	replicationQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "event_bus_replication_queue_depth",
+		Help: "Chunks queued for the instance which are not fully replicated yet.",
+	})
+)
+
+// instrumentedBackend records the latency of every call to the backend
+type instrumentedBackend struct {
+	Backend
+}
+
+func (b instrumentedBackend) Put(ctx context.Context, key, value string) error {
+	defer observeCoordination("put", time.Now())
+	return b.Backend.Put(ctx, key, value)
+}
+
+func (b instrumentedBackend) Get(ctx context.Context, key string, prefix bool) ([]Result, error) {
+	defer observeCoordination("get", time.Now())
+	return b.Backend.Get(ctx, key, prefix)
+}
+
+func (b instrumentedBackend) Delete(ctx context.Context, key string, prefix bool) error {
+	defer observeCoordination("delete", time.Now())
+	return b.Backend.Delete(ctx, key, prefix)
+}
+
+func (b instrumentedBackend) Watch(ctx context.Context, prefix string) (<-chan Result, error) {
+	defer observeCoordination("watch", time.Now())
+	return b.Backend.Watch(ctx, prefix)
+}
+
+func observeCoordination(op string, start time.Time) {
+	coordinationDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+}
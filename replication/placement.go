@@ -0,0 +1,41 @@
+package replication
+
+import "sort"
+
+// PlacementPolicy picks the peers which receive a copy of a chunk
+type PlacementPolicy interface {
+	// Place returns up to n of the candidates, owner is the peer which already holds the chunk
+	Place(owner Peer, candidates []Peer, usage map[string]uint64, n int) []Peer
+}
+
+// ZoneAwarePlacement spreads the copies of a chunk across as many zones as possible and,
+// within the same zone, prefers the peers using the least disk space
+type ZoneAwarePlacement struct{}
+
+var _ PlacementPolicy = ZoneAwarePlacement{}
+
+func (ZoneAwarePlacement) Place(owner Peer, candidates []Peer, usage map[string]uint64, n int) []Peer {
+	remaining := make([]Peer, len(candidates))
+	copy(remaining, candidates)
+	sort.Slice(remaining, func(i, j int) bool {
+		if usage[remaining[i].Name] != usage[remaining[j].Name] {
+			return usage[remaining[i].Name] < usage[remaining[j].Name]
+		}
+		return remaining[i].Name < remaining[j].Name
+	})
+
+	zoneCopies := map[string]int{owner.Zone: 1}
+	var placed []Peer
+	for len(placed) < n && len(remaining) > 0 {
+		best := 0
+		for i := 1; i < len(remaining); i++ {
+			if zoneCopies[remaining[i].Zone] < zoneCopies[remaining[best].Zone] {
+				best = i
+			}
+		}
+		placed = append(placed, remaining[best])
+		zoneCopies[remaining[best].Zone]++
+		remaining = append(remaining[:best], remaining[best+1:]...)
+	}
+	return placed
+}
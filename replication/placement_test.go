@@ -0,0 +1,39 @@
+package replication
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestZoneAwarePlacement(t *testing.T) {
+	owner := Peer{Name: "luffy", Zone: "a"}
+	candidates := []Peer{
+		{Name: "zoro", Zone: "a"},
+		{Name: "nami", Zone: "b"},
+		{Name: "usopp", Zone: "b"},
+		{Name: "sanji", Zone: "c"},
+	}
+	usage := map[string]uint64{"zoro": 1, "nami": 50, "usopp": 10, "sanji": 100}
+
+	testCases := []struct {
+		desc string
+		n    int
+		want []string
+	}{
+		{desc: "other zones are preferred, least used first", n: 2, want: []string{"usopp", "sanji"}},
+		{desc: "zones are reused once all of them hold a copy", n: 3, want: []string{"usopp", "sanji", "zoro"}},
+		{desc: "no more copies than candidates", n: 10, want: []string{"usopp", "sanji", "zoro", "nami"}},
+		{desc: "no copies", n: 0, want: nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var got []string
+			for _, p := range (ZoneAwarePlacement{}).Place(owner, candidates, usage, tc.n) {
+				got = append(got, p.Name)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v want %v", got, tc.want)
+			}
+		})
+	}
+}
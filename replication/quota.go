@@ -0,0 +1,76 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// QuotaScope is what a quota applies to
+type QuotaScope string
+
+const (
+	// ClientQuota limits the requests of an authenticated principal, or of an address when
+	// authentication is disabled
+	ClientQuota QuotaScope = "clients"
+	// CategoryQuota limits the requests on a category whoever sends them
+	CategoryQuota QuotaScope = "categories"
+)
+
+// Quota limits the rate of the requests, every instance enforces it on the requests it receives,
+// a zero rate is unlimited
+type Quota struct {
+	// BytesPerSecond limits the bytes written
+	BytesPerSecond float64 `json:"bytesPerSecond,omitempty"`
+	// RequestsPerSecond limits the requests of any kind
+	RequestsPerSecond float64 `json:"requestsPerSecond,omitempty"`
+}
+
+// SetQuota overrides the default quota of the client or the category
+func (c *Client) SetQuota(ctx context.Context, scope QuotaScope, name string, quota Quota) error {
+	b, err := json.Marshal(quota)
+	if err != nil {
+		return err
+	}
+	return c.backend.Put(ctx, c.prefix+"quotas/"+string(scope)+"/"+name, string(b))
+}
+
+// GetQuota returns the quota overriding the default of the client or the category
+func (c *Client) GetQuota(ctx context.Context, scope QuotaScope, name string) (Quota, bool, error) {
+	resp, err := c.backend.Get(ctx, c.prefix+"quotas/"+string(scope)+"/"+name, false)
+	if err != nil {
+		return Quota{}, false, fmt.Errorf("error getting quota %w", err)
+	}
+	if len(resp) == 0 {
+		return Quota{}, false, nil
+	}
+	var quota Quota
+	if err := json.Unmarshal([]byte(resp[0].Value), &quota); err != nil {
+		return Quota{}, false, fmt.Errorf("error decoding quota of %s %w", name, err)
+	}
+	return quota, true, nil
+}
+
+// ListQuotas returns the quotas overriding the defaults by client or category
+func (c *Client) ListQuotas(ctx context.Context, scope QuotaScope) (map[string]Quota, error) {
+	prefix := c.prefix + "quotas/" + string(scope) + "/"
+	resp, err := c.backend.Get(ctx, prefix, true)
+	if err != nil {
+		return nil, fmt.Errorf("error getting quotas %w", err)
+	}
+	quotas := make(map[string]Quota, len(resp))
+	for _, kv := range resp {
+		var quota Quota
+		if err := json.Unmarshal([]byte(kv.Value), &quota); err != nil {
+			return nil, fmt.Errorf("error decoding quota %s %w", kv.Key, err)
+		}
+		quotas[strings.TrimPrefix(kv.Key, prefix)] = quota
+	}
+	return quotas, nil
+}
+
+// DeleteQuota restores the default quota of the client or the category
+func (c *Client) DeleteQuota(ctx context.Context, scope QuotaScope, name string) error {
+	return c.backend.Delete(ctx, c.prefix+"quotas/"+string(scope)+"/"+name, false)
+}
@@ -0,0 +1,446 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// RaftApplyPath is the endpoint of the leader accepting the changes made on the followers
+	RaftApplyPath = "/raft/apply"
+
+	raftStartTimeout      = time.Minute
+	raftPollInterval      = 10 * time.Millisecond
+	raftMaxPool           = 3
+	raftSnapshotsRetained = 2
+	// raftHTTPAddrKey stores the address where every leader accepts the changes forwarded by the followers
+	raftHTTPAddrKey = "raft/http/"
+)
+
+var errNoRaftLeader = errors.New("no raft leader elected")
+
+// RaftConfig describes the embedded raft group, every instance of the cluster is a voting member
+type RaftConfig struct {
+	// Instance is the raft server ID, it must be the same as the instance name
+	Instance string
+	// Addr is the address of the raft transport
+	Addr string
+	// HTTPAddr is the listen address of the event bus server, the followers send their changes there
+	HTTPAddr string
+	// Dir stores the raft log and snapshots
+	Dir string
+	// Peers maps every instance to its raft address, it is only used to bootstrap a new cluster
+	Peers map[string]string
+	// ServerTLS and ClientTLS encrypt the raft transport and the changes forwarded to the leader,
+	// plain connections are used when they are nil
+	ServerTLS *tls.Config
+	ClientTLS *tls.Config
+	// Token authenticates the changes forwarded to the leader when the servers require authentication
+	Token string
+}
+
+// RaftBackend keeps the cluster state in a raft group embedded in the event bus instances.
+// Reads are served from the local copy of the state, changes are applied through the leader.
+type RaftBackend struct {
+	cfg       RaftConfig
+	raft      *raft.Raft
+	boltStore *raftboltdb.BoltStore
+	store     *memStore
+	fsm       *raftFSM
+	httpCli   http.Client
+	logger    *slog.Logger
+}
+
+var _ Backend = (*RaftBackend)(nil)
+
+type raftCommand struct {
+	Op     string `json:"op"`
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Prefix bool   `json:"prefix,omitempty"`
+}
+
+const (
+	raftOpPut    = "put"
+	raftOpDelete = "delete"
+)
+
+type raftApplyResponse struct {
+	Index uint64 `json:"index"`
+}
+
+// NewRaftBackend starts the raft member of the current instance, bootstraps the group if it has no state yet
+// and waits until a leader is elected
+func NewRaftBackend(cfg RaftConfig) (*RaftBackend, error) {
+	if err := os.MkdirAll(cfg.Dir, 0777); err != nil {
+		return nil, fmt.Errorf("error creating raft directory %s %w", cfg.Dir, err)
+	}
+	b := &RaftBackend{
+		cfg:     cfg,
+		store:   newMemStore(),
+		httpCli: http.Client{Timeout: defaultTimeout},
+		logger:  slog.Default().With("instance", cfg.Instance),
+	}
+	b.fsm = &raftFSM{store: b.store}
+	if cfg.ClientTLS != nil {
+		b.httpCli.Transport = &http.Transport{TLSClientConfig: cfg.ClientTLS}
+	}
+
+	config := raft.DefaultConfig()
+	config.LocalID = raft.ServerID(cfg.Instance)
+	config.LogLevel = "INFO"
+	notify := make(chan bool, 1)
+	config.NotifyCh = notify
+
+	transport, err := newRaftTransport(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error creating raft transport %w", err)
+	}
+	snapshots, err := raft.NewFileSnapshotStore(cfg.Dir, raftSnapshotsRetained, os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("error creating raft snapshot store %w", err)
+	}
+	b.boltStore, err = raftboltdb.NewBoltStore(filepath.Join(cfg.Dir, "raft.db"))
+	if err != nil {
+		return nil, fmt.Errorf("error creating raft log store %w", err)
+	}
+
+	hasState, err := raft.HasExistingState(b.boltStore, b.boltStore, snapshots)
+	if err != nil {
+		return nil, fmt.Errorf("error reading raft state %w", err)
+	}
+	b.raft, err = raft.NewRaft(config, b.fsm, b.boltStore, b.boltStore, snapshots, transport)
+	if err != nil {
+		return nil, fmt.Errorf("error starting raft %w", err)
+	}
+	if !hasState {
+		if err := b.raft.BootstrapCluster(raft.Configuration{Servers: bootstrapServers(cfg)}).Error(); err != nil {
+			return nil, fmt.Errorf("error bootstrapping raft cluster %w", err)
+		}
+	}
+	go b.announce(notify)
+
+	ctx, cancel := context.WithTimeout(context.Background(), raftStartTimeout)
+	defer cancel()
+	if _, err := b.leader(ctx); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func newRaftTransport(cfg RaftConfig) (*raft.NetworkTransport, error) {
+	addr, err := net.ResolveTCPAddr("tcp", cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving raft address %s %w", cfg.Addr, err)
+	}
+	if cfg.ServerTLS == nil {
+		return raft.NewTCPTransport(cfg.Addr, addr, raftMaxPool, defaultTimeout, os.Stderr)
+	}
+	ln, err := tls.Listen("tcp", cfg.Addr, cfg.ServerTLS)
+	if err != nil {
+		return nil, err
+	}
+	layer := &tlsStreamLayer{Listener: ln, advertise: addr, client: cfg.ClientTLS}
+	return raft.NewNetworkTransport(layer, raftMaxPool, defaultTimeout, os.Stderr), nil
+}
+
+// tlsStreamLayer carries the raft transport over TLS
+type tlsStreamLayer struct {
+	net.Listener
+	advertise net.Addr
+	client    *tls.Config
+}
+
+func (l *tlsStreamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", string(address), l.client)
+}
+
+func (l *tlsStreamLayer) Addr() net.Addr {
+	return l.advertise
+}
+
+// bootstrapServers returns the initial members of the group, every instance must bootstrap with the same members
+func bootstrapServers(cfg RaftConfig) []raft.Server {
+	peers := map[string]string{cfg.Instance: cfg.Addr}
+	for instance, addr := range cfg.Peers {
+		peers[instance] = addr
+	}
+	servers := make([]raft.Server, 0, len(peers))
+	for instance, addr := range peers {
+		servers = append(servers, raft.Server{ID: raft.ServerID(instance), Address: raft.ServerAddress(addr)})
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].ID < servers[j].ID })
+	return servers
+}
+
+// announce publishes the HTTP address of the current instance every time it becomes the leader
+func (b *RaftBackend) announce(notify <-chan bool) {
+	for isLeader := range notify {
+		if !isLeader {
+			continue
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+			defer cancel()
+			if err := b.Put(ctx, raftHTTPAddrKey+b.cfg.Instance, b.cfg.HTTPAddr); err != nil {
+				b.logger.Error("error announcing raft leader address", "error", err)
+			}
+		}()
+	}
+}
+
+// Close stops the raft member of the current instance
+func (b *RaftBackend) Close() error {
+	if err := b.raft.Shutdown().Error(); err != nil {
+		return err
+	}
+	return b.boltStore.Close()
+}
+
+func (b *RaftBackend) Put(ctx context.Context, key, value string) error {
+	return b.apply(ctx, raftCommand{Op: raftOpPut, Key: key, Value: value})
+}
+
+func (b *RaftBackend) Get(ctx context.Context, key string, prefix bool) ([]Result, error) {
+	return b.store.get(key, prefix), nil
+}
+
+func (b *RaftBackend) Delete(ctx context.Context, key string, prefix bool) error {
+	return b.apply(ctx, raftCommand{Op: raftOpDelete, Key: key, Prefix: prefix})
+}
+
+func (b *RaftBackend) Watch(ctx context.Context, prefix string) (<-chan Result, error) {
+	return b.store.watch(ctx, prefix), nil
+}
+
+// Leadership makes the raft leader the leader of every category
+func (b *RaftBackend) Leadership(prefix, currentInstance string, ttl time.Duration) Leadership {
+	return &raftLeadership{backend: b}
+}
+
+// apply commits the command through the leader and waits until it is applied to the local state,
+// so that the change is visible to the next read
+func (b *RaftBackend) apply(ctx context.Context, cmd raftCommand) error {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	for {
+		index, err := b.applyOnce(ctx, data)
+		if err == nil {
+			return b.waitApplied(ctx, index)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("error applying %s of %s %v: %w", cmd.Op, cmd.Key, err, ctx.Err())
+		case <-time.After(leaderPollInterval):
+		}
+	}
+}
+
+func (b *RaftBackend) applyOnce(ctx context.Context, data []byte) (uint64, error) {
+	if b.raft.State() == raft.Leader {
+		return b.applyLocal(ctx, data)
+	}
+	_, leader := b.raft.LeaderWithID()
+	if leader == "" {
+		return 0, errNoRaftLeader
+	}
+	addrs := b.store.get(raftHTTPAddrKey+string(leader), false)
+	if len(addrs) == 0 {
+		return 0, fmt.Errorf("address of raft leader %s is not known yet", leader)
+	}
+
+	scheme := "http://"
+	if b.cfg.ClientTLS != nil {
+		scheme = "https://"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, scheme+addrs[0].Value+RaftApplyPath, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set(ForwardedHeader, b.cfg.Instance)
+	if b.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.cfg.Token)
+	}
+	resp, err := b.httpCli.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("raft leader %s returned status %d %s", leader, resp.StatusCode, body)
+	}
+	var res raftApplyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return 0, err
+	}
+	return res.Index, nil
+}
+
+func (b *RaftBackend) applyLocal(ctx context.Context, data []byte) (uint64, error) {
+	timeout := defaultTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	f := b.raft.Apply(data, timeout)
+	if err := f.Error(); err != nil {
+		return 0, err
+	}
+	if err, ok := f.Response().(error); ok {
+		return 0, err
+	}
+	return f.Index(), nil
+}
+
+func (b *RaftBackend) waitApplied(ctx context.Context, index uint64) error {
+	for b.fsm.applied.Load() < index {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("error waiting for raft index %d %w", index, ctx.Err())
+		case <-time.After(raftPollInterval):
+		}
+	}
+	return nil
+}
+
+func (b *RaftBackend) leader(ctx context.Context) (string, error) {
+	for {
+		if _, id := b.raft.LeaderWithID(); id != "" {
+			return string(id), nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("%w %v", errNoRaftLeader, ctx.Err())
+		case <-time.After(leaderPollInterval):
+		}
+	}
+}
+
+// ServeHTTP applies the changes forwarded by the followers when the current instance is the leader
+func (b *RaftBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if b.raft.State() != raft.Leader {
+		http.Error(w, "not the raft leader", http.StatusServiceUnavailable)
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var cmd raftCommand
+	if err := json.Unmarshal(data, &cmd); err != nil || (cmd.Op != raftOpPut && cmd.Op != raftOpDelete) {
+		http.Error(w, "invalid raft command", http.StatusBadRequest)
+		return
+	}
+	index, err := b.applyLocal(r.Context(), data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(raftApplyResponse{Index: index}); err != nil {
+		b.logger.Warn("error writing raft apply response", "error", err)
+	}
+}
+
+// raftLeadership follows the raft leader, there is a single leader for every category
+type raftLeadership struct {
+	backend *RaftBackend
+}
+
+func (l *raftLeadership) Leader(ctx context.Context, category string) (string, error) {
+	leader, err := l.backend.leader(ctx)
+	if err != nil {
+		return "", fmt.Errorf("no leader elected for category %s %w", category, err)
+	}
+	return leader, nil
+}
+
+// Close hands the raft leadership over to another member
+func (l *raftLeadership) Close() error {
+	if l.backend.raft.State() != raft.Leader {
+		return nil
+	}
+	return l.backend.raft.LeadershipTransfer().Error()
+}
+
+// raftFSM applies the committed commands to the local copy of the cluster state. It tracks the
+// index of the last command it applied since raft reports entries as applied as soon as they
+// are handed over to the FSM, before they are visible in the state.
+type raftFSM struct {
+	store   *memStore
+	applied atomic.Uint64
+}
+
+func (f *raftFSM) Apply(l *raft.Log) interface{} {
+	defer f.applied.Store(l.Index)
+	var cmd raftCommand
+	if err := json.Unmarshal(l.Data, &cmd); err != nil {
+		return fmt.Errorf("error decoding raft command %w", err)
+	}
+	store := f.store
+	switch cmd.Op {
+	case raftOpPut:
+		store.put(cmd.Key, cmd.Value)
+	case raftOpDelete:
+		store.delete(cmd.Key, cmd.Prefix)
+	default:
+		return fmt.Errorf("unknown raft command %s", cmd.Op)
+	}
+	return nil
+}
+
+// Snapshot is never called concurrently with Apply, the index of the last command applied is the one of the snapshot
+func (f *raftFSM) Snapshot() (raft.FSMSnapshot, error) {
+	return raftSnapshot{Index: f.applied.Load(), Data: f.store.snapshot()}, nil
+}
+
+func (f *raftFSM) Restore(snapshot io.ReadCloser) error {
+	defer snapshot.Close()
+	var s raftSnapshot
+	if err := json.NewDecoder(snapshot).Decode(&s); err != nil {
+		return fmt.Errorf("error decoding raft snapshot %w", err)
+	}
+	if s.Data == nil {
+		s.Data = make(map[string]string)
+	}
+	f.store.restore(s.Data)
+	f.applied.Store(s.Index)
+	return nil
+}
+
+// raftSnapshot is the state of the cluster once the command at Index was applied
+type raftSnapshot struct {
+	Index uint64            `json:"index"`
+	Data  map[string]string `json:"data"`
+}
+
+func (s raftSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := json.NewEncoder(sink).Encode(s); err != nil {
+		_ = sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s raftSnapshot) Release() {}
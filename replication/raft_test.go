@@ -0,0 +1,276 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/Vignesh-Rajarajan/event-bus/tlsconfig"
+	"github.com/Vignesh-Rajarajan/event-bus/tlsconfig/tlsconfigtest"
+	"github.com/hashicorp/raft"
+	"github.com/phayes/freeport"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func startRaftCluster(t *testing.T, instances ...string) map[string]*RaftBackend {
+	t.Helper()
+	return startRaftClusterWithTLS(t, nil, instances...)
+}
+
+// startRaftClusterWithTLS starts the members with certificates issued by the CA when it is not nil
+func startRaftClusterWithTLS(t *testing.T, ca *tlsconfigtest.CA, instances ...string) map[string]*RaftBackend {
+	t.Helper()
+	tlsConfigs := make(map[string]tlsconfig.Config)
+	peers := make(map[string]string)
+	servers := make(map[string]*httptest.Server)
+	handlers := make(map[string]http.Handler)
+	var handlersMu sync.Mutex
+	for _, instance := range instances {
+		port, err := freeport.GetFreePort()
+		if err != nil {
+			t.Fatalf("error getting free port %v", err)
+		}
+		peers[instance] = fmt.Sprintf("127.0.0.1:%d", port)
+		instance := instance
+		servers[instance] = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlersMu.Lock()
+			h := handlers[instance]
+			handlersMu.Unlock()
+			if h == nil {
+				http.Error(w, "not started", http.StatusServiceUnavailable)
+				return
+			}
+			h.ServeHTTP(w, r)
+		}))
+		if ca == nil {
+			servers[instance].Start()
+		} else {
+			tlsConfigs[instance] = ca.Issue(instance)
+			cert, err := tls.LoadX509KeyPair(tlsConfigs[instance].CertFile, tlsConfigs[instance].KeyFile)
+			if err != nil {
+				t.Fatalf("error loading certificate %v", err)
+			}
+			servers[instance].TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+			servers[instance].StartTLS()
+		}
+		t.Cleanup(servers[instance].Close)
+	}
+
+	backends := make(map[string]*RaftBackend)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, instance := range instances {
+		wg.Add(1)
+		go func(instance string) {
+			defer wg.Done()
+			cfg := RaftConfig{
+				Instance: instance,
+				Addr:     peers[instance],
+				HTTPAddr: servers[instance].Listener.Addr().String(),
+				Dir:      t.TempDir(),
+				Peers:    peers,
+			}
+			if ca != nil {
+				var err error
+				if cfg.ServerTLS, err = tlsConfigs[instance].Server(); err != nil {
+					t.Errorf("error creating server TLS config %v", err)
+					return
+				}
+				if cfg.ClientTLS, err = tlsConfigs[instance].Client(); err != nil {
+					t.Errorf("error creating client TLS config %v", err)
+					return
+				}
+			}
+			b, err := NewRaftBackend(cfg)
+			if err != nil {
+				t.Errorf("error starting raft backend of %s %v", instance, err)
+				return
+			}
+			handlersMu.Lock()
+			handlers[instance] = b
+			handlersMu.Unlock()
+			mu.Lock()
+			backends[instance] = b
+			mu.Unlock()
+		}(instance)
+	}
+	wg.Wait()
+	if t.Failed() {
+		t.FailNow()
+	}
+	t.Cleanup(func() {
+		for _, b := range backends {
+			_ = b.Close()
+		}
+	})
+	return backends
+}
+
+func TestRaftBackend(t *testing.T) {
+	backends := startRaftCluster(t, "luffy", "zoro", "nami")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	watch, err := backends["nami"].Watch(ctx, "events/default/replication/nami/")
+	if err != nil {
+		t.Fatalf("error watching %v", err)
+	}
+
+	// every member accepts writes, the followers forward them to the leader
+	for _, instance := range []string{"luffy", "zoro", "nami"} {
+		client := NewClientWithBackend(backends[instance], "default")
+		if err := client.RegisterPeer(ctx, Peer{Name: instance, Addr: instance + ":8080"}); err != nil {
+			t.Fatalf("error registering peer %s %v", instance, err)
+		}
+		// changes are visible to the next read on the instance which made them
+		peers, err := client.ListPeers(ctx)
+		if err != nil {
+			t.Fatalf("error listing peers %v", err)
+		}
+		if !containsPeer(peers, instance) {
+			t.Errorf("peer %s is not listed on itself, got %v", instance, peers)
+		}
+	}
+
+	client := NewClientWithBackend(backends["zoro"], "default")
+	want := Chunk{OwnedBy: "zoro", Category: "numbers", FileName: "zoro-chunk000000000"}
+	if err := client.AddChunkToReplicationQueue(ctx, "nami", want); err != nil {
+		t.Fatalf("error queueing chunk %v", err)
+	}
+	select {
+	case kv := <-watch:
+		if want := "events/default/replication/nami/numbers/zoro-chunk000000000"; kv.Key != want || kv.Value != "zoro" {
+			t.Errorf("watch got %v, want key %s", kv, want)
+		}
+	case <-ctx.Done():
+		t.Fatalf("chunk added to the queue was not watched")
+	}
+
+	if err := client.DeleteReplicationQueue(ctx, "nami"); err != nil {
+		t.Fatalf("error deleting queue %v", err)
+	}
+	got, err := backends["zoro"].Get(ctx, "events/default/replication/", true)
+	if err != nil {
+		t.Fatalf("error getting queue %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("queue was not deleted, got %v", got)
+	}
+
+	var leaders []string
+	for _, instance := range []string{"luffy", "zoro", "nami"} {
+		leader, err := NewLeadership(NewClientWithBackend(backends[instance], "default"), instance, 0).Leader(ctx, "numbers")
+		if err != nil {
+			t.Fatalf("error getting leader %v", err)
+		}
+		leaders = append(leaders, leader)
+	}
+	if leaders[0] != leaders[1] || leaders[1] != leaders[2] {
+		t.Errorf("members disagree on the leader %v", leaders)
+	}
+}
+
+func containsPeer(peers []Peer, name string) bool {
+	for _, p := range peers {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRaftBackendOverTLS(t *testing.T) {
+	backends := startRaftClusterWithTLS(t, tlsconfigtest.NewCA(t), "luffy", "zoro", "nami")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// the followers forward their changes to the leader over TLS and receive them through the encrypted transport
+	for _, instance := range []string{"luffy", "zoro", "nami"} {
+		client := NewClientWithBackend(backends[instance], "default")
+		if err := client.RegisterPeer(ctx, Peer{Name: instance, Addr: instance + ":8080"}); err != nil {
+			t.Fatalf("error registering peer %s %v", instance, err)
+		}
+	}
+	for instance, b := range backends {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			peers, err := NewClientWithBackend(b, "default").ListPeers(ctx)
+			if err == nil && len(peers) == 3 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s got peers %v %v", instance, peers, err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// snapshotSink keeps the snapshot in memory
+type snapshotSink struct {
+	bytes.Buffer
+}
+
+func (s *snapshotSink) ID() string    { return "test" }
+func (s *snapshotSink) Cancel() error { return nil }
+func (s *snapshotSink) Close() error  { return nil }
+
+func TestRaftFSMRestore(t *testing.T) {
+	apply := func(f *raftFSM, index uint64, cmd raftCommand) {
+		t.Helper()
+		data, err := json.Marshal(cmd)
+		if err != nil {
+			t.Fatalf("error encoding command %v", err)
+		}
+		if err, _ := f.Apply(&raft.Log{Index: index, Data: data}).(error); err != nil {
+			t.Fatalf("error applying command %v", err)
+		}
+	}
+	leader := &raftFSM{store: newMemStore()}
+	apply(leader, 4, raftCommand{Op: raftOpPut, Key: "events/test/replication/zoro/numbers/luffy-chunk000000000", Value: "luffy"})
+	apply(leader, 5, raftCommand{Op: raftOpPut, Key: "events/test/peers/luffy", Value: "127.0.0.1:8080"})
+	snapshot, err := leader.Snapshot()
+	if err != nil {
+		t.Fatalf("error taking snapshot %v", err)
+	}
+	var sink snapshotSink
+	if err := snapshot.Persist(&sink); err != nil {
+		t.Fatalf("error persisting snapshot %v", err)
+	}
+
+	// a member far behind is sent the snapshot instead of the commands
+	follower := &raftFSM{store: newMemStore()}
+	apply(follower, 1, raftCommand{Op: raftOpPut, Key: "events/test/peers/luffy", Value: "127.0.0.1:8080"})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	watch := follower.store.watch(ctx, "events/test/")
+	if kv := <-watch; kv.Key != "events/test/peers/luffy" {
+		t.Fatalf("got %v stored before the snapshot", kv)
+	}
+	if err := follower.Restore(io.NopCloser(&sink)); err != nil {
+		t.Fatalf("error restoring snapshot %v", err)
+	}
+	if got := follower.applied.Load(); got != 5 {
+		t.Errorf("applied index %d after restoring the snapshot, want 5", got)
+	}
+	// only the entries changed by the snapshot are sent to the watchers
+	select {
+	case kv := <-watch:
+		if kv.Key != "events/test/replication/zoro/numbers/luffy-chunk000000000" || kv.Value != "luffy" {
+			t.Errorf("got %v once the snapshot was restored", kv)
+		}
+	case <-ctx.Done():
+		t.Fatalf("the entries of the snapshot were not watched")
+	}
+	select {
+	case kv := <-watch:
+		t.Errorf("unchanged entry %v sent again", kv)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,329 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Vignesh-Rajarajan/event-bus/chunk"
+	"github.com/Vignesh-Rajarajan/event-bus/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultReplicationBufferSize = 4 * 1024 * 1024
+	// maxReplicationBufferSize bounds the buffer grown to download a message larger than the default one
+	maxReplicationBufferSize = 64 * 1024 * 1024
+	retryInterval            = 500 * time.Millisecond
+	maxWatchBackoff          = 30 * time.Second
+)
+
+// ForwardedHeader marks requests sent by a peer, they are always served by the receiving instance itself
+const ForwardedHeader = "X-Event-Bus-Forwarded"
+
+var (
+	errNoNewData      = errors.New("no new data yet")
+	errBufferTooSmall = errors.New("the next message does not fit in the buffer")
+)
+
+// bufferPool holds the download buffers of the default size, the grown ones are dropped
+var bufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, defaultReplicationBufferSize)
+		return &buf
+	},
+}
+
+// DirectWriter stores the chunks downloaded from other instances
+type DirectWriter interface {
+	Stat(category, fileName string) (size uint64, exists bool, err error)
+	WriteDirect(category, fileName string, contents []byte) error
+	// CompleteCopy is called once the chunk has been copied completely
+	CompleteCopy(category, fileName string) error
+}
+
+// Replicator downloads the chunks queued for the current instance from their owners
+type Replicator struct {
+	client          Coordinator
+	currentInstance string
+	writer          DirectWriter
+	httpCli         http.Client
+	scheme          string
+	token           string
+	logger          *slog.Logger
+
+	mu         sync.Mutex
+	inProgress map[string]bool
+}
+
+// ReplicatorOption configures optional behaviour of the replicator
+type ReplicatorOption func(*Replicator)
+
+// WithPeerTLS downloads the chunks from the owners over TLS
+func WithPeerTLS(cfg *tls.Config) ReplicatorOption {
+	return func(r *Replicator) {
+		r.httpCli.Transport = &http.Transport{TLSClientConfig: cfg}
+		r.scheme = "https"
+	}
+}
+
+// WithPeerToken authenticates the downloads with the cluster token
+func WithPeerToken(token string) ReplicatorOption {
+	return func(r *Replicator) {
+		r.token = token
+	}
+}
+
+func NewReplicator(client Coordinator, currentInstance string, writer DirectWriter, opts ...ReplicatorOption) *Replicator {
+	r := &Replicator{
+		client:          client,
+		currentInstance: currentInstance,
+		writer:          writer,
+		httpCli:         http.Client{Timeout: defaultTimeout},
+		scheme:          "http",
+		logger:          slog.Default().With("instance", currentInstance),
+		inProgress:      make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Loop watches the replication queue and downloads the chunks until the context is cancelled, the
+// watch is established again with a backoff whenever it ends
+func (r *Replicator) Loop(ctx context.Context) error {
+	backoff := retryInterval
+	for {
+		queue, err := r.client.WatchReplicationQueue(ctx, r.currentInstance)
+		if err != nil {
+			r.logger.Warn("error watching replication queue, retrying", "error", err, "backoff", backoff)
+		} else {
+			r.process(ctx, queue)
+			backoff = retryInterval
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxWatchBackoff)
+	}
+}
+
+// process starts downloading every chunk sent by the watch until it is closed
+func (r *Replicator) process(ctx context.Context, queue <-chan Chunk) {
+	for ch := range queue {
+		key := ch.Category + "/" + ch.FileName
+		r.mu.Lock()
+		if r.inProgress[key] {
+			r.mu.Unlock()
+			continue
+		}
+		r.inProgress[key] = true
+		r.mu.Unlock()
+		replicationQueueDepth.Inc()
+
+		go func(ch Chunk) {
+			defer func() {
+				r.mu.Lock()
+				delete(r.inProgress, key)
+				r.mu.Unlock()
+				replicationQueueDepth.Dec()
+			}()
+			if err := r.replicate(ctx, ch); err != nil && !errors.Is(err, context.Canceled) {
+				r.logger.Error("error replicating chunk", "category", ch.Category, "chunk", ch.FileName, "owner", ch.OwnedBy, "error", err)
+			}
+		}(ch)
+	}
+}
+
+func (r *Replicator) replicate(ctx context.Context, ch Chunk) error {
+	pooled := bufferPool.Get().(*[]byte)
+	defer bufferPool.Put(pooled)
+	buf := *pooled
+	for {
+		done, err := r.replicateStep(ctx, ch, buf)
+		if errors.Is(err, errBufferTooSmall) {
+			if len(buf) >= maxReplicationBufferSize {
+				return fmt.Errorf("a message of chunk %s is larger than %d bytes and cannot be copied", ch.FileName, maxReplicationBufferSize)
+			}
+			buf = make([]byte, min(2*len(buf), maxReplicationBufferSize))
+			continue
+		}
+		if err != nil && !errors.Is(err, errNoNewData) {
+			r.logger.Warn("error downloading chunk, retrying", "category", ch.Category, "chunk", ch.FileName, "owner", ch.OwnedBy, "error", err)
+		}
+		if done {
+			return r.client.DeleteChunkFromReplicationQueue(ctx, r.currentInstance, ch)
+		}
+		if err == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// replicateStep downloads the next part of the chunk and reports whether the chunk is fully replicated
+func (r *Replicator) replicateStep(ctx context.Context, ch Chunk, buf []byte) (bool, error) {
+	size, _, err := r.writer.Stat(ch.Category, ch.FileName)
+	if err != nil {
+		return false, err
+	}
+	addr, err := r.ownerAddr(ctx, ch.OwnedBy)
+	if err != nil {
+		return false, err
+	}
+	start := time.Now()
+	contents, producers, err := r.download(ctx, addr, ch, size, buf)
+	if err != nil {
+		// the chunk might have been acked or its category deleted on the owner in the meantime
+		if _, found, ownerErr := r.ownerChunk(ctx, addr, ch); ownerErr == nil && !found {
+			return true, nil
+		}
+		return false, err
+	}
+	if len(contents) > 0 {
+		return false, r.store(ctx, ch, size, contents, producers, start)
+	}
+
+	ownerChunk, found, err := r.ownerChunk(ctx, addr, ch)
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		// the chunk was already acked on the owner, there is nothing left to copy
+		return true, nil
+	}
+	if !ownerChunk.Complete || size < ownerChunk.Size {
+		return false, errNoNewData
+	}
+	if err := r.writer.CompleteCopy(ch.Category, ch.FileName); err != nil {
+		return false, err
+	}
+	return true, r.client.SetReplicaState(ctx, ch.Category, ReplicaState{
+		Instance: r.currentInstance,
+		FileName: ch.FileName,
+		Size:     size,
+		Complete: true,
+	})
+}
+
+// store appends the downloaded contents to the local copy, the span is linked to the traces
+// that produced the copied messages
+func (r *Replicator) store(ctx context.Context, ch Chunk, size uint64, contents []byte, producers []trace.Link, start time.Time) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Replicator.replicate",
+		trace.WithTimestamp(start),
+		trace.WithLinks(producers...),
+		trace.WithAttributes(
+			attribute.String("category", ch.Category),
+			attribute.String("chunk", ch.FileName),
+			attribute.String("owner", ch.OwnedBy),
+			attribute.Int64("offset", int64(size)),
+			attribute.Int("bytes", len(contents)),
+		))
+	defer func() { tracing.End(span, err) }()
+
+	if err := r.writer.WriteDirect(ch.Category, ch.FileName, contents); err != nil {
+		return err
+	}
+	return r.client.SetReplicaState(ctx, ch.Category, ReplicaState{
+		Instance: r.currentInstance,
+		FileName: ch.FileName,
+		Size:     size + uint64(len(contents)),
+	})
+}
+
+func (r *Replicator) ownerAddr(ctx context.Context, owner string) (string, error) {
+	peers, err := r.client.ListPeers(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range peers {
+		if p.Name == owner {
+			return p.Addr, nil
+		}
+	}
+	return "", fmt.Errorf("owner %s is not registered", owner)
+}
+
+func (r *Replicator) download(ctx context.Context, addr string, ch Chunk, offset uint64, buf []byte) ([]byte, []trace.Link, error) {
+	u := url.Values{}
+	u.Add("category", ch.Category)
+	u.Add("chunk", ch.FileName)
+	u.Add("offset", strconv.FormatUint(offset, 10))
+	u.Add("maxSize", strconv.Itoa(len(buf)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s://%s/read?%s", r.scheme, addr, u.Encode()), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set(ForwardedHeader, r.currentInstance)
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+	resp, err := r.httpCli.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusRequestEntityTooLarge {
+		return nil, nil, errBufferTooSmall
+	}
+	if resp.StatusCode != http.StatusOK {
+		var b bytes.Buffer
+		_, _ = io.Copy(&b, resp.Body)
+		return nil, nil, fmt.Errorf("status code:: %d - error::%s ", resp.StatusCode, b.String())
+	}
+	b := bytes.NewBuffer(buf[0:0])
+	if _, err := io.Copy(b, resp.Body); err != nil {
+		return nil, nil, err
+	}
+	return b.Bytes(), tracing.ParseProducers(resp.Header.Get(tracing.ProducersHeader)), nil
+}
+
+func (r *Replicator) ownerChunk(ctx context.Context, addr string, ch Chunk) (chunk.Chunk, bool, error) {
+	u := url.Values{}
+	u.Add("category", ch.Category)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s://%s/listChunks?%s", r.scheme, addr, u.Encode()), nil)
+	if err != nil {
+		return chunk.Chunk{}, false, err
+	}
+	req.Header.Set(ForwardedHeader, r.currentInstance)
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+	resp, err := r.httpCli.Do(req)
+	if err != nil {
+		return chunk.Chunk{}, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var b bytes.Buffer
+		_, _ = io.Copy(&b, resp.Body)
+		return chunk.Chunk{}, false, fmt.Errorf("status code:: %d - error::%s ", resp.StatusCode, b.String())
+	}
+	var chunks []chunk.Chunk
+	if err := json.NewDecoder(resp.Body).Decode(&chunks); err != nil {
+		return chunk.Chunk{}, false, err
+	}
+	for _, c := range chunks {
+		if c.Name == ch.FileName {
+			return c, true, nil
+		}
+	}
+	return chunk.Chunk{}, false, nil
+}
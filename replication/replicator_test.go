@@ -0,0 +1,110 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/Vignesh-Rajarajan/event-bus/chunk"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// closingWatch ends every watch of the replication queue straight away
+type closingWatch struct {
+	Coordinator
+	watches atomic.Int32
+}
+
+func (c *closingWatch) WatchReplicationQueue(context.Context, string) (<-chan Chunk, error) {
+	c.watches.Add(1)
+	ch := make(chan Chunk)
+	close(ch)
+	return ch, nil
+}
+
+func TestReplicatorWatchesAgain(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	coordinator := &closingWatch{Coordinator: NewClientWithBackend(NewMemoryBackend(), "default")}
+	r := NewReplicator(coordinator, "luffy", nil)
+
+	done := make(chan error, 1)
+	go func() { done <- r.Loop(ctx) }()
+	for coordinator.watches.Load() < 2 {
+		select {
+		case err := <-done:
+			t.Fatalf("Loop() returned %v once the watch ended", err)
+		case <-ctx.Done():
+			t.Fatalf("the watch was established %d times", coordinator.watches.Load())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Loop() = %v, want %v", err, context.Canceled)
+	}
+}
+
+// memoryWriter keeps the copied chunks in memory
+type memoryWriter struct {
+	contents map[string][]byte
+	complete map[string]bool
+}
+
+func (w *memoryWriter) Stat(category, fileName string) (uint64, bool, error) {
+	c, ok := w.contents[category+"/"+fileName]
+	return uint64(len(c)), ok, nil
+}
+
+func (w *memoryWriter) WriteDirect(category, fileName string, contents []byte) error {
+	w.contents[category+"/"+fileName] = append(w.contents[category+"/"+fileName], contents...)
+	return nil
+}
+
+func (w *memoryWriter) CompleteCopy(category, fileName string) error {
+	w.complete[category+"/"+fileName] = true
+	return nil
+}
+
+func TestReplicatorGrowsBuffer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg := append(bytes.Repeat([]byte("a"), defaultReplicationBufferSize), '\n')
+	owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/read":
+			maxSize, _ := strconv.Atoi(req.URL.Query().Get("maxSize"))
+			offset, _ := strconv.Atoi(req.URL.Query().Get("offset"))
+			if offset < len(msg) && maxSize < len(msg) {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				return
+			}
+			_, _ = w.Write(msg[offset:])
+		case "/listChunks":
+			_ = json.NewEncoder(w).Encode([]chunk.Chunk{{Name: "zoro-chunk000000000", Complete: true, Size: uint64(len(msg))}})
+		}
+	}))
+	defer owner.Close()
+
+	client := NewClientWithBackend(NewMemoryBackend(), "default")
+	if err := client.RegisterPeer(ctx, Peer{Name: "zoro", Addr: strings.TrimPrefix(owner.URL, "http://")}); err != nil {
+		t.Fatalf("RegisterPeer() = %v", err)
+	}
+	writer := &memoryWriter{contents: make(map[string][]byte), complete: make(map[string]bool)}
+	r := NewReplicator(client, "luffy", writer)
+	ch := Chunk{Category: "numbers", FileName: "zoro-chunk000000000", OwnedBy: "zoro"}
+	if err := r.replicate(ctx, ch); err != nil {
+		t.Fatalf("replicate() = %v", err)
+	}
+	if got := writer.contents["numbers/zoro-chunk000000000"]; !bytes.Equal(got, msg) {
+		t.Errorf("copied %d bytes, want %d", len(got), len(msg))
+	}
+	if !writer.complete["numbers/zoro-chunk000000000"] {
+		t.Errorf("the copy was not completed")
+	}
+}
@@ -6,30 +6,106 @@ import (
 )
 
 type Storage struct {
-	client          *Client
-	currentInstance string
+	client            Coordinator
+	currentInstance   string
+	replicationFactor int
+	placement         PlacementPolicy
 }
 
-func NewStorage(client *Client, currentInstance string) *Storage {
-	return &Storage{client: client, currentInstance: currentInstance}
+// StorageOption configures how chunks are replicated
+type StorageOption func(*Storage)
+
+// WithReplicationFactor sets the number of copies of every chunk including the owner's one,
+// zero or less keeps a copy on every peer
+func WithReplicationFactor(n int) StorageOption {
+	return func(s *Storage) {
+		s.replicationFactor = n
+	}
+}
+
+// WithPlacement sets the policy deciding which peers receive the copies
+func WithPlacement(policy PlacementPolicy) StorageOption {
+	return func(s *Storage) {
+		s.placement = policy
+	}
+}
+
+func NewStorage(client Coordinator, currentInstance string, opts ...StorageOption) *Storage {
+	s := &Storage{client: client, currentInstance: currentInstance, placement: ZoneAwarePlacement{}}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Init queues the new chunk for replication to as many peers as the replication factor of its
+// category asks for, zero keeps the default one
+func (s *Storage) Init(ctx context.Context, category, fileName string, replicationFactor int) error {
+	peers, err := s.client.ListPeers(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get peers %w", err)
+	}
+	owner := Peer{Name: s.currentInstance}
+	for _, p := range peers {
+		if p.Name == s.currentInstance {
+			owner = p
+		}
+	}
+	_, err = s.Replicate(ctx, category, fileName, owner, map[string]bool{s.currentInstance: true}, s.Copies(replicationFactor, len(peers))-1)
+	return err
 }
 
-func (s *Storage) Init(ctx context.Context, category, fileName string) error {
+// Copies returns how many copies of a chunk the cluster should hold when it has the given number of
+// peers, the replication factor of the category overrides the default one unless it is zero
+func (s *Storage) Copies(factor, peers int) int {
+	if factor <= 0 {
+		factor = s.replicationFactor
+	}
+	if factor <= 0 || factor > peers {
+		return peers
+	}
+	return factor
+}
+
+// Replicate queues the chunk for up to n peers picked by the placement policy, skipping the peers
+// in exclude and the ones being decommissioned, the chunk is downloaded from source
+func (s *Storage) Replicate(ctx context.Context, category, fileName string, source Peer, exclude map[string]bool, n int) ([]Peer, error) {
+	if n <= 0 {
+		return nil, nil
+	}
 	peers, err := s.client.ListPeers(ctx)
 	if err != nil {
-		return fmt.Errorf("could not get peers from etcd %w", err)
+		return nil, fmt.Errorf("could not get peers %w", err)
+	}
+	draining, err := s.client.ListDrainStates(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get draining peers %w", err)
+	}
+	usage, err := s.client.ListDiskUsage(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get disk usage %w", err)
 	}
+	var candidates []Peer
 	for _, peer := range peers {
-		if peer.Name == s.currentInstance {
+		if exclude[peer.Name] || peer.Name == source.Name {
 			continue
 		}
+		if _, ok := draining[peer.Name]; ok {
+			// instances being decommissioned do not receive new chunks
+			continue
+		}
+		candidates = append(candidates, peer)
+	}
+
+	targets := s.placement.Place(source, candidates, usage, n)
+	for _, peer := range targets {
 		if err := s.client.AddChunkToReplicationQueue(ctx, peer.Name, Chunk{
 			Category: category,
 			FileName: fileName,
-			OwnedBy:  s.currentInstance,
+			OwnedBy:  source.Name,
 		}); err != nil {
-			return fmt.Errorf("could not send file to peer %s %w", peer.Name, err)
+			return nil, fmt.Errorf("could not send file to peer %s %w", peer.Name, err)
 		}
 	}
-	return nil
+	return targets, nil
 }
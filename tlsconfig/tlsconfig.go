@@ -0,0 +1,143 @@
+// Package tlsconfig builds the TLS configuration of the listener and of the connections to the peers
+// from certificate files, the certificate of the instance is reloaded when its files change
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Config lists the files making up the TLS setup of an instance
+type Config struct {
+	// CertFile and KeyFile hold the certificate presented by the instance, both to its clients and,
+	// when mutual TLS is used, to the peers it connects to in which case it must allow client
+	// authentication too
+	CertFile string
+	KeyFile  string
+	// CAFile verifies the certificates of the servers the instance connects to, the system roots
+	// are used when it is empty
+	CAFile string
+	// ClientCAFile verifies the certificates of the clients, a valid client certificate is required when it is set
+	ClientCAFile string
+}
+
+// IsZero reports whether no file is configured, in which case plain connections are used
+func (c Config) IsZero() bool {
+	return c == Config{}
+}
+
+// Server returns the configuration of the listener
+func (c Config) Server() (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, fmt.Errorf("both the certificate and the key are required to serve TLS")
+	}
+	reloader, err := newCertReloader(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return reloader.certificate() },
+	}
+	if c.ClientCAFile != "" {
+		pool, err := loadPool(c.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// Client returns the configuration of the connections to the peers and to the coordination backend,
+// the certificate of the instance is presented when the server asks for one
+func (c Config) Client() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.CAFile != "" {
+		pool, err := loadPool(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		reloader, err := newCertReloader(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) { return reloader.certificate() }
+	}
+	return cfg, nil
+}
+
+func loadPool(file string) (*x509.CertPool, error) {
+	contents, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA file %s %w", file, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(contents) {
+		return nil, fmt.Errorf("no certificate found in CA file %s", file)
+	}
+	return pool, nil
+}
+
+// certReloader loads the certificate again when its files are modified, so that certificates
+// can be renewed without restarting the instance
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modTimes [2]time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.certificate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) certificate() (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	modTimes, err := r.stat()
+	if err == nil && r.cert != nil && modTimes == r.modTimes {
+		return r.cert, nil
+	}
+	if err == nil {
+		var cert tls.Certificate
+		cert, err = tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err == nil {
+			r.cert, r.modTimes = &cert, modTimes
+			return r.cert, nil
+		}
+	}
+	if r.cert == nil {
+		return nil, fmt.Errorf("error loading certificate %s %w", r.certFile, err)
+	}
+	// the files might be in the middle of being replaced, keep serving the previous certificate
+	slog.Warn("error reloading certificate, keeping the previous one", "cert", r.certFile, "error", err)
+	return r.cert, nil
+}
+
+func (r *certReloader) stat() ([2]time.Time, error) {
+	var modTimes [2]time.Time
+	for i, file := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = fi.ModTime()
+	}
+	return modTimes, nil
+}
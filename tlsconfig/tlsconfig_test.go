@@ -0,0 +1,90 @@
+package tlsconfig_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/Vignesh-Rajarajan/event-bus/tlsconfig"
+	"github.com/Vignesh-Rajarajan/event-bus/tlsconfig/tlsconfigtest"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func serve(t *testing.T, cfg tlsconfig.Config) string {
+	t.Helper()
+	serverTLS, err := cfg.Server()
+	if err != nil {
+		t.Fatalf("error creating server config %v", err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverTLS)
+	if err != nil {
+		t.Fatalf("error listening %v", err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(func() { _ = srv.Close() })
+	return ln.Addr().String()
+}
+
+func dial(addr string, cfg tlsconfig.Config) (*x509.Certificate, error) {
+	clientTLS, err := cfg.Client()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := tls.Dial("tcp", addr, clientTLS)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// the server verifies the client certificate after the client has finished its side of the handshake
+	_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			return nil, err
+		}
+	}
+	return conn.ConnectionState().PeerCertificates[0], nil
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := tlsconfigtest.NewCA(t)
+	addr := serve(t, ca.Issue("server"))
+
+	if _, err := dial(addr, ca.Issue("client")); err != nil {
+		t.Errorf("client with a certificate rejected %v", err)
+	}
+	if _, err := dial(addr, tlsconfig.Config{CAFile: ca.Issue("anonymous").CAFile}); err == nil {
+		t.Errorf("client without a certificate accepted")
+	}
+	if _, err := dial(addr, tlsconfigtest.NewCA(t).Issue("client")); err == nil {
+		t.Errorf("client trusting another CA accepted the server")
+	}
+}
+
+func TestCertificateReload(t *testing.T) {
+	ca := tlsconfigtest.NewCA(t)
+	cfg := ca.Issue("server")
+	addr := serve(t, cfg)
+	client := ca.Issue("client")
+
+	before, err := dial(addr, client)
+	if err != nil {
+		t.Fatalf("error connecting %v", err)
+	}
+	ca.Issue("server")
+	later := time.Now().Add(time.Minute)
+	for _, file := range []string{cfg.CertFile, cfg.KeyFile} {
+		if err := os.Chtimes(file, later, later); err != nil {
+			t.Fatalf("error touching %s %v", file, err)
+		}
+	}
+	after, err := dial(addr, client)
+	if err != nil {
+		t.Fatalf("error connecting after the renewal %v", err)
+	}
+	if before.SerialNumber.Cmp(after.SerialNumber) == 0 {
+		t.Errorf("renewed certificate not served")
+	}
+}
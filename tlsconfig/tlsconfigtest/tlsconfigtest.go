@@ -0,0 +1,106 @@
+// Package tlsconfigtest generates certificates for the tests using TLS
+package tlsconfigtest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/Vignesh-Rajarajan/event-bus/tlsconfig"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// CA signs the certificates of a test
+type CA struct {
+	t    testing.TB
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+// NewCA creates a CA whose files are stored in a temporary directory of the test
+func NewCA(t testing.TB) *CA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating CA key %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "event-bus test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating CA certificate %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("error parsing CA certificate %v", err)
+	}
+	ca := &CA{t: t, dir: t.TempDir(), cert: cert, key: key}
+	ca.file = filepath.Join(ca.dir, "ca.pem")
+	ca.write(ca.file, "CERTIFICATE", der)
+	return ca
+}
+
+// Issue creates a certificate for the name valid for localhost, usable by servers and clients,
+// the returned configuration trusts the CA for both servers and clients
+func (ca *CA) Issue(name string) tlsconfig.Config {
+	ca.t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		ca.t.Fatalf("error generating key %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		ca.t.Fatalf("error generating serial %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		ca.t.Fatalf("error creating certificate %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		ca.t.Fatalf("error encoding key %v", err)
+	}
+	cfg := tlsconfig.Config{
+		CertFile:     filepath.Join(ca.dir, name+".pem"),
+		KeyFile:      filepath.Join(ca.dir, name+"-key.pem"),
+		CAFile:       ca.file,
+		ClientCAFile: ca.file,
+	}
+	ca.write(cfg.CertFile, "CERTIFICATE", der)
+	ca.write(cfg.KeyFile, "EC PRIVATE KEY", keyDER)
+	return cfg
+}
+
+func (ca *CA) write(file, blockType string, der []byte) {
+	ca.t.Helper()
+	contents := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(file, contents, 0600); err != nil {
+		ca.t.Fatalf("error writing %s %v", file, err)
+	}
+}
@@ -0,0 +1,104 @@
+// Package tracing sets up OpenTelemetry tracing and carries the trace context of the producers
+// of messages over to their consumers
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+)
+
+const tracerName = "github.com/Vignesh-Rajarajan/event-bus"
+
+// ProducersHeader lists the trace context of the writes which produced the messages of a read response,
+// consumers link their spans to the producing traces. Only the owner of a chunk knows them, the reads
+// served by a replica carry none.
+const ProducersHeader = "X-Event-Bus-Producers"
+
+// MaxProducersSize bounds the size of the ProducersHeader so that it fits in the default header
+// buffers of the HTTP clients, only the most recent producers are kept beyond it
+const MaxProducersSize = 2048
+
+// Propagator carries the trace context in the W3C traceparent header, it is used regardless of the
+// global propagator so that traces connect even when only one side exports them
+var Propagator propagation.TextMapPropagator = propagation.TraceContext{}
+
+// Tracer returns the tracer of the event bus from the global tracer provider
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Setup exports the spans to the OTLP/HTTP endpoint, e.g. http://localhost:4318, the returned
+// function flushes the remaining spans and stops the exporter
+func Setup(ctx context.Context, endpoint, instance string) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("error creating OTLP exporter %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "event-bus"),
+			attribute.String("service.instance.id", instance),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(Propagator)
+	return provider.Shutdown, nil
+}
+
+// FormatProducers encodes the span contexts as a comma separated list of traceparent values, keeping
+// the most recent ones which fit in MaxProducersSize
+func FormatProducers(producers []trace.SpanContext) string {
+	var values []string
+	size := 0
+	for i := len(producers) - 1; i >= 0; i-- {
+		carrier := propagation.MapCarrier{}
+		Propagator.Inject(trace.ContextWithSpanContext(context.Background(), producers[i]), carrier)
+		v := carrier.Get("traceparent")
+		if v == "" {
+			continue
+		}
+		if size+len(v)+1 > MaxProducersSize {
+			break
+		}
+		size += len(v) + 1
+		values = append(values, v)
+	}
+	for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
+		values[i], values[j] = values[j], values[i]
+	}
+	return strings.Join(values, ",")
+}
+
+// ParseProducers decodes the value of the ProducersHeader as links to the producing spans
+func ParseProducers(header string) []trace.Link {
+	var links []trace.Link
+	for _, v := range strings.Split(header, ",") {
+		if v == "" {
+			continue
+		}
+		ctx := Propagator.Extract(context.Background(), propagation.MapCarrier{"traceparent": strings.TrimSpace(v)})
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+	return links
+}
+
+// End records the error, if any, on the span and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
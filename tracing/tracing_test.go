@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSetupExportsSpans(t *testing.T) {
+	var exported atomic.Int32
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/traces" {
+			exported.Add(1)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	shutdown, err := Setup(context.Background(), collector.URL, "luffy")
+	if err != nil {
+		t.Fatalf("error setting up tracing %v", err)
+	}
+	_, span := Tracer().Start(context.Background(), "test")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("error shutting down tracing %v", err)
+	}
+	if exported.Load() == 0 {
+		t.Errorf("no spans exported to the collector")
+	}
+}
+
+func TestFormatParseProducers(t *testing.T) {
+	var producers []trace.SpanContext
+	for i := byte(1); i <= 2; i++ {
+		producers = append(producers, trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    trace.TraceID{i},
+			SpanID:     trace.SpanID{i},
+			TraceFlags: trace.FlagsSampled,
+		}))
+	}
+	links := ParseProducers(FormatProducers(producers))
+	if len(links) != len(producers) {
+		t.Fatalf("got %d links want %d", len(links), len(producers))
+	}
+	for i, l := range links {
+		if !l.SpanContext.Equal(producers[i].WithRemote(true)) {
+			t.Errorf("link %d: got %v want %v", i, l.SpanContext, producers[i])
+		}
+	}
+	if links := ParseProducers(""); len(links) != 0 {
+		t.Errorf("got %d links for an empty header", len(links))
+	}
+}
+
+func TestFormatProducersKeepsMostRecent(t *testing.T) {
+	var producers []trace.SpanContext
+	for i := 1; i <= 1024; i++ {
+		producers = append(producers, trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    trace.TraceID{byte(i), byte(i >> 8)},
+			SpanID:     trace.SpanID{byte(i), byte(i >> 8)},
+			TraceFlags: trace.FlagsSampled,
+		}))
+	}
+	header := FormatProducers(producers)
+	if len(header) > MaxProducersSize {
+		t.Fatalf("got a header of %d bytes, want at most %d", len(header), MaxProducersSize)
+	}
+	links := ParseProducers(header)
+	if len(links) == 0 {
+		t.Fatalf("no producer kept")
+	}
+	if last := links[len(links)-1].SpanContext; !last.Equal(producers[len(producers)-1].WithRemote(true)) {
+		t.Errorf("got %v last, want the most recent producer %v", last, producers[len(producers)-1])
+	}
+}
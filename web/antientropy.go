@@ -0,0 +1,281 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Vignesh-Rajarajan/event-bus/chunk"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"github.com/valyala/fasthttp"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ReplicationReport is the outcome of the last comparison of the chunks stored across the cluster
+type ReplicationReport struct {
+	CheckedAt       time.Time    `json:"checkedAt"`
+	UnderReplicated []ChunkIssue `json:"underReplicated"`
+	Divergent       []ChunkIssue `json:"divergent"`
+}
+
+// ChunkIssue describes a chunk which does not have the expected copies
+type ChunkIssue struct {
+	Category string `json:"category"`
+	Chunk    string `json:"chunk"`
+	Owner    string `json:"owner"`
+	Copies   int    `json:"copies,omitempty"`
+	Expected int    `json:"expected,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type checksumResponse struct {
+	Size     uint64 `json:"size"`
+	Checksum uint32 `json:"checksum"`
+}
+
+// RunAntiEntropy periodically compares the chunks across peers until the context is cancelled
+func (s *Server) RunAntiEntropy(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		report, err := s.checkReplication(ctx)
+		if err != nil {
+			s.logger.Error("error checking replication", "error", err)
+			continue
+		}
+		s.reportMu.Lock()
+		s.report = report
+		s.reportMu.Unlock()
+	}
+}
+
+// checkReplication finds chunks which have fewer copies than the replication factor or whose copies differ,
+// copies missing from chunks owned by the current instance are queued for replication again
+func (s *Server) checkReplication(ctx context.Context) (ReplicationReport, error) {
+	report := ReplicationReport{CheckedAt: time.Now()}
+	categories, err := s.localCategories()
+	if err != nil {
+		return report, err
+	}
+	for _, category := range categories {
+		cfg, _, err := s.replicationClient.GetCategory(ctx, category)
+		if err != nil {
+			return report, err
+		}
+		local, err := s.localChunks(ctx, category)
+		if err != nil {
+			return report, err
+		}
+		listings, addrs, err := s.listPeerChunks(ctx, category, local)
+		if err != nil {
+			return report, err
+		}
+		queued, err := s.replicationClient.ListQueuedCopies(ctx, category)
+		if err != nil {
+			return report, err
+		}
+		for _, ch := range mergeChunkListings(listings, addrs) {
+			if issue, ok := s.checkCopies(ctx, category, cfg.ReplicationFactor, ch, queued[ch.Name], listings, addrs); ok {
+				report.UnderReplicated = append(report.UnderReplicated, issue)
+			}
+			if ch.Owner != s.instanceName {
+				continue
+			}
+			report.Divergent = append(report.Divergent, s.checkReplicaContents(ctx, category, ch, addrs)...)
+		}
+	}
+	underReplicatedChunks.Set(float64(len(report.UnderReplicated)))
+	divergentChunks.Set(float64(len(report.Divergent)))
+	return report, nil
+}
+
+// checkCopies reports the chunk when it has fewer copies than expected, the owner queues the missing copies
+// on instances which neither hold the chunk nor already have it queued
+func (s *Server) checkCopies(ctx context.Context, category string, factor int, ch chunk.Chunk, queued []string, listings map[string][]chunk.Chunk, addrs map[string]string) (ChunkIssue, bool) {
+	holders := map[string]bool{}
+	for _, r := range ch.Replicas {
+		holders[r.Instance] = true
+	}
+	if hasChunk(listings[ch.Owner], ch.Name) {
+		holders[ch.Owner] = true
+	}
+	expected := s.replicationStorage.Copies(factor, len(addrs))
+	if len(holders) >= expected {
+		return ChunkIssue{}, false
+	}
+
+	// the copies which are queued but not started yet will be made by their replicator
+	pending := map[string]bool{}
+	for _, instance := range queued {
+		if _, registered := addrs[instance]; registered && !holders[instance] {
+			pending[instance] = true
+		}
+	}
+	if missing := expected - len(holders) - len(pending); ch.Owner == s.instanceName && missing > 0 {
+		// dead peers cannot receive a copy right now, the next check will try them again
+		exclude := make(map[string]bool, len(holders)+len(pending))
+		for instance := range addrs {
+			if _, live := listings[instance]; holders[instance] || pending[instance] || !live {
+				exclude[instance] = true
+			}
+		}
+		targets, err := s.replicationStorage.Replicate(ctx, category, ch.Name, replication.Peer{Name: s.instanceName}, exclude, missing)
+		if err != nil {
+			s.logger.Error("error queueing chunk for replication", "category", category, "chunk", ch.Name, "error", err)
+		}
+		requeuedChunks.Add(float64(len(targets)))
+	}
+	return ChunkIssue{
+		Category: category,
+		Chunk:    ch.Name,
+		Owner:    ch.Owner,
+		Copies:   len(holders),
+		Expected: expected,
+	}, true
+}
+
+// checkReplicaContents compares the checksum of every replica with the local copy over the replicated size
+func (s *Server) checkReplicaContents(ctx context.Context, category string, ch chunk.Chunk, addrs map[string]string) []ChunkIssue {
+	storage, err := s.getStorage(category)
+	if err != nil {
+		s.logger.Error("error getting storage", "category", category, "error", err)
+		return nil
+	}
+	var issues []ChunkIssue
+	for _, r := range ch.Replicas {
+		issue := ChunkIssue{Category: category, Chunk: ch.Name, Owner: ch.Owner, Instance: r.Instance}
+		if r.Size > ch.Size {
+			issue.Reason = fmt.Sprintf("replica has %d bytes while the owner has %d", r.Size, ch.Size)
+			issues = append(issues, issue)
+			continue
+		}
+		if r.Complete && r.Size != ch.Size {
+			issue.Reason = fmt.Sprintf("complete replica has %d bytes while the owner has %d", r.Size, ch.Size)
+			issues = append(issues, issue)
+			continue
+		}
+		want, err := storage.Checksum(ch.Name, r.Size)
+		if err != nil {
+			s.logger.Error("error computing checksum", "category", category, "chunk", ch.Name, "error", err)
+			continue
+		}
+		got, err := s.peerChecksum(ctx, addrs[r.Instance], category, ch.Name, r.Size)
+		if err != nil {
+			s.logger.Warn("error getting checksum from replica", "category", category, "chunk", ch.Name, "replica", r.Instance, "error", err)
+			continue
+		}
+		if got != want {
+			issue.Reason = fmt.Sprintf("checksum of the first %d bytes is %08x while the owner has %08x", r.Size, got, want)
+			issues = append(issues, issue)
+		}
+	}
+	return issues
+}
+
+func (s *Server) peerChecksum(ctx context.Context, addr, category, fileName string, size uint64) (uint32, error) {
+	args := fasthttp.AcquireArgs()
+	defer fasthttp.ReleaseArgs(args)
+	args.Add("category", category)
+	args.Add("chunk", fileName)
+	args.Add("size", strconv.FormatUint(size, 10))
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	if err := s.peerRequest(addr, "/checksum", args, resp); err != nil {
+		return 0, err
+	}
+	var res checksumResponse
+	if err := json.Unmarshal(resp.Body(), &res); err != nil {
+		return 0, err
+	}
+	return res.Checksum, nil
+}
+
+func (s *Server) checksumHandler(ctx *fasthttp.RequestCtx) {
+	category := string(ctx.QueryArgs().Peek("category"))
+	if !s.hasLocalCategory(category) {
+		ctx.Error(fmt.Sprintf("category %s not found", category), fasthttp.StatusNotFound)
+		return
+	}
+	chunk := string(ctx.QueryArgs().Peek("chunk"))
+	if !isValidChunk(chunk) {
+		ctx.Error(fmt.Sprintf("invalid chunk %s", chunk), fasthttp.StatusBadRequest)
+		return
+	}
+	size, err := ctx.QueryArgs().GetUint("size")
+	if err != nil {
+		ctx.Error(fmt.Sprintf("bad `size` getParam: %v", err.Error()), fasthttp.StatusBadRequest)
+		return
+	}
+	storage, err := s.getStorage(category)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	sum, err := storage.Checksum(chunk, uint64(size))
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(ctx).Encode(checksumResponse{Size: uint64(size), Checksum: sum}); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+	}
+}
+
+func (s *Server) replicationStatusHandler(ctx *fasthttp.RequestCtx) {
+	s.reportMu.Lock()
+	report := s.report
+	s.reportMu.Unlock()
+	if err := json.NewEncoder(ctx).Encode(report); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+	}
+}
+
+// localCategories lists the categories which have a directory on the current instance
+func (s *Server) localCategories() ([]string, error) {
+	entries, err := os.ReadDir(s.dirname)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory %s %v", s.dirname, err)
+	}
+	var categories []string
+	for _, e := range entries {
+		if e.IsDir() && isValidCategory(e.Name()) {
+			categories = append(categories, e.Name())
+		}
+	}
+	return categories, nil
+}
+
+// localChunks lists the chunks of the category stored on the current instance without creating the category
+func (s *Server) localChunks(ctx context.Context, category string) ([]chunk.Chunk, error) {
+	if !s.hasLocalCategory(category) {
+		return nil, nil
+	}
+	storage, err := s.getStorage(category)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := storage.ListChunks()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.addReplicaInfo(ctx, category, chunks); err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
+
+func hasChunk(chunks []chunk.Chunk, name string) bool {
+	for _, ch := range chunks {
+		if ch.Name == name {
+			return true
+		}
+	}
+	return false
+}
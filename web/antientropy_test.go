@@ -0,0 +1,111 @@
+package web
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/Vignesh-Rajarajan/event-bus/chunk"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"github.com/valyala/fasthttp"
+)
+
+func TestCheckCopies(t *testing.T) {
+	client := replication.NewClientWithBackend(replication.NewMemoryBackend(), "test")
+	s := &Server{instanceName: "luffy", replicationStorage: replication.NewStorage(client, "luffy")}
+	addrs := map[string]string{"luffy": "luffy:8080", "zoro": "zoro:8080", "nami": "nami:8080"}
+	listings := map[string][]chunk.Chunk{
+		"luffy": {{Name: "zoro-chunk000000001", Size: 4}},
+		"zoro":  {{Name: "zoro-chunk000000001", Size: 4}},
+	}
+	merged := mergeChunkListings(listings, addrs)
+
+	issue, ok := s.checkCopies(context.Background(), "numbers", 0, merged[0], nil, listings, addrs)
+	if !ok {
+		t.Fatalf("chunk with 2 out of 3 copies is not reported as under replicated")
+	}
+	want := ChunkIssue{Category: "numbers", Chunk: "zoro-chunk000000001", Owner: "zoro", Copies: 2, Expected: 3}
+	if !reflect.DeepEqual(issue, want) {
+		t.Errorf("got %+v want %+v", issue, want)
+	}
+
+	listings["nami"] = []chunk.Chunk{{Name: "zoro-chunk000000001", Size: 4}}
+	merged = mergeChunkListings(listings, addrs)
+	if issue, ok := s.checkCopies(context.Background(), "numbers", 0, merged[0], nil, listings, addrs); ok {
+		t.Errorf("fully replicated chunk reported as under replicated %+v", issue)
+	}
+}
+
+func TestCheckCopiesWithReplicationFactor(t *testing.T) {
+	client := replication.NewClientWithBackend(replication.NewMemoryBackend(), "test")
+	s := &Server{instanceName: "luffy", replicationStorage: replication.NewStorage(client, "luffy", replication.WithReplicationFactor(2))}
+	addrs := map[string]string{"luffy": "luffy:8080", "zoro": "zoro:8080", "nami": "nami:8080"}
+	listings := map[string][]chunk.Chunk{
+		"luffy": {{Name: "zoro-chunk000000001", Size: 4}},
+		"zoro":  {{Name: "zoro-chunk000000001", Size: 4}},
+		"nami":  {},
+	}
+	merged := mergeChunkListings(listings, addrs)
+	if issue, ok := s.checkCopies(context.Background(), "numbers", 0, merged[0], nil, listings, addrs); ok {
+		t.Errorf("chunk with as many copies as the replication factor reported as under replicated %+v", issue)
+	}
+
+	// the replication factor of the category overrides the default one
+	if _, ok := s.checkCopies(context.Background(), "numbers", 3, merged[0], nil, listings, addrs); !ok {
+		t.Errorf("chunk with fewer copies than the replication factor of its category is not reported")
+	}
+}
+
+func TestCheckCopiesCountsQueuedCopies(t *testing.T) {
+	ctx := context.Background()
+	client := replication.NewClientWithBackend(replication.NewMemoryBackend(), "test")
+	s := &Server{instanceName: "luffy", replicationClient: client, replicationStorage: replication.NewStorage(client, "luffy")}
+	addrs := map[string]string{"luffy": "luffy:8080", "zoro": "zoro:8080", "nami": "nami:8080"}
+	listings := map[string][]chunk.Chunk{
+		"luffy": {{Name: "luffy-chunk000000001", Size: 4}},
+		"zoro":  {},
+		"nami":  {},
+	}
+	for name, addr := range addrs {
+		if err := client.RegisterPeer(ctx, replication.Peer{Name: name, Addr: addr}); err != nil {
+			t.Fatalf("RegisterPeer() = %v", err)
+		}
+	}
+	merged := mergeChunkListings(listings, addrs)
+	if err := client.AddChunkToReplicationQueue(ctx, "zoro", replication.Chunk{Category: "numbers", FileName: "luffy-chunk000000001", OwnedBy: "luffy"}); err != nil {
+		t.Fatalf("AddChunkToReplicationQueue() = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		queued, err := client.ListQueuedCopies(ctx, "numbers")
+		if err != nil {
+			t.Fatalf("ListQueuedCopies() = %v", err)
+		}
+		if _, ok := s.checkCopies(ctx, "numbers", 2, merged[0], queued["luffy-chunk000000001"], listings, addrs); !ok {
+			t.Errorf("chunk with a single copy is not reported as under replicated")
+		}
+	}
+	queued, err := client.ListQueuedCopies(ctx, "numbers")
+	if err != nil {
+		t.Fatalf("ListQueuedCopies() = %v", err)
+	}
+	if got, want := queued["luffy-chunk000000001"], []string{"zoro"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("chunk queued on %v, want %v", got, want)
+	}
+}
+
+func TestChecksumRejectsChunkOutsideCategory(t *testing.T) {
+	client := replication.NewClientWithBackend(replication.NewMemoryBackend(), "test")
+	s := NewServer(client, "luffy", t.TempDir(), "", replication.NewStorage(client, "luffy"))
+	if _, err := s.getStorage("numbers"); err != nil {
+		t.Fatalf("error creating category %v", err)
+	}
+	for _, chunk := range []string{"", "..", "../numbers", ".luffy-chunk000000000.manifest"} {
+		var ctx fasthttp.RequestCtx
+		ctx.Request.SetRequestURI("/checksum?category=numbers&size=1&chunk=" + chunk)
+		s.handleRequest(&ctx)
+		if code := ctx.Response.StatusCode(); code != fasthttp.StatusBadRequest {
+			t.Errorf("chunk %q: got status %d want %d", chunk, code, fasthttp.StatusBadRequest)
+		}
+	}
+}
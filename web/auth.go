@@ -0,0 +1,328 @@
+package web
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"github.com/valyala/fasthttp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// authCacheTTL is how long the tokens and grants read from the cluster state are trusted,
+	// revocations take up to that long to apply
+	authCacheTTL = 5 * time.Second
+	// clusterPrincipal is the principal of the requests authenticated with the cluster token
+	clusterPrincipal = "cluster"
+)
+
+// pathPermissions is the permission required by every endpoint on the category of the request,
+// endpoints which are not listed require the admin permission on every category
+var pathPermissions = map[string]replication.Permission{
+	"/write":         replication.Produce,
+	"/read":          replication.Consume,
+	"/ack":           replication.Consume,
+	"/listChunks":    replication.Consume,
+	"/checksum":      replication.Consume,
+	"/commit":        replication.Consume,
+	"/lag":           replication.Consume,
+	"/countMessages": replication.Consume,
+}
+
+// publicPaths are served without authentication so that the orchestrator can probe the instance
+var publicPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// WithAuth requires every request to be authenticated, either with a bearer token or with a client
+// certificate whose common name is the principal, and allowed by the grants of the principal.
+// The cluster token grants everything, the instances use it to talk to each other and it lets
+// operators create the first tokens and grants.
+func WithAuth(clusterToken string) Option {
+	return func(s *Server) {
+		s.auth = &authenticator{clusterToken: clusterToken, client: s.replicationClient}
+	}
+}
+
+// authenticator resolves the principal of the requests and checks their permissions
+// against the grants stored in the cluster state
+type authenticator struct {
+	clusterToken string
+	client       replication.Coordinator
+
+	mu     sync.Mutex
+	tokens map[string]cachedPrincipal
+	grants map[string]cachedGrants
+}
+
+type principalKey struct{}
+
+// requestPrincipal returns the principal the request was authenticated as, it is empty when
+// authentication is disabled
+func requestPrincipal(ctx *fasthttp.RequestCtx) string {
+	principal, _ := ctx.UserValue(principalKey{}).(string)
+	return principal
+}
+
+type cachedPrincipal struct {
+	principal string
+	expires   time.Time
+}
+
+type cachedGrants struct {
+	grants  []replication.Grant
+	expires time.Time
+}
+
+// authorize checks the request and responds with 401 or 403 when it is not allowed
+func (s *Server) authorize(ctx *fasthttp.RequestCtx) bool {
+	if s.auth == nil || publicPaths[string(ctx.Path())] {
+		return true
+	}
+	principal, err := s.auth.principal(ctx)
+	if err != nil {
+		ctx.Response.Header.Set("WWW-Authenticate", "Bearer")
+		ctx.Error(err.Error(), fasthttp.StatusUnauthorized)
+		return false
+	}
+	ctx.SetUserValue(principalKey{}, principal)
+	if string(ctx.Path()) == "/categories" {
+		// the listing only shows the categories the principal may consume
+		return true
+	}
+	permission, ok := pathPermissions[string(ctx.Path())]
+	category := string(ctx.QueryArgs().Peek("category"))
+	if !ok {
+		permission, category = replication.Admin, ""
+	}
+	allowed, err := s.auth.allowed(ctx, principal, category, permission)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusServiceUnavailable)
+		return false
+	}
+	if !allowed {
+		ctx.Error(fmt.Sprintf("%s is not allowed to %s category %q", principal, permission, category), fasthttp.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// canConsume tells whether the principal of the request may consume the category
+func (s *Server) canConsume(ctx *fasthttp.RequestCtx, category string) (bool, error) {
+	if s.auth == nil {
+		return true, nil
+	}
+	return s.auth.allowed(ctx, requestPrincipal(ctx), category, replication.Consume)
+}
+
+// setPeerAuth authenticates a request sent to a peer on behalf of the current instance
+func (s *Server) setPeerAuth(h *fasthttp.RequestHeader) {
+	if s.auth != nil && s.auth.clusterToken != "" {
+		h.Set(fasthttp.HeaderAuthorization, "Bearer "+s.auth.clusterToken)
+	}
+}
+
+func (a *authenticator) principal(ctx *fasthttp.RequestCtx) (string, error) {
+	if token, ok := strings.CutPrefix(string(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization)), "Bearer "); ok {
+		if a.clusterToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.clusterToken)) == 1 {
+			return clusterPrincipal, nil
+		}
+		principal, found, err := a.lookupToken(ctx, replication.HashToken(token))
+		if err != nil {
+			return "", err
+		}
+		if !found {
+			return "", fmt.Errorf("invalid token")
+		}
+		return principal, nil
+	}
+	return certificatePrincipal(ctx.TLSConnectionState())
+}
+
+// certificatePrincipal returns the common name of the verified client certificate, the name of
+// the principal of the cluster token is reserved as it is granted everything
+func certificatePrincipal(state *tls.ConnectionState) (string, error) {
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return "", fmt.Errorf("authentication required")
+	}
+	cn := state.VerifiedChains[0][0].Subject.CommonName
+	switch cn {
+	case "":
+		return "", fmt.Errorf("authentication required")
+	case clusterPrincipal:
+		return "", fmt.Errorf("certificate common name %q is reserved", cn)
+	}
+	return cn, nil
+}
+
+// lookupToken returns the principal of the token, only the tokens found are cached so that
+// invalid tokens cannot fill the cache
+func (a *authenticator) lookupToken(ctx context.Context, hash string) (string, bool, error) {
+	a.mu.Lock()
+	cached, ok := a.tokens[hash]
+	a.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.principal, true, nil
+	}
+	principal, found, err := a.client.LookupToken(ctx, hash)
+	if err != nil {
+		return "", false, err
+	}
+	a.mu.Lock()
+	if found {
+		if a.tokens == nil {
+			a.tokens = make(map[string]cachedPrincipal)
+		}
+		a.tokens[hash] = cachedPrincipal{principal: principal, expires: time.Now().Add(authCacheTTL)}
+	} else {
+		// the token was revoked
+		delete(a.tokens, hash)
+	}
+	a.mu.Unlock()
+	return principal, found, nil
+}
+
+func (a *authenticator) allowed(ctx context.Context, principal, category string, permission replication.Permission) (bool, error) {
+	if principal == clusterPrincipal {
+		return true, nil
+	}
+	a.mu.Lock()
+	cached, ok := a.grants[principal]
+	a.mu.Unlock()
+	if !ok || time.Now().After(cached.expires) {
+		grants, err := a.client.GetGrants(ctx, principal)
+		if err != nil {
+			return false, err
+		}
+		cached = cachedGrants{grants: grants, expires: time.Now().Add(authCacheTTL)}
+		a.mu.Lock()
+		if a.grants == nil {
+			a.grants = make(map[string]cachedGrants)
+		}
+		a.grants[principal] = cached
+		a.mu.Unlock()
+	}
+	for _, g := range cached.grants {
+		if g.Allows(category, permission) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type tokenResponse struct {
+	Principal string `json:"principal"`
+	Hash      string `json:"hash"`
+	Token     string `json:"token,omitempty"`
+}
+
+// tokensHandler lists the tokens, creates a token for a principal or revokes a token by its hash,
+// the token itself is only returned when it is created
+func (s *Server) tokensHandler(ctx *fasthttp.RequestCtx) {
+	switch string(ctx.Method()) {
+	case fasthttp.MethodGet:
+		tokens, err := s.replicationClient.ListTokens(ctx)
+		if err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+			return
+		}
+		resp := make([]tokenResponse, 0, len(tokens))
+		for hash, principal := range tokens {
+			resp = append(resp, tokenResponse{Principal: principal, Hash: hash})
+		}
+		if err := json.NewEncoder(ctx).Encode(resp); err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		}
+	case fasthttp.MethodPost:
+		principal := string(ctx.QueryArgs().Peek("principal"))
+		if principal == "" || principal == clusterPrincipal {
+			ctx.Error(fmt.Sprintf("invalid principal %q", principal), fasthttp.StatusBadRequest)
+			return
+		}
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+			return
+		}
+		token := hex.EncodeToString(b)
+		hash := replication.HashToken(token)
+		if err := s.replicationClient.SetToken(ctx, hash, principal); err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+			return
+		}
+		if err := json.NewEncoder(ctx).Encode(tokenResponse{Principal: principal, Hash: hash, Token: token}); err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		}
+	case fasthttp.MethodDelete:
+		hash := string(ctx.QueryArgs().Peek("hash"))
+		if hash == "" {
+			ctx.Error("hash cannot be empty", fasthttp.StatusBadRequest)
+			return
+		}
+		if err := s.replicationClient.DeleteToken(ctx, hash); err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		}
+	default:
+		ctx.Error("method not allowed", fasthttp.StatusMethodNotAllowed)
+	}
+}
+
+// aclsHandler lists the grants of every principal, replaces the grants of a principal or removes them
+func (s *Server) aclsHandler(ctx *fasthttp.RequestCtx) {
+	principal := string(ctx.QueryArgs().Peek("principal"))
+	switch string(ctx.Method()) {
+	case fasthttp.MethodGet:
+		acls, err := s.replicationClient.ListGrants(ctx)
+		if err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+			return
+		}
+		if err := json.NewEncoder(ctx).Encode(acls); err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		}
+	case fasthttp.MethodPut:
+		if principal == "" {
+			ctx.Error("principal cannot be empty", fasthttp.StatusBadRequest)
+			return
+		}
+		var grants []replication.Grant
+		if err := json.Unmarshal(ctx.PostBody(), &grants); err != nil {
+			ctx.Error(fmt.Sprintf("invalid grants %v", err), fasthttp.StatusBadRequest)
+			return
+		}
+		for _, g := range grants {
+			for _, p := range g.Permissions {
+				if p != replication.Produce && p != replication.Consume && p != replication.Admin {
+					ctx.Error(fmt.Sprintf("unknown permission %q", p), fasthttp.StatusBadRequest)
+					return
+				}
+				if p == replication.Admin && g.Category != "*" && g.Category != "" {
+					// the operations on the instance are not tied to a category, they would never be allowed
+					ctx.Error(fmt.Sprintf("admin can only be granted on every category, not on %q", g.Category), fasthttp.StatusBadRequest)
+					return
+				}
+			}
+		}
+		if err := s.replicationClient.SetGrants(ctx, principal, grants); err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		}
+	case fasthttp.MethodDelete:
+		if principal == "" {
+			ctx.Error("principal cannot be empty", fasthttp.StatusBadRequest)
+			return
+		}
+		if err := s.replicationClient.DeleteGrants(ctx, principal); err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		}
+	default:
+		ctx.Error("method not allowed", fasthttp.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,79 @@
+package web
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"github.com/valyala/fasthttp"
+	"testing"
+)
+
+func TestAuthorization(t *testing.T) {
+	client := replication.NewClientWithBackend(replication.NewMemoryBackend(), "test")
+	s := NewServer(client, "luffy", t.TempDir(), "", replication.NewStorage(client, "luffy"), WithAuth("secret"))
+
+	do := func(method, uri, token, body string) *fasthttp.RequestCtx {
+		t.Helper()
+		var req fasthttp.RequestCtx
+		req.Request.Header.SetMethod(method)
+		req.Request.SetRequestURI(uri)
+		if token != "" {
+			req.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+token)
+		}
+		req.Request.SetBodyString(body)
+		s.handleRequest(&req)
+		return &req
+	}
+	expect := func(req *fasthttp.RequestCtx, want int) {
+		t.Helper()
+		if code := req.Response.StatusCode(); code != want {
+			t.Errorf("%s: got status %d want %d %s", req.Request.URI(), code, want, req.Response.Body())
+		}
+	}
+
+	expect(do(fasthttp.MethodPost, "/write?category=orders-eu", "", "1\n"), fasthttp.StatusUnauthorized)
+	expect(do(fasthttp.MethodPost, "/write?category=orders-eu", "wrong", "1\n"), fasthttp.StatusUnauthorized)
+	expect(do(fasthttp.MethodGet, "/healthz", "", ""), fasthttp.StatusOK)
+
+	req := do(fasthttp.MethodPost, "/admin/tokens?principal=alice", "secret", "")
+	expect(req, fasthttp.StatusOK)
+	var created tokenResponse
+	if err := json.Unmarshal(req.Response.Body(), &created); err != nil || created.Token == "" {
+		t.Fatalf("got token %+v error %v", created, err)
+	}
+	expect(do(fasthttp.MethodPut, "/admin/acls?principal=alice", "secret", `[{"category":"orders*","permissions":["produce"]}]`), fasthttp.StatusOK)
+	expect(do(fasthttp.MethodPut, "/admin/acls?principal=alice", "secret", `[{"category":"orders*","permissions":["delete"]}]`), fasthttp.StatusBadRequest)
+	// admin is only checked on the operations of the instance, a category cannot scope it
+	expect(do(fasthttp.MethodPut, "/admin/acls?principal=bob", "secret", `[{"category":"orders*","permissions":["admin"]}]`), fasthttp.StatusBadRequest)
+
+	expect(do(fasthttp.MethodPost, "/write?category=orders-eu", created.Token, "1\n"), fasthttp.StatusOK)
+	expect(do(fasthttp.MethodPost, "/write?category=payments", created.Token, "1\n"), fasthttp.StatusForbidden)
+	expect(do(fasthttp.MethodGet, "/listChunks?category=orders-eu", created.Token, ""), fasthttp.StatusForbidden)
+	expect(do(fasthttp.MethodGet, "/admin/tokens", created.Token, ""), fasthttp.StatusForbidden)
+
+	// the invalid tokens are not cached
+	if len(s.auth.tokens) != 1 {
+		t.Errorf("got %d cached tokens want only the valid one", len(s.auth.tokens))
+	}
+}
+
+func TestCertificatePrincipal(t *testing.T) {
+	state := func(cn string) *tls.ConnectionState {
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: cn}}}}}
+	}
+	if principal, err := certificatePrincipal(state("alice")); err != nil || principal != "alice" {
+		t.Errorf("got principal %q error %v want alice", principal, err)
+	}
+	for name, s := range map[string]*tls.ConnectionState{
+		"no certificate":     nil,
+		"empty common name":  state(""),
+		"reserved principal": state(clusterPrincipal),
+		"unverified chain":   {},
+	} {
+		if principal, err := certificatePrincipal(s); err == nil {
+			t.Errorf("%s: got principal %q", name, principal)
+		}
+	}
+}
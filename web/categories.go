@@ -0,0 +1,314 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Vignesh-Rajarajan/event-bus/manager"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"github.com/valyala/fasthttp"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+var errUnknownCategory = errors.New("unknown category")
+
+// WithAutoCreate tells whether writing into a category which does not exist creates it, when disabled
+// the categories must be created through /admin/categories first
+func WithAutoCreate(enabled bool) Option {
+	return func(s *Server) {
+		s.autoCreate = enabled
+	}
+}
+
+// CategoryInfo is a category listed by /admin/categories, the stats are the ones of the current instance
+type CategoryInfo struct {
+	Name string `json:"name"`
+	// Config is nil for the categories which were created implicitly
+	Config          *replication.CategoryConfig `json:"config,omitempty"`
+	Chunks          int                         `json:"chunks"`
+	Bytes           uint64                      `json:"bytes"`
+	ActiveChunkSize uint64                      `json:"activeChunkSize"`
+}
+
+func storageSettings(cfg replication.CategoryConfig) manager.Settings {
+	return manager.Settings{
+		MaxChunkSize:      cfg.MaxChunkSize,
+		MaxChunkAge:       time.Duration(cfg.MaxChunkAgeSeconds) * time.Second,
+		MaxChunkMessages:  cfg.MaxChunkMessages,
+		Fsync:             manager.FsyncPolicy(cfg.Fsync),
+		ReplicationFactor: cfg.ReplicationFactor,
+	}
+}
+
+func validateCategoryConfig(cfg replication.CategoryConfig) error {
+	switch manager.FsyncPolicy(cfg.Fsync) {
+	case "", manager.FsyncNever, manager.FsyncOnSeal, manager.FsyncAlways:
+	default:
+		return fmt.Errorf("unknown fsync policy %q", cfg.Fsync)
+	}
+	if cfg.RetentionSeconds < 0 || cfg.MaxChunkAgeSeconds < 0 || cfg.ReplicationFactor < 0 {
+		return fmt.Errorf("retention, chunk age and replication factor cannot be negative")
+	}
+	return nil
+}
+
+// categoriesHandler lists the categories with their settings and stats, creates a category,
+// updates its settings or deletes it with its data on every instance
+func (s *Server) categoriesHandler(ctx *fasthttp.RequestCtx) {
+	if string(ctx.Method()) == fasthttp.MethodGet {
+		s.listCategories(ctx)
+		return
+	}
+	category := string(ctx.QueryArgs().Peek("category"))
+	if !isValidCategory(category) {
+		ctx.Error(fmt.Sprintf("invalid category %s", category), fasthttp.StatusBadRequest)
+		return
+	}
+	switch string(ctx.Method()) {
+	case fasthttp.MethodPost, fasthttp.MethodPut:
+		s.configureCategory(ctx, category, string(ctx.Method()) == fasthttp.MethodPost)
+	case fasthttp.MethodDelete:
+		if err := s.deleteCategory(ctx, category); err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusBadGateway)
+		}
+	default:
+		ctx.Error("method not allowed", fasthttp.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) listCategories(ctx *fasthttp.RequestCtx) {
+	configs, err := s.replicationClient.ListCategories(ctx)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	local, err := s.localCategories()
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	infos := make(map[string]*CategoryInfo)
+	for name, cfg := range configs {
+		cfg := cfg
+		infos[name] = &CategoryInfo{Name: name, Config: &cfg}
+	}
+	for _, name := range local {
+		info, ok := infos[name]
+		if !ok {
+			info = &CategoryInfo{Name: name}
+			infos[name] = info
+		}
+		storage, err := s.getStorage(name)
+		if err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+			return
+		}
+		stats, err := storage.Stats()
+		if err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+			return
+		}
+		info.Chunks, info.Bytes, info.ActiveChunkSize = stats.Chunks, stats.Bytes, stats.ActiveChunkSize
+	}
+	resp := make([]CategoryInfo, 0, len(infos))
+	for _, info := range infos {
+		resp = append(resp, *info)
+	}
+	sort.Slice(resp, func(i, j int) bool { return resp[i].Name < resp[j].Name })
+	if err := json.NewEncoder(ctx).Encode(resp); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+	}
+}
+
+// categoryNamesHandler lists the names of the categories the client may consume, only the ones
+// matching the glob pattern parameter such as orders.* when it is set. The cluster scope lists the
+// categories stored on every live instance, otherwise the ones stored locally or created explicitly.
+func (s *Server) categoryNamesHandler(ctx *fasthttp.RequestCtx) {
+	pattern := string(ctx.QueryArgs().Peek("pattern"))
+	if _, err := path.Match(pattern, ""); err != nil {
+		ctx.Error(fmt.Sprintf("invalid pattern %s %v", pattern, err), fasthttp.StatusBadRequest)
+		return
+	}
+	configs, err := s.replicationClient.ListCategories(ctx)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	local, err := s.localCategories()
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	candidates := make(map[string]bool)
+	for name := range configs {
+		candidates[name] = true
+	}
+	for _, name := range local {
+		candidates[name] = true
+	}
+	if isClusterScope(ctx) {
+		peerNames, err := s.peerCategoryNames(ctx, pattern)
+		if err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+			return
+		}
+		for _, name := range peerNames {
+			candidates[name] = true
+		}
+	}
+
+	names := make([]string, 0, len(candidates))
+	for name := range candidates {
+		if matched, _ := path.Match(pattern, name); pattern != "" && !matched {
+			continue
+		}
+		allowed, err := s.canConsume(ctx, name)
+		if err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusServiceUnavailable)
+			return
+		}
+		if allowed {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if err := json.NewEncoder(ctx).Encode(names); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+	}
+}
+
+// peerCategoryNames returns the categories listed by every live peer
+func (s *Server) peerCategoryNames(ctx context.Context, pattern string) ([]string, error) {
+	addrs, err := s.peerAddrs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name, addr := range addrs {
+		if name == s.instanceName {
+			continue
+		}
+		args := fasthttp.AcquireArgs()
+		args.Add("pattern", pattern)
+		resp := fasthttp.AcquireResponse()
+		err := s.peerRequest(addr, "/categories", args, resp)
+		fasthttp.ReleaseArgs(args)
+		if err != nil {
+			s.logger.Warn("error listing categories on peer", "peer", name, "error", err)
+			fasthttp.ReleaseResponse(resp)
+			continue
+		}
+		var peerNames []string
+		err = json.Unmarshal(resp.Body(), &peerNames)
+		fasthttp.ReleaseResponse(resp)
+		if err != nil {
+			s.logger.Warn("error decoding categories from peer", "peer", name, "error", err)
+			continue
+		}
+		names = append(names, peerNames...)
+	}
+	return names, nil
+}
+
+// configureCategory creates the category or updates its settings, the other instances apply
+// the new settings at their next retention run
+func (s *Server) configureCategory(ctx *fasthttp.RequestCtx, category string, create bool) {
+	var cfg replication.CategoryConfig
+	if len(ctx.PostBody()) > 0 {
+		if err := json.Unmarshal(ctx.PostBody(), &cfg); err != nil {
+			ctx.Error(fmt.Sprintf("invalid settings %v", err), fasthttp.StatusBadRequest)
+			return
+		}
+	}
+	if err := validateCategoryConfig(cfg); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	current, found, err := s.replicationClient.GetCategory(ctx, category)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	exists := found || s.hasLocalCategory(category)
+	switch {
+	case create && exists:
+		ctx.Error(fmt.Sprintf("category %s already exists", category), fasthttp.StatusConflict)
+		return
+	case !create && !exists:
+		ctx.Error(fmt.Sprintf("%v %s", errUnknownCategory, category), fasthttp.StatusNotFound)
+		return
+	}
+	cfg.CreatedAt = current.CreatedAt
+	if !found {
+		cfg.CreatedAt = time.Now().UTC()
+	}
+	if err := s.replicationClient.SetCategory(ctx, category, cfg); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	storage, err := s.getStorage(category)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	storage.SetSettings(storageSettings(cfg))
+	if err := json.NewEncoder(ctx).Encode(CategoryInfo{Name: category, Config: &cfg}); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+	}
+}
+
+// deleteCategory removes the category from the cluster state and its data from every instance,
+// a request forwarded by a peer only removes the local data
+func (s *Server) deleteCategory(ctx *fasthttp.RequestCtx, category string) error {
+	var errs []error
+	if !isForwarded(ctx) {
+		if err := s.replicationClient.DeleteCategory(ctx, category); err != nil {
+			return fmt.Errorf("error deleting category %s %v", category, err)
+		}
+		addrs, err := s.peerAddrs(ctx)
+		if err != nil {
+			return err
+		}
+		for name, addr := range addrs {
+			if name == s.instanceName {
+				continue
+			}
+			args := fasthttp.AcquireArgs()
+			args.Add("category", category)
+			resp := fasthttp.AcquireResponse()
+			err := s.peerRequestWithMethod(fasthttp.MethodDelete, addr, "/admin/categories", args, resp)
+			fasthttp.ReleaseArgs(args)
+			fasthttp.ReleaseResponse(resp)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("error deleting category %s on %s %v", category, name, err))
+			}
+		}
+	}
+
+	s.m.Lock()
+	storage, ok := s.storages[category]
+	var err error
+	if !ok {
+		// no write is in flight without a storage and none opens it while the lock is held
+		err = os.RemoveAll(filepath.Join(s.dirname, category))
+	}
+	s.m.Unlock()
+	if ok {
+		// the storage stays registered until it is deleted so that the writes arriving meanwhile
+		// fail instead of creating the category again, the writes in flight are waited for
+		err = storage.Delete()
+		s.m.Lock()
+		delete(s.storages, category)
+		s.m.Unlock()
+	}
+	if err != nil {
+		errs = append(errs, fmt.Errorf("error removing category %s %v", category, err))
+	}
+	s.logger.Info("deleted category", "category", category)
+	return errors.Join(errs...)
+}
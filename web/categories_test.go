@@ -0,0 +1,117 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"github.com/valyala/fasthttp"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCategoryLifecycle(t *testing.T) {
+	dir := t.TempDir()
+	client := replication.NewClientWithBackend(replication.NewMemoryBackend(), "test")
+	s := NewServer(client, "luffy", dir, "", replication.NewStorage(client, "luffy"), WithAutoCreate(false))
+
+	do := func(method, uri, body string, want int) *fasthttp.RequestCtx {
+		t.Helper()
+		var req fasthttp.RequestCtx
+		req.Request.Header.SetMethod(method)
+		req.Request.SetRequestURI(uri)
+		req.Request.SetBodyString(body)
+		s.handleRequest(&req)
+		if code := req.Response.StatusCode(); code != want {
+			t.Fatalf("%s %s: got status %d want %d %s", method, uri, code, want, req.Response.Body())
+		}
+		return &req
+	}
+
+	do(fasthttp.MethodPost, "/write?category=numbers", "1\n", fasthttp.StatusNotFound)
+	do(fasthttp.MethodPost, "/admin/categories?category=numbers", `{"fsync":"sometimes"}`, fasthttp.StatusBadRequest)
+	do(fasthttp.MethodPost, "/admin/categories?category=numbers", `{"maxChunkSize":4,"retentionSeconds":3600,"fsync":"seal"}`, fasthttp.StatusOK)
+	do(fasthttp.MethodPost, "/admin/categories?category=numbers", "", fasthttp.StatusConflict)
+	do(fasthttp.MethodPut, "/admin/categories?category=letters", `{}`, fasthttp.StatusNotFound)
+	do(fasthttp.MethodPost, "/write?category=numbers", "1\n2\n", fasthttp.StatusOK)
+	do(fasthttp.MethodPost, "/write?category=numbers", "3\n", fasthttp.StatusOK)
+
+	var infos []CategoryInfo
+	if err := json.Unmarshal(do(fasthttp.MethodGet, "/admin/categories", "", fasthttp.StatusOK).Response.Body(), &infos); err != nil {
+		t.Fatalf("error decoding categories %v", err)
+	}
+	if len(infos) != 1 || infos[0].Name != "numbers" || infos[0].Config == nil || infos[0].Config.Fsync != "seal" ||
+		infos[0].Config.CreatedAt.IsZero() || infos[0].Chunks != 2 || infos[0].Bytes != 6 {
+		t.Errorf("got categories %+v", infos)
+	}
+
+	do(fasthttp.MethodPut, "/admin/categories?category=numbers", `{"replicationFactor":2}`, fasthttp.StatusOK)
+	cfg, _, err := client.GetCategory(context.Background(), "numbers")
+	if err != nil || cfg.ReplicationFactor != 2 || cfg.MaxChunkSize != 0 || cfg.CreatedAt != infos[0].Config.CreatedAt {
+		t.Errorf("got settings %+v error %v", cfg, err)
+	}
+
+	do(fasthttp.MethodDelete, "/admin/categories?category=numbers", "", fasthttp.StatusOK)
+	if _, err := os.Stat(filepath.Join(dir, "numbers")); !os.IsNotExist(err) {
+		t.Errorf("data of the deleted category is still there %v", err)
+	}
+	if _, found, _ := client.GetCategory(context.Background(), "numbers"); found {
+		t.Errorf("settings of the deleted category are still there")
+	}
+	do(fasthttp.MethodPost, "/write?category=numbers", "1\n", fasthttp.StatusNotFound)
+}
+
+func TestCategoryNames(t *testing.T) {
+	client := replication.NewClientWithBackend(replication.NewMemoryBackend(), "test")
+	s := NewServer(client, "luffy", t.TempDir(), "", replication.NewStorage(client, "luffy"), WithAuth("secret"))
+
+	do := func(uri, token string, want int) *fasthttp.RequestCtx {
+		t.Helper()
+		var req fasthttp.RequestCtx
+		req.Request.Header.SetMethod(fasthttp.MethodPost)
+		req.Request.SetRequestURI(uri)
+		req.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+token)
+		req.Request.SetBodyString("1\n")
+		s.handleRequest(&req)
+		if code := req.Response.StatusCode(); code != want {
+			t.Fatalf("%s: got status %d want %d %s", uri, code, want, req.Response.Body())
+		}
+		return &req
+	}
+	names := func(uri, token string) []string {
+		t.Helper()
+		var names []string
+		if err := json.Unmarshal(do(uri, token, fasthttp.StatusOK).Response.Body(), &names); err != nil {
+			t.Fatalf("error decoding categories %v", err)
+		}
+		return names
+	}
+
+	for _, category := range []string{"orders.eu", "orders.us", "payments"} {
+		do("/write?category="+category, "secret", fasthttp.StatusOK)
+	}
+	if err := client.SetCategory(context.Background(), "orders.asia", replication.CategoryConfig{}); err != nil {
+		t.Fatalf("error creating category %v", err)
+	}
+	if got := names("/categories?pattern=orders.*", "secret"); !reflect.DeepEqual(got, []string{"orders.asia", "orders.eu", "orders.us"}) {
+		t.Errorf("got categories %v", got)
+	}
+	do("/categories?pattern=[", "secret", fasthttp.StatusBadRequest)
+
+	var created tokenResponse
+	if err := json.Unmarshal(do("/admin/tokens?principal=alice", "secret", fasthttp.StatusOK).Response.Body(), &created); err != nil {
+		t.Fatalf("error decoding token %v", err)
+	}
+	if err := client.SetGrants(context.Background(), "alice", []replication.Grant{
+		{Category: "orders.eu", Permissions: []replication.Permission{replication.Consume}},
+		{Category: "orders.us", Permissions: []replication.Permission{replication.Produce}},
+		{Category: "payments", Permissions: []replication.Permission{replication.Consume}},
+	}); err != nil {
+		t.Fatalf("error setting grants %v", err)
+	}
+	if got := names("/categories?pattern=orders.*", created.Token); !reflect.DeepEqual(got, []string{"orders.eu"}) {
+		t.Errorf("got categories %v for alice", got)
+	}
+	do("/categories", "", fasthttp.StatusUnauthorized)
+}
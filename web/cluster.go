@@ -0,0 +1,193 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Vignesh-Rajarajan/event-bus/chunk"
+	"github.com/Vignesh-Rajarajan/event-bus/manager"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"github.com/valyala/fasthttp"
+	"sort"
+	"strconv"
+)
+
+const clusterScope = "cluster"
+
+// isClusterScope reports whether the client asked for a view of the whole cluster, peers are only ever asked for their local view
+func isClusterScope(ctx *fasthttp.RequestCtx) bool {
+	return string(ctx.QueryArgs().Peek("scope")) == clusterScope && !isForwarded(ctx)
+}
+
+// listPeerChunks returns the local chunk listing of every peer which responds, keyed by instance name
+func (s *Server) listPeerChunks(ctx context.Context, category string, local []chunk.Chunk) (map[string][]chunk.Chunk, map[string]string, error) {
+	addrs, err := s.peerAddrs(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	listings := map[string][]chunk.Chunk{s.instanceName: local}
+	for name, addr := range addrs {
+		if name == s.instanceName {
+			continue
+		}
+		args := fasthttp.AcquireArgs()
+		args.Add("category", category)
+		resp := fasthttp.AcquireResponse()
+		err := s.peerRequest(addr, "/listChunks", args, resp)
+		fasthttp.ReleaseArgs(args)
+		if err != nil {
+			// only live peers take part in the cluster view
+			s.logger.Warn("error listing chunks on peer", "category", category, "peer", name, "error", err)
+			fasthttp.ReleaseResponse(resp)
+			continue
+		}
+		var chunks []chunk.Chunk
+		err = json.Unmarshal(resp.Body(), &chunks)
+		fasthttp.ReleaseResponse(resp)
+		if err != nil {
+			s.logger.Warn("error decoding chunks from peer", "category", category, "peer", name, "error", err)
+			continue
+		}
+		listings[name] = chunks
+	}
+	return listings, addrs, nil
+}
+
+// mergeChunkListings deduplicates the chunks listed by every instance, the owner's copy is
+// authoritative and every other copy is reported as a replica
+func mergeChunkListings(listings map[string][]chunk.Chunk, addrs map[string]string) []chunk.Chunk {
+	holders := make(map[string]map[string]chunk.Chunk)
+	for instance, chunks := range listings {
+		for _, ch := range chunks {
+			if holders[ch.Name] == nil {
+				holders[ch.Name] = make(map[string]chunk.Chunk)
+			}
+			holders[ch.Name][instance] = ch
+		}
+	}
+
+	merged := make([]chunk.Chunk, 0, len(holders))
+	for name, copies := range holders {
+		owner, _ := manager.ChunkOwner(name)
+		ch := chunk.Chunk{Name: name, Owner: owner, OwnerAddr: addrs[owner]}
+		ownerCopy, ownerLive := copies[owner]
+		if ownerLive {
+			ch.Size = ownerCopy.Size
+			ch.Complete = ownerCopy.Complete
+		}
+
+		instances := make([]string, 0, len(copies))
+		for instance := range copies {
+			if instance != owner {
+				instances = append(instances, instance)
+			}
+		}
+		sort.Strings(instances)
+		for _, instance := range instances {
+			c := copies[instance]
+			ch.Replicas = append(ch.Replicas, chunk.Replica{
+				Instance: instance,
+				Addr:     addrs[instance],
+				Size:     c.Size,
+				Complete: c.Complete,
+			})
+			if !ownerLive && c.Size >= ch.Size {
+				// without the owner the most complete replica is the best we know about
+				ch.Size = c.Size
+				ch.Complete = c.Complete
+			}
+		}
+		merged = append(merged, ch)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Name < merged[j].Name
+	})
+	return merged
+}
+
+// ackCluster acks the chunk on its owner first and then on every replica, so that the copies are
+// only dropped once the chunk is known to be complete and fully processed
+func (s *Server) ackCluster(ctx *fasthttp.RequestCtx, category, fileName string, size uint64) error {
+	local, err := s.localChunks(ctx, category)
+	if err != nil {
+		return err
+	}
+	listings, addrs, err := s.listPeerChunks(ctx, category, local)
+	if err != nil {
+		return err
+	}
+
+	owner, _ := manager.ChunkOwner(fileName)
+	var replicas []string
+	ownerHolds := false
+	for instance, chunks := range listings {
+		for _, ch := range chunks {
+			if ch.Name != fileName {
+				continue
+			}
+			if instance == owner {
+				ownerHolds = true
+			} else {
+				replicas = append(replicas, instance)
+			}
+		}
+	}
+	sort.Strings(replicas)
+	if !ownerHolds && len(replicas) == 0 {
+		return fmt.Errorf("chunk %s not found", fileName)
+	}
+
+	if ownerHolds {
+		if err := s.ackOn(addrs, owner, category, fileName, size, false); err != nil {
+			return err
+		}
+	}
+	for _, instance := range replicas {
+		if err := s.ackOn(addrs, instance, category, fileName, size, ownerHolds); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ackOn acks the copy of the chunk held by the instance, ownerAcked lets a replica drop a copy
+// which was still being downloaded when the owner acked the chunk. The replicas only trust it
+// from a peer authenticated with the cluster token.
+func (s *Server) ackOn(addrs map[string]string, instance, category, fileName string, size uint64, ownerAcked bool) error {
+	args := fasthttp.AcquireArgs()
+	defer fasthttp.ReleaseArgs(args)
+	args.Add("category", category)
+	args.Add("chunk", fileName)
+	args.Add("size", strconv.FormatUint(size, 10))
+	if ownerAcked {
+		args.Add("ownerAcked", "true")
+	}
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	if err := s.peerRequest(addrs[instance], "/ack", args, resp); err != nil {
+		return fmt.Errorf("error acking chunk %s on %s %v", fileName, instance, err)
+	}
+	return nil
+}
+
+// peerRequest sends a GET request to the peer which is served with the peer's local view only
+func (s *Server) peerRequest(addr, path string, args *fasthttp.Args, resp *fasthttp.Response) error {
+	return s.peerRequestWithMethod(fasthttp.MethodGet, addr, path, args, resp)
+}
+
+// peerRequestWithMethod sends a request with the given method to the peer, which serves it locally
+func (s *Server) peerRequestWithMethod(method, addr, path string, args *fasthttp.Args, resp *fasthttp.Response) error {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.Header.SetMethod(method)
+	req.SetRequestURI(fmt.Sprintf("%s://%s%s?%s", s.peerScheme, addr, path, args.QueryString()))
+	req.Header.Set(replication.ForwardedHeader, s.instanceName)
+	s.setPeerAuth(&req.Header)
+	if err := s.httpCli.DoTimeout(req, resp, forwardTimeout); err != nil {
+		return err
+	}
+	if resp.StatusCode() != fasthttp.StatusOK {
+		return fmt.Errorf("status code:: %d - error::%s ", resp.StatusCode(), resp.Body())
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package web
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Vignesh-Rajarajan/event-bus/chunk"
+)
+
+func TestMergeChunkListings(t *testing.T) {
+	addrs := map[string]string{"luffy": "luffy:8080", "zoro": "zoro:8080"}
+	listings := map[string][]chunk.Chunk{
+		"luffy": {
+			{Name: "luffy-chunk000000001", Complete: true, Size: 10},
+			{Name: "zoro-chunk000000001", Complete: false, Size: 4},
+		},
+		"zoro": {
+			{Name: "luffy-chunk000000001", Complete: false, Size: 6},
+			{Name: "nami-chunk000000001", Complete: true, Size: 8},
+		},
+	}
+
+	want := []chunk.Chunk{
+		{
+			Name: "luffy-chunk000000001", Complete: true, Size: 10, Owner: "luffy", OwnerAddr: "luffy:8080",
+			Replicas: []chunk.Replica{{Instance: "zoro", Addr: "zoro:8080", Size: 6}},
+		},
+		{
+			Name: "nami-chunk000000001", Complete: true, Size: 8, Owner: "nami",
+			Replicas: []chunk.Replica{{Instance: "zoro", Addr: "zoro:8080", Size: 8, Complete: true}},
+		},
+		{
+			Name: "zoro-chunk000000001", Complete: false, Size: 4, Owner: "zoro", OwnerAddr: "zoro:8080",
+			Replicas: []chunk.Replica{{Instance: "luffy", Addr: "luffy:8080", Size: 4}},
+		},
+	}
+	got := mergeChunkListings(listings, addrs)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v want %+v", got, want)
+	}
+}
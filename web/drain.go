@@ -0,0 +1,228 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Vignesh-Rajarajan/event-bus/manager"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"github.com/valyala/fasthttp"
+	"time"
+)
+
+const (
+	drainPollInterval   = time.Second
+	defaultDrainTimeout = time.Hour
+)
+
+// WithDrainTimeout bounds the time given to the chunks of a draining instance to be copied to the
+// other peers, the instance keeps rejecting writes when it is exceeded and the drain can be started again
+func WithDrainTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.drainTimeout = timeout
+	}
+}
+
+type drainStatus struct {
+	Instance string                 `json:"instance"`
+	State    replication.DrainState `json:"state"`
+}
+
+// drainHandler starts decommissioning an instance on POST and reports its progress on GET
+func (s *Server) drainHandler(ctx *fasthttp.RequestCtx) {
+	instance := string(ctx.QueryArgs().Peek("instance"))
+	if instance == "" {
+		instance = s.instanceName
+	}
+	switch {
+	case ctx.IsGet():
+		states, err := s.replicationClient.ListDrainStates(ctx)
+		if err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+			return
+		}
+		if err := json.NewEncoder(ctx).Encode(drainStatus{Instance: instance, State: states[instance]}); err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		}
+	case ctx.IsPost():
+		if instance != s.instanceName {
+			// only the instance itself knows which chunks it holds
+			s.forwardToPeer(ctx, instance)
+			return
+		}
+		s.stopWrites()
+		if !s.drainRunning.CompareAndSwap(false, true) {
+			ctx.SetStatusCode(fasthttp.StatusAccepted)
+			return
+		}
+		if err := s.replicationClient.SetDrainState(ctx, s.instanceName, replication.Draining); err != nil {
+			s.drainRunning.Store(false)
+			s.draining.Store(false)
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+			return
+		}
+		logger := s.requestLogger(ctx)
+		go func() {
+			defer s.drainRunning.Store(false)
+			drainCtx, cancel := context.WithTimeout(context.Background(), s.drainTimeout)
+			defer cancel()
+			if err := s.drain(drainCtx); err != nil {
+				logger.Error("error draining instance", "error", err)
+			}
+		}()
+		ctx.SetStatusCode(fasthttp.StatusAccepted)
+	default:
+		ctx.Error("method not allowed", fasthttp.StatusMethodNotAllowed)
+	}
+}
+
+// drain hands over everything the current instance is responsible for and then removes it from the cluster:
+// it gives up its leaderships, seals the chunks being written into, waits until every chunk it holds has
+// enough complete copies on the remaining peers and finally deregisters itself
+func (s *Server) drain(ctx context.Context) error {
+	s.logger.Info("draining instance")
+	if s.leadership != nil {
+		if err := s.leadership.Close(); err != nil {
+			s.logger.Error("error giving up leadership", "error", err)
+		}
+	}
+	s.stopWrites()
+	s.m.Lock()
+	for _, storage := range s.storages {
+		storage.Seal()
+	}
+	s.m.Unlock()
+
+	queued := make(map[string]map[string]bool)
+	for {
+		pending, err := s.pendingDrainChunks(ctx, queued)
+		if err != nil {
+			s.logger.Error("error checking chunks left to drain", "error", err)
+		} else if pending == 0 {
+			break
+		} else {
+			s.logger.Info("waiting for chunks to be copied to other peers", "pending", pending)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("gave up waiting for chunks to be copied to other peers %w", ctx.Err())
+		case <-time.After(drainPollInterval):
+		}
+	}
+
+	if err := s.replicationClient.DeleteReplicationQueue(ctx, s.instanceName); err != nil {
+		return fmt.Errorf("error deleting replication queue %v", err)
+	}
+	categories, err := s.localCategories()
+	if err != nil {
+		return err
+	}
+	for _, category := range categories {
+		replicas, err := s.replicationClient.ListReplicas(ctx, category)
+		if err != nil {
+			return fmt.Errorf("error listing replicas of %s %v", category, err)
+		}
+		for _, r := range replicas {
+			if r.Instance != s.instanceName {
+				continue
+			}
+			if err := s.replicationClient.DeleteReplicaState(ctx, category, r); err != nil {
+				return fmt.Errorf("error deleting replica state of %s %v", r.FileName, err)
+			}
+		}
+	}
+	if err := s.replicationClient.DeletePeer(ctx, s.instanceName); err != nil {
+		return fmt.Errorf("error deregistering peer %v", err)
+	}
+	s.logger.Info("instance drained")
+	return s.replicationClient.SetDrainState(ctx, s.instanceName, replication.Drained)
+}
+
+// stopWrites rejects the writes from now on, it returns once the writes in flight are done
+func (s *Server) stopWrites() {
+	s.writeMu.Lock()
+	s.draining.Store(true)
+	s.writeMu.Unlock()
+}
+
+// pendingDrainChunks counts the local chunks which do not have enough complete copies on the live peers yet
+// and queues them for replication to the peers picked by the placement policy
+func (s *Server) pendingDrainChunks(ctx context.Context, queued map[string]map[string]bool) (int, error) {
+	peers, err := s.replicationClient.ListPeers(ctx)
+	if err != nil {
+		return 0, err
+	}
+	draining, err := s.replicationClient.ListDrainStates(ctx)
+	if err != nil {
+		return 0, err
+	}
+	live := make(map[string]bool)
+	for _, p := range peers {
+		if _, ok := draining[p.Name]; !ok && p.Name != s.instanceName {
+			live[p.Name] = true
+		}
+	}
+
+	categories, err := s.localCategories()
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, category := range categories {
+		cfg, _, err := s.replicationClient.GetCategory(ctx, category)
+		if err != nil {
+			return 0, err
+		}
+		storage, err := s.getStorage(category)
+		if err != nil {
+			return 0, err
+		}
+		chunks, err := storage.ListChunks()
+		if err != nil {
+			return 0, err
+		}
+		replicas, err := s.replicationClient.ListReplicas(ctx, category)
+		if err != nil {
+			return 0, err
+		}
+		for _, ch := range chunks {
+			owner, ok := manager.ChunkOwner(ch.Name)
+			if !ok || (owner != s.instanceName && live[owner]) {
+				continue
+			}
+			// the copies are counted among the peers which stay in the cluster
+			required := s.replicationStorage.Copies(cfg.ReplicationFactor, len(live))
+			exclude := map[string]bool{}
+			copies := 0
+			for _, r := range replicas {
+				if r.FileName == ch.Name && live[r.Instance] && r.Complete && r.Size >= ch.Size {
+					exclude[r.Instance] = true
+					copies++
+				}
+			}
+			if copies >= required && copies > 0 {
+				continue
+			}
+			pending++
+			key := category + "/" + ch.Name
+			for peer := range queued[key] {
+				exclude[peer] = true
+			}
+			// the chunk is downloaded from the current instance even if it was created by another one
+			targets, err := s.replicationStorage.Replicate(ctx, category, ch.Name, replication.Peer{Name: s.instanceName}, exclude, required-len(exclude))
+			if err != nil {
+				return 0, err
+			}
+			for _, peer := range targets {
+				if queued[key] == nil {
+					queued[key] = make(map[string]bool)
+				}
+				queued[key][peer.Name] = true
+			}
+		}
+	}
+	if pending > 0 && len(live) == 0 {
+		return pending, fmt.Errorf("no live peers to copy %d chunks to", pending)
+	}
+	return pending, nil
+}
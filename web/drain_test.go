@@ -0,0 +1,79 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"github.com/valyala/fasthttp"
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+	ctx := context.Background()
+	client := replication.NewClientWithBackend(replication.NewMemoryBackend(), "test")
+	s := NewServer(client, "luffy", t.TempDir(), "", replication.NewStorage(client, "luffy"))
+	for _, p := range []replication.Peer{{Name: "luffy", Addr: "luffy:8080"}, {Name: "zoro", Addr: "zoro:8080"}} {
+		if err := client.RegisterPeer(ctx, p); err != nil {
+			t.Fatalf("error registering peer %v", err)
+		}
+	}
+	write := func(want int) {
+		t.Helper()
+		var req fasthttp.RequestCtx
+		req.Request.Header.SetMethod(fasthttp.MethodPost)
+		req.Request.SetRequestURI("/write?category=numbers")
+		req.Request.SetBodyString("1\n")
+		s.handleRequest(&req)
+		if code := req.Response.StatusCode(); code != want {
+			t.Fatalf("got status %d want %d %s", code, want, req.Response.Body())
+		}
+	}
+	write(fasthttp.StatusOK)
+
+	// zoro never copies the chunk, the drain gives up once its context is done
+	timeoutCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if err := s.drain(timeoutCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("drain() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	write(fasthttp.StatusServiceUnavailable)
+
+	if err := client.SetReplicaState(ctx, "numbers", replication.ReplicaState{
+		Instance: "zoro",
+		FileName: "luffy-chunk000000000",
+		Size:     2,
+		Complete: true,
+	}); err != nil {
+		t.Fatalf("error setting replica state %v", err)
+	}
+	if err := s.drain(ctx); err != nil {
+		t.Fatalf("drain() = %v", err)
+	}
+	peers, err := client.ListPeers(ctx)
+	if err != nil {
+		t.Fatalf("error listing peers %v", err)
+	}
+	if len(peers) != 1 || peers[0].Name != "zoro" {
+		t.Errorf("got peers %v once drained, want only zoro", peers)
+	}
+	states, err := client.ListDrainStates(ctx)
+	if err != nil {
+		t.Fatalf("error listing drain states %v", err)
+	}
+	if states["luffy"] != replication.Drained {
+		t.Errorf("got drain state %q want %q", states["luffy"], replication.Drained)
+	}
+
+	// the instance comes back under the same name and receives copies again
+	if err := client.RegisterPeer(ctx, replication.Peer{Name: "luffy", Addr: "luffy:8080"}); err != nil {
+		t.Fatalf("error registering peer %v", err)
+	}
+	if states, err := client.ListDrainStates(ctx); err != nil || len(states) != 0 {
+		t.Errorf("got drain states %v error %v once registered again", states, err)
+	}
+	targets, err := replication.NewStorage(client, "zoro").Replicate(ctx, "numbers", "zoro-chunk000000000", replication.Peer{Name: "zoro"}, nil, 1)
+	if err != nil || len(targets) != 1 || targets[0].Name != "luffy" {
+		t.Errorf("got replication targets %v error %v, want luffy", targets, err)
+	}
+}
@@ -0,0 +1,190 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Vignesh-Rajarajan/event-bus/chunk"
+	"github.com/Vignesh-Rajarajan/event-bus/manager"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"github.com/Vignesh-Rajarajan/event-bus/tracing"
+	"github.com/valyala/fasthttp"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isForwarded reports whether the request was proxied by a peer, such requests are always served locally
+func isForwarded(ctx *fasthttp.RequestCtx) bool {
+	return len(ctx.Request.Header.Peek(replication.ForwardedHeader)) > 0
+}
+
+func (s *Server) hasLocalCategory(category string) bool {
+	if !isValidCategory(category) {
+		return false
+	}
+	s.m.Lock()
+	_, ok := s.storages[category]
+	s.m.Unlock()
+	if ok {
+		return true
+	}
+	fi, err := os.Stat(filepath.Join(s.dirname, category))
+	return err == nil && fi.IsDir()
+}
+
+// isValidChunk tells whether the name can only designate a chunk file of the category directory
+func isValidChunk(name string) bool {
+	return name != "" && filepath.Base(name) == name && !strings.HasPrefix(name, ".")
+}
+
+func (s *Server) hasLocalChunk(category, chunk string) bool {
+	if !isValidCategory(category) || !isValidChunk(chunk) {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(s.dirname, category, chunk))
+	return err == nil
+}
+
+func (s *Server) peerAddrs(ctx context.Context) (map[string]string, error) {
+	peers, err := s.replicationClient.ListPeers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing peers %v", err)
+	}
+	addrs := make(map[string]string, len(peers))
+	for _, p := range peers {
+		addrs[p.Name] = p.Addr
+	}
+	return addrs, nil
+}
+
+// chunkHolders returns the peers which hold a copy of the chunk, starting with its owner
+func (s *Server) chunkHolders(ctx *fasthttp.RequestCtx, category, fileName string) ([]string, error) {
+	var holders []string
+	owner, ok := manager.ChunkOwner(fileName)
+	if ok && owner != s.instanceName {
+		holders = append(holders, owner)
+	}
+	replicas, err := s.replicationClient.ListReplicas(ctx, category)
+	if err != nil {
+		return nil, fmt.Errorf("error listing replicas %v", err)
+	}
+	for _, r := range replicas {
+		if r.FileName != fileName || r.Instance == owner || r.Instance == s.instanceName {
+			continue
+		}
+		holders = append(holders, r.Instance)
+	}
+	return holders, nil
+}
+
+// forwardChunkRequest proxies a request for a chunk which is not stored locally to a peer holding it
+func (s *Server) forwardChunkRequest(ctx *fasthttp.RequestCtx, category, fileName string) {
+	holders, err := s.chunkHolders(ctx, category, fileName)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	if len(holders) == 0 {
+		ctx.Error(fmt.Sprintf("chunk %s not found", fileName), fasthttp.StatusNotFound)
+		return
+	}
+	s.forwardToAny(ctx, holders)
+}
+
+// forwardListChunks asks the peers for the chunks of a category which has none locally and
+// responds with the first non-empty listing, it reports whether a listing was found
+func (s *Server) forwardListChunks(ctx *fasthttp.RequestCtx) bool {
+	addrs, err := s.peerAddrs(ctx)
+	if err != nil {
+		s.requestLogger(ctx).Warn("error forwarding listChunks", "error", err)
+		return false
+	}
+	for name, addr := range addrs {
+		if name == s.instanceName {
+			continue
+		}
+		resp := fasthttp.AcquireResponse()
+		if err := s.forward(ctx, addr, resp); err != nil {
+			s.requestLogger(ctx).Warn("error forwarding listChunks", "peer", name, "error", err)
+			fasthttp.ReleaseResponse(resp)
+			continue
+		}
+		var chunks []chunk.Chunk
+		if resp.StatusCode() == fasthttp.StatusOK && json.Unmarshal(resp.Body(), &chunks) == nil && len(chunks) > 0 {
+			resp.CopyTo(&ctx.Response)
+			fasthttp.ReleaseResponse(resp)
+			return true
+		}
+		fasthttp.ReleaseResponse(resp)
+	}
+	return false
+}
+
+// forwardToPeer proxies the request as is to the peer and copies the peer's response back
+func (s *Server) forwardToPeer(ctx *fasthttp.RequestCtx, peerName string) {
+	if isForwarded(ctx) {
+		ctx.Error(fmt.Sprintf("request already forwarded, %s is not responsible for it", s.instanceName), fasthttp.StatusServiceUnavailable)
+		return
+	}
+	s.forwardToAny(ctx, []string{peerName})
+}
+
+// forwardToAny proxies the request to the first of the peers which serves it successfully, when none
+// does the response of the last peer which responded is copied back
+func (s *Server) forwardToAny(ctx *fasthttp.RequestCtx, peerNames []string) {
+	addrs, err := s.peerAddrs(ctx)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	lastErr := fmt.Errorf("peers %v are not registered", peerNames)
+	var failed *fasthttp.Response
+	defer func() {
+		if failed != nil {
+			fasthttp.ReleaseResponse(failed)
+		}
+	}()
+	for _, name := range peerNames {
+		addr, ok := addrs[name]
+		if !ok {
+			continue
+		}
+		resp := fasthttp.AcquireResponse()
+		err := s.forward(ctx, addr, resp)
+		if err != nil {
+			fasthttp.ReleaseResponse(resp)
+			lastErr = fmt.Errorf("error forwarding request to %s %v", name, err)
+			continue
+		}
+		if resp.StatusCode() < fasthttp.StatusMultipleChoices {
+			resp.CopyTo(&ctx.Response)
+			fasthttp.ReleaseResponse(resp)
+			return
+		}
+		// the peer may have lost its copy in the meantime, the next one may still serve it
+		s.requestLogger(ctx).Warn("peer failed forwarded request", "peer", name, "status", resp.StatusCode())
+		if failed != nil {
+			fasthttp.ReleaseResponse(failed)
+		}
+		failed = resp
+	}
+	if failed != nil {
+		failed.CopyTo(&ctx.Response)
+		return
+	}
+	ctx.Error(lastErr.Error(), fasthttp.StatusBadGateway)
+}
+
+func (s *Server) forward(ctx *fasthttp.RequestCtx, addr string, resp *fasthttp.Response) error {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	ctx.Request.CopyTo(req)
+	req.SetHost(addr)
+	req.URI().SetScheme(s.peerScheme)
+	req.Header.Set(replication.ForwardedHeader, s.instanceName)
+	// the request has been authorized already, the peer trusts the current instance
+	s.setPeerAuth(&req.Header)
+	tracing.Propagator.Inject(requestContext(ctx), requestHeaderCarrier{h: &req.Header})
+	return s.httpCli.DoTimeout(req, resp, forwardTimeout)
+}
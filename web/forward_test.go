@@ -0,0 +1,45 @@
+package web
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHasLocalChunk(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "numbers"), 0777); err != nil {
+		t.Fatalf("error creating category dir %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "numbers", "luffy-chunk000000001"), []byte("1\n"), 0666); err != nil {
+		t.Fatalf("error creating chunk %v", err)
+	}
+	s := &Server{dirname: dir}
+
+	testCases := []struct {
+		desc     string
+		category string
+		chunk    string
+		local    bool
+	}{
+		{desc: "existing chunk", category: "numbers", chunk: "luffy-chunk000000001", local: true},
+		{desc: "missing chunk", category: "numbers", chunk: "zoro-chunk000000001", local: false},
+		{desc: "missing category", category: "letters", chunk: "luffy-chunk000000001", local: false},
+		{desc: "chunk outside of category", category: "numbers", chunk: "../numbers", local: false},
+		{desc: "manifest", category: "numbers", chunk: ".luffy-chunk000000001.manifest", local: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := s.hasLocalChunk(tc.category, tc.chunk); got != tc.local {
+				t.Errorf("got %v want %v", got, tc.local)
+			}
+		})
+	}
+
+	if !s.hasLocalCategory("numbers") {
+		t.Errorf("category numbers should be local")
+	}
+	if s.hasLocalCategory("letters") {
+		t.Errorf("category letters should not be local")
+	}
+}
@@ -0,0 +1,151 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Vignesh-Rajarajan/event-bus/diskspace"
+	"github.com/valyala/fasthttp"
+	"os"
+	"sort"
+	"time"
+)
+
+const readinessTimeout = 2 * time.Second
+
+// Check is the outcome of one of the readiness checks
+type Check struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// Readiness tells whether the instance can serve traffic, it is ready when every check passed
+type Readiness struct {
+	Ready  bool    `json:"ready"`
+	Checks []Check `json:"checks"`
+}
+
+// Status describes the instance for operators
+type Status struct {
+	Instance      string           `json:"instance"`
+	Cluster       string           `json:"cluster,omitempty"`
+	StartedAt     time.Time        `json:"startedAt"`
+	UptimeSeconds float64          `json:"uptimeSeconds"`
+	Draining      bool             `json:"draining"`
+	Categories    []string         `json:"categories"`
+	Disk          *diskspace.Usage `json:"disk,omitempty"`
+	DiskError     string           `json:"diskError,omitempty"`
+	// DiskWatermarks is set when the writes are rejected above a disk usage
+	DiskWatermarks *DiskWatermarks `json:"diskWatermarks,omitempty"`
+}
+
+// CheckDataDir makes sure files can be created in the data directory
+func CheckDataDir(dirname string) error {
+	fp, err := os.CreateTemp(dirname, ".check-*")
+	if err != nil {
+		return fmt.Errorf("error creating a file in directory %s %v", dirname, err)
+	}
+	_ = fp.Close()
+	return os.Remove(fp.Name())
+}
+
+// healthzHandler reports that the process is up and serving requests
+func (s *Server) healthzHandler(ctx *fasthttp.RequestCtx) {
+	ctx.SetContentType("text/plain")
+	ctx.SetBodyString("ok\n")
+}
+
+// readyzHandler reports whether the instance can take traffic, it fails with 503 when any check does
+func (s *Server) readyzHandler(ctx *fasthttp.RequestCtx) {
+	// the checks have their own deadline, a fasthttp context is only cancelled when the server shuts down
+	readiness := s.readiness(context.Background())
+	if !readiness.Ready {
+		ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+	}
+	ctx.SetContentType("application/json")
+	if err := json.NewEncoder(ctx).Encode(readiness); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+	}
+}
+
+func (s *Server) readiness(ctx context.Context) Readiness {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	checks := []Check{newCheck("dataDir", CheckDataDir(s.dirname))}
+	peers, err := s.replicationClient.ListPeers(ctx)
+	checks = append(checks, newCheck("coordination", err))
+	if err == nil {
+		registered := false
+		for _, p := range peers {
+			if p.Name == s.instanceName {
+				registered = true
+			}
+		}
+		if !registered {
+			err = fmt.Errorf("instance %s is not registered as a peer", s.instanceName)
+		}
+		checks = append(checks, newCheck("registered", err))
+	}
+	var draining error
+	if s.draining.Load() {
+		draining = fmt.Errorf("instance %s is draining", s.instanceName)
+	}
+	checks = append(checks, newCheck("notDraining", draining))
+
+	readiness := Readiness{Ready: true, Checks: checks}
+	for _, c := range checks {
+		readiness.Ready = readiness.Ready && c.OK
+	}
+	return readiness
+}
+
+func newCheck(name string, err error) Check {
+	if err != nil {
+		return Check{Name: name, Error: err.Error()}
+	}
+	return Check{Name: name, OK: true}
+}
+
+// statusHandler describes the instance, its categories and the space left on its disk
+func (s *Server) statusHandler(ctx *fasthttp.RequestCtx) {
+	status, err := s.status()
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	if err := json.NewEncoder(ctx).Encode(status); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+	}
+}
+
+func (s *Server) status() (Status, error) {
+	categories, err := s.localCategories()
+	if err != nil {
+		return Status{}, err
+	}
+	sort.Strings(categories)
+	status := Status{
+		Instance:      s.instanceName,
+		Cluster:       s.cluster,
+		StartedAt:     s.startedAt,
+		UptimeSeconds: time.Since(s.startedAt).Seconds(),
+		Draining:      s.draining.Load(),
+		Categories:    categories,
+	}
+	if status.Categories == nil {
+		status.Categories = []string{}
+	}
+	if usage, err := diskspace.Get(s.dirname); err != nil {
+		status.DiskError = err.Error()
+	} else {
+		status.Disk = &usage
+	}
+	if s.disk.enabled() {
+		watermarks := s.disk.state()
+		status.DiskWatermarks = &watermarks
+	}
+	return status, nil
+}
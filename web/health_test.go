@@ -0,0 +1,75 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"github.com/valyala/fasthttp"
+	"testing"
+)
+
+func TestReadiness(t *testing.T) {
+	ctx := context.Background()
+	client := replication.NewClientWithBackend(replication.NewMemoryBackend(), "test")
+	s := NewServer(client, "luffy", t.TempDir(), "", replication.NewStorage(client, "luffy"))
+
+	failed := func() map[string]bool {
+		r := s.readiness(ctx)
+		failed := make(map[string]bool)
+		for _, c := range r.Checks {
+			if !c.OK {
+				failed[c.Name] = true
+			}
+		}
+		if r.Ready != (len(failed) == 0) {
+			t.Errorf("got ready %v with failed checks %v", r.Ready, failed)
+		}
+		return failed
+	}
+
+	if got := failed(); !got["registered"] || len(got) != 1 {
+		t.Errorf("unregistered instance: got failed checks %v", got)
+	}
+	if err := client.RegisterPeer(ctx, replication.Peer{Name: "luffy", Addr: "127.0.0.1:8080"}); err != nil {
+		t.Fatalf("error registering peer %v", err)
+	}
+	if got := failed(); len(got) != 0 {
+		t.Errorf("registered instance: got failed checks %v", got)
+	}
+	s.draining.Store(true)
+	if got := failed(); !got["notDraining"] || len(got) != 1 {
+		t.Errorf("draining instance: got failed checks %v", got)
+	}
+
+	var req fasthttp.RequestCtx
+	req.Request.SetRequestURI("/readyz")
+	s.handleRequest(&req)
+	if code := req.Response.StatusCode(); code != fasthttp.StatusServiceUnavailable {
+		t.Errorf("got status %d want %d", code, fasthttp.StatusServiceUnavailable)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	client := replication.NewClientWithBackend(replication.NewMemoryBackend(), "test")
+	s := NewServer(client, "luffy", t.TempDir(), "", replication.NewStorage(client, "luffy"), WithCluster("test"))
+	if _, err := s.getStorage("numbers"); err != nil {
+		t.Fatalf("error getting storage %v", err)
+	}
+
+	var req fasthttp.RequestCtx
+	req.Request.SetRequestURI("/status")
+	s.handleRequest(&req)
+	if code := req.Response.StatusCode(); code != fasthttp.StatusOK {
+		t.Fatalf("got status %d %s", code, req.Response.Body())
+	}
+	var status Status
+	if err := json.Unmarshal(req.Response.Body(), &status); err != nil {
+		t.Fatalf("error decoding status %v", err)
+	}
+	if status.Instance != "luffy" || status.Cluster != "test" || len(status.Categories) != 1 || status.Categories[0] != "numbers" {
+		t.Errorf("got status %+v", status)
+	}
+	if status.Disk == nil && status.DiskError == "" {
+		t.Errorf("status reports neither the disk space nor an error")
+	}
+}
@@ -0,0 +1,309 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Vignesh-Rajarajan/event-bus/chunk"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/valyala/fasthttp"
+	"strconv"
+	"time"
+)
+
+// defaultConsumerLagExpiry is how long after their last commit the consumers stop being reported as metrics
+const defaultConsumerLagExpiry = 24 * time.Hour
+
+var (
+	consumerLagBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "event_bus_consumer_lag_bytes",
+		Help: "Bytes of the category the consumer has not processed yet.",
+	}, []string{"category", "consumer"})
+	consumerLagMessages = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "event_bus_consumer_lag_messages",
+		Help: "Messages of the category the consumer has not processed yet.",
+	}, []string{"category", "consumer"})
+	consumerLagSeconds = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "event_bus_consumer_lag_seconds",
+		Help: "Age of the oldest chunk of the category the consumer has not fully processed.",
+	}, []string{"category", "consumer"})
+)
+
+// WithConsumerLagExpiry stops publishing the lag metrics of the consumers which have not committed for the
+// given duration, so that the consumers which are gone do not keep their series forever. /lag still reports them.
+func WithConsumerLagExpiry(expiry time.Duration) Option {
+	return func(s *Server) {
+		s.lagExpiry = expiry
+	}
+}
+
+// ConsumerLag is how far behind the head of a category a consumer is
+type ConsumerLag struct {
+	Category    string    `json:"category"`
+	Consumer    string    `json:"consumer"`
+	Chunk       string    `json:"chunk"`
+	Offset      uint64    `json:"offset"`
+	CommittedAt time.Time `json:"committedAt"`
+	Bytes       uint64    `json:"lagBytes"`
+	Messages    uint64    `json:"lagMessages"`
+	// Seconds is the age of the first chunk with unprocessed data, the oldest unprocessed
+	// message is at least that old
+	Seconds float64 `json:"lagSeconds"`
+}
+
+type countMessagesResponse struct {
+	Messages uint64    `json:"messages"`
+	ModTime  time.Time `json:"modTime"`
+}
+
+// ReportConsumerLag periodically publishes the lag of the consumers of the local categories as metrics
+func (s *Server) ReportConsumerLag(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		categories, err := s.localCategories()
+		if err != nil {
+			s.logger.Error("error listing categories", "error", err)
+			continue
+		}
+		for _, category := range categories {
+			if _, err := s.consumerLag(ctx, category); err != nil {
+				s.logger.Error("error computing consumer lag", "category", category, "error", err)
+			}
+		}
+	}
+}
+
+// commitHandler records the position a consumer has processed the category up to
+func (s *Server) commitHandler(ctx *fasthttp.RequestCtx) {
+	category := string(ctx.QueryArgs().Peek("category"))
+	if !isValidCategory(category) {
+		ctx.Error(fmt.Sprintf("invalid category %s", category), fasthttp.StatusBadRequest)
+		return
+	}
+	consumer := string(ctx.QueryArgs().Peek("consumer"))
+	if !isValidCategory(consumer) {
+		ctx.Error(fmt.Sprintf("invalid consumer %s", consumer), fasthttp.StatusBadRequest)
+		return
+	}
+	chunk := string(ctx.QueryArgs().Peek("chunk"))
+	if chunk == "" {
+		ctx.Error("chunk cannot be empty", fasthttp.StatusBadRequest)
+		return
+	}
+	offset, err := ctx.QueryArgs().GetUint("offset")
+	if err != nil {
+		ctx.Error(fmt.Sprintf("bad `offset` getParam: %v", err.Error()), fasthttp.StatusBadRequest)
+		return
+	}
+	if err := s.replicationClient.SetConsumerOffset(ctx, category, replication.ConsumerOffset{
+		Consumer:    consumer,
+		Chunk:       chunk,
+		Offset:      uint64(offset),
+		CommittedAt: time.Now(),
+	}); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+	}
+}
+
+// lagHandler reports the lag of every consumer of the category, or of the given consumer only
+func (s *Server) lagHandler(ctx *fasthttp.RequestCtx) {
+	category := string(ctx.QueryArgs().Peek("category"))
+	if !isValidCategory(category) {
+		ctx.Error(fmt.Sprintf("invalid category %s", category), fasthttp.StatusBadRequest)
+		return
+	}
+	lags, err := s.consumerLag(ctx, category)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	if consumer := string(ctx.QueryArgs().Peek("consumer")); consumer != "" {
+		filtered := []ConsumerLag{}
+		for _, l := range lags {
+			if l.Consumer == consumer {
+				filtered = append(filtered, l)
+			}
+		}
+		lags = filtered
+	}
+	if err := json.NewEncoder(ctx).Encode(lags); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+	}
+}
+
+// consumerLag compares the committed position of every consumer with the chunks stored across the cluster,
+// chunks are consumed in the order of their names so everything after the committed position is unprocessed
+func (s *Server) consumerLag(ctx context.Context, category string) ([]ConsumerLag, error) {
+	offsets, err := s.replicationClient.ListConsumerOffsets(ctx, category)
+	if err != nil {
+		return nil, err
+	}
+	local, err := s.localChunks(ctx, category)
+	if err != nil {
+		return nil, err
+	}
+	listings, addrs, err := s.listPeerChunks(ctx, category, local)
+	if err != nil {
+		return nil, err
+	}
+	chunks := mergeChunkListings(listings, addrs)
+
+	// most consumers read whole chunks which are counted only once
+	whole := make(map[string]countMessagesResponse)
+	now := time.Now()
+	lags := make([]ConsumerLag, 0, len(offsets))
+	for _, o := range offsets {
+		lag := ConsumerLag{Category: category, Consumer: o.Consumer, Chunk: o.Chunk, Offset: o.Offset, CommittedAt: o.CommittedAt}
+		aged := false
+		for _, ch := range chunks {
+			var from uint64
+			if ch.Name < o.Chunk {
+				continue
+			} else if ch.Name == o.Chunk {
+				from = o.Offset
+			}
+			if from >= ch.Size {
+				continue
+			}
+			lag.Bytes += ch.Size - from
+			count, ok := whole[ch.Name]
+			if !ok || from > 0 {
+				count, err = s.countMessages(ctx, category, ch, listings, addrs, from)
+				if err != nil {
+					return nil, err
+				}
+				if from == 0 {
+					whole[ch.Name] = count
+				}
+			}
+			lag.Messages += count.Messages
+			if !aged {
+				lag.Seconds = now.Sub(count.ModTime).Seconds()
+				aged = true
+			}
+		}
+		lags = append(lags, lag)
+	}
+
+	consumerLagBytes.DeletePartialMatch(prometheus.Labels{"category": category})
+	consumerLagMessages.DeletePartialMatch(prometheus.Labels{"category": category})
+	consumerLagSeconds.DeletePartialMatch(prometheus.Labels{"category": category})
+	for _, l := range lags {
+		if now.Sub(l.CommittedAt) > s.lagExpiry {
+			continue
+		}
+		consumerLagBytes.WithLabelValues(category, l.Consumer).Set(float64(l.Bytes))
+		consumerLagMessages.WithLabelValues(category, l.Consumer).Set(float64(l.Messages))
+		consumerLagSeconds.WithLabelValues(category, l.Consumer).Set(l.Seconds)
+	}
+	return lags, nil
+}
+
+// countMessages counts the messages of the chunk after the from offset, using the local copy
+// when it is complete enough and otherwise the owner or a replica
+func (s *Server) countMessages(ctx context.Context, category string, ch chunk.Chunk, listings map[string][]chunk.Chunk, addrs map[string]string, from uint64) (countMessagesResponse, error) {
+	var holders []string
+	for _, instance := range append([]string{s.instanceName, ch.Owner}, replicaInstances(ch)...) {
+		for _, c := range listings[instance] {
+			if c.Name == ch.Name && c.Size >= ch.Size {
+				holders = append(holders, instance)
+			}
+		}
+	}
+	var lastErr error
+	for _, instance := range holders {
+		if instance == s.instanceName {
+			storage, err := s.getStorage(category)
+			if err != nil {
+				return countMessagesResponse{}, err
+			}
+			messages, modTime, err := storage.CountMessages(ch.Name, from, ch.Size)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			return countMessagesResponse{Messages: messages, ModTime: modTime}, nil
+		}
+		count, err := s.peerCountMessages(addrs[instance], category, ch.Name, from, ch.Size)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return count, nil
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no instance holds chunk %s", ch.Name)
+	}
+	return countMessagesResponse{}, lastErr
+}
+
+func replicaInstances(ch chunk.Chunk) []string {
+	instances := make([]string, 0, len(ch.Replicas))
+	for _, r := range ch.Replicas {
+		instances = append(instances, r.Instance)
+	}
+	return instances
+}
+
+func (s *Server) peerCountMessages(addr, category, fileName string, from, to uint64) (countMessagesResponse, error) {
+	args := fasthttp.AcquireArgs()
+	defer fasthttp.ReleaseArgs(args)
+	args.Add("category", category)
+	args.Add("chunk", fileName)
+	args.Add("from", strconv.FormatUint(from, 10))
+	args.Add("to", strconv.FormatUint(to, 10))
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	if err := s.peerRequest(addr, "/countMessages", args, resp); err != nil {
+		return countMessagesResponse{}, err
+	}
+	var res countMessagesResponse
+	if err := json.Unmarshal(resp.Body(), &res); err != nil {
+		return countMessagesResponse{}, err
+	}
+	return res, nil
+}
+
+func (s *Server) countMessagesHandler(ctx *fasthttp.RequestCtx) {
+	category := string(ctx.QueryArgs().Peek("category"))
+	if !s.hasLocalCategory(category) {
+		ctx.Error(fmt.Sprintf("category %s not found", category), fasthttp.StatusNotFound)
+		return
+	}
+	chunk := string(ctx.QueryArgs().Peek("chunk"))
+	if !isValidChunk(chunk) {
+		ctx.Error(fmt.Sprintf("invalid chunk %s", chunk), fasthttp.StatusBadRequest)
+		return
+	}
+	from, err := ctx.QueryArgs().GetUint("from")
+	if err != nil {
+		ctx.Error(fmt.Sprintf("bad `from` getParam: %v", err.Error()), fasthttp.StatusBadRequest)
+		return
+	}
+	to, err := ctx.QueryArgs().GetUint("to")
+	if err != nil {
+		ctx.Error(fmt.Sprintf("bad `to` getParam: %v", err.Error()), fasthttp.StatusBadRequest)
+		return
+	}
+	storage, err := s.getStorage(category)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	messages, modTime, err := storage.CountMessages(chunk, uint64(from), uint64(to))
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(ctx).Encode(countMessagesResponse{Messages: messages, ModTime: modTime}); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,110 @@
+package web
+
+import (
+	"context"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/valyala/fasthttp"
+	"testing"
+	"time"
+)
+
+func TestConsumerLag(t *testing.T) {
+	ctx := context.Background()
+	client := replication.NewClientWithBackend(replication.NewMemoryBackend(), "test")
+	s := NewServer(client, "luffy", t.TempDir(), "", replication.NewStorage(client, "luffy"))
+	storage, err := s.getStorage("numbers")
+	if err != nil {
+		t.Fatalf("error getting storage %v", err)
+	}
+	if err := storage.Write(ctx, []byte("1\n2\n3\n")); err != nil {
+		t.Fatalf("error writing %v", err)
+	}
+	storage.Seal()
+	if err := storage.Write(ctx, []byte("4\n5\n")); err != nil {
+		t.Fatalf("error writing %v", err)
+	}
+
+	offsets := []replication.ConsumerOffset{
+		{Consumer: "behind", Chunk: "luffy-chunk000000000", Offset: 2},
+		{Consumer: "caught-up", Chunk: "luffy-chunk000000001", Offset: 4},
+		{Consumer: "acked", Chunk: "luffy-chunk000000000", Offset: 6},
+	}
+	for _, o := range offsets {
+		if err := client.SetConsumerOffset(ctx, "numbers", o); err != nil {
+			t.Fatalf("error committing offset %v", err)
+		}
+	}
+
+	lags, err := s.consumerLag(ctx, "numbers")
+	if err != nil {
+		t.Fatalf("error computing lag %v", err)
+	}
+	want := map[string][2]uint64{
+		"behind":    {8, 4},
+		"caught-up": {0, 0},
+		"acked":     {4, 2},
+	}
+	if len(lags) != len(want) {
+		t.Fatalf("got %d lags, want %d", len(lags), len(want))
+	}
+	for _, l := range lags {
+		if got := [2]uint64{l.Bytes, l.Messages}; got != want[l.Consumer] {
+			t.Errorf("lag of %s is %d bytes and %d messages, want %v", l.Consumer, l.Bytes, l.Messages, want[l.Consumer])
+		}
+		if l.Consumer == "caught-up" && l.Seconds != 0 {
+			t.Errorf("consumer without lag is %f seconds behind", l.Seconds)
+		}
+	}
+}
+
+func TestConsumerLagExpiry(t *testing.T) {
+	ctx := context.Background()
+	client := replication.NewClientWithBackend(replication.NewMemoryBackend(), "test")
+	s := NewServer(client, "luffy", t.TempDir(), "", replication.NewStorage(client, "luffy"), WithConsumerLagExpiry(time.Hour))
+	storage, err := s.getStorage("expiring")
+	if err != nil {
+		t.Fatalf("error getting storage %v", err)
+	}
+	if err := storage.Write(ctx, []byte("1\n2\n")); err != nil {
+		t.Fatalf("error writing %v", err)
+	}
+
+	offsets := []replication.ConsumerOffset{
+		{Consumer: "active", Chunk: "luffy-chunk000000000", Offset: 2, CommittedAt: time.Now()},
+		{Consumer: "gone", Chunk: "luffy-chunk000000000", Offset: 2, CommittedAt: time.Now().Add(-2 * time.Hour)},
+	}
+	for _, o := range offsets {
+		if err := client.SetConsumerOffset(ctx, "expiring", o); err != nil {
+			t.Fatalf("error committing offset %v", err)
+		}
+	}
+
+	lags, err := s.consumerLag(ctx, "expiring")
+	if err != nil {
+		t.Fatalf("error computing lag %v", err)
+	}
+	if len(lags) != 2 {
+		t.Errorf("got %d lags, want 2", len(lags))
+	}
+	if got := testutil.ToFloat64(consumerLagBytes.WithLabelValues("expiring", "active")); got != 2 {
+		t.Errorf("lag of the active consumer is %f bytes, want 2", got)
+	}
+	if consumerLagBytes.DeleteLabelValues("expiring", "gone") {
+		t.Errorf("lag of the consumer which has not committed for 2 hours is still published")
+	}
+}
+
+func TestCountMessagesRejectsChunkOutsideCategory(t *testing.T) {
+	client := replication.NewClientWithBackend(replication.NewMemoryBackend(), "test")
+	s := NewServer(client, "luffy", t.TempDir(), "", replication.NewStorage(client, "luffy"))
+	if _, err := s.getStorage("numbers"); err != nil {
+		t.Fatalf("error creating category %v", err)
+	}
+	var ctx fasthttp.RequestCtx
+	ctx.Request.SetRequestURI("/countMessages?category=numbers&from=0&chunk=../numbers")
+	s.handleRequest(&ctx)
+	if code := ctx.Response.StatusCode(); code != fasthttp.StatusBadRequest {
+		t.Errorf("got status %d want %d", code, fasthttp.StatusBadRequest)
+	}
+}
@@ -0,0 +1,71 @@
+package web
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/valyala/fasthttp"
+	"log/slog"
+	"time"
+)
+
+// RequestIDHeader carries the id of a request, it is generated when the client does not send one
+// and passed on to the peers the request is forwarded to
+const RequestIDHeader = "X-Request-Id"
+
+type requestIDKey struct{}
+
+func newRequestID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// startRequest assigns the request its id, reusing the one sent by the client or the forwarding peer
+func startRequest(ctx *fasthttp.RequestCtx) {
+	id := string(ctx.Request.Header.Peek(RequestIDHeader))
+	if id == "" {
+		id = newRequestID()
+		ctx.Request.Header.Set(RequestIDHeader, id)
+	}
+	ctx.SetUserValue(requestIDKey{}, id)
+}
+
+func requestID(ctx *fasthttp.RequestCtx) string {
+	id, _ := ctx.UserValue(requestIDKey{}).(string)
+	return id
+}
+
+// requestLogger returns the logger of the server with the fields identifying the request
+func (s *Server) requestLogger(ctx *fasthttp.RequestCtx) *slog.Logger {
+	logger := s.logger.With("request_id", requestID(ctx), "path", string(ctx.Path()))
+	if category := ctx.QueryArgs().Peek("category"); len(category) > 0 {
+		logger = logger.With("category", string(category))
+	}
+	if chunk := ctx.QueryArgs().Peek("chunk"); len(chunk) > 0 {
+		logger = logger.With("chunk", string(chunk))
+	}
+	return logger
+}
+
+// finishRequest logs the request and adds its id to the response, error responses mention it
+// in the body too unless they were produced by a peer which already did so
+func (s *Server) finishRequest(ctx *fasthttp.RequestCtx, start time.Time) {
+	status := ctx.Response.StatusCode()
+	fromPeer := len(ctx.Response.Header.Peek(RequestIDHeader)) > 0
+	ctx.Response.Header.Set(RequestIDHeader, requestID(ctx))
+
+	logger := s.requestLogger(ctx).With("method", string(ctx.Method()), "status", status, "duration", time.Since(start))
+	if status < fasthttp.StatusBadRequest {
+		logger.Debug("request served")
+		return
+	}
+	level := slog.LevelWarn
+	if status >= fasthttp.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	logger.Log(ctx, level, "request failed", "error", string(ctx.Response.Body()), "proxied", fromPeer)
+	if !fromPeer {
+		ctx.Response.AppendBodyString(fmt.Sprintf(" (request id %s)", requestID(ctx)))
+	}
+}
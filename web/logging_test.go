@@ -0,0 +1,52 @@
+package web
+
+import (
+	"bytes"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"github.com/valyala/fasthttp"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestErrorResponsesCarryRequestID(t *testing.T) {
+	var logs bytes.Buffer
+	client := replication.NewClientWithBackend(replication.NewMemoryBackend(), "test")
+	s := NewServer(client, "luffy", t.TempDir(), "", replication.NewStorage(client, "luffy"),
+		WithLogger(slog.New(slog.NewJSONHandler(&logs, nil))))
+
+	var ctx fasthttp.RequestCtx
+	ctx.Request.SetRequestURI("/read?category=numbers")
+	ctx.Request.Header.Set(RequestIDHeader, "abc123")
+	s.handleRequest(&ctx)
+
+	if code := ctx.Response.StatusCode(); code != fasthttp.StatusBadRequest {
+		t.Fatalf("got status %d want %d", code, fasthttp.StatusBadRequest)
+	}
+	if got := string(ctx.Response.Header.Peek(RequestIDHeader)); got != "abc123" {
+		t.Errorf("got request id header %q want %q", got, "abc123")
+	}
+	if body := string(ctx.Response.Body()); !strings.Contains(body, "abc123") {
+		t.Errorf("error response %q does not mention the request id", body)
+	}
+	if !strings.Contains(logs.String(), `"request_id":"abc123"`) || !strings.Contains(logs.String(), `"category":"numbers"`) {
+		t.Errorf("failed request not logged with its fields: %s", logs.String())
+	}
+}
+
+func TestRequestIDGenerated(t *testing.T) {
+	client := replication.NewClientWithBackend(replication.NewMemoryBackend(), "test")
+	s := NewServer(client, "luffy", t.TempDir(), "", replication.NewStorage(client, "luffy"))
+
+	var ctx fasthttp.RequestCtx
+	ctx.Request.SetRequestURI("/unknown")
+	s.handleRequest(&ctx)
+
+	id := string(ctx.Response.Header.Peek(RequestIDHeader))
+	if id == "" {
+		t.Fatalf("no request id assigned")
+	}
+	if body := string(ctx.Response.Body()); !strings.HasSuffix(body, "(request id "+id+")") {
+		t.Errorf("error response %q does not mention the request id %s", body, id)
+	}
+}
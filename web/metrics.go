@@ -0,0 +1,109 @@
+package web
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+	"time"
+)
+
+var (
+	requestBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "event_bus_bytes_total",
+		Help: "Bytes written, read and acked per category.",
+	}, []string{"op", "category"})
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "event_bus_request_duration_seconds",
+		Help:    "Latency of write, read and ack requests per category.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"op", "category"})
+
+	underReplicatedChunks = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "event_bus_under_replicated_chunks",
+		Help: "Chunks with fewer copies than the replication factor at the last anti-entropy check.",
+	})
+	divergentChunks = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "event_bus_divergent_chunks",
+		Help: "Replicas whose contents differ from the owner's copy at the last anti-entropy check.",
+	})
+	requeuedChunks = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "event_bus_requeued_chunks_total",
+		Help: "Copies queued again by the anti-entropy checks.",
+	})
+	throttledRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "event_bus_throttled_requests_total",
+		Help: "Requests rejected because a client or a category is over its quota.",
+	}, []string{"scope"})
+)
+
+var (
+	chunksDesc = prometheus.NewDesc("event_bus_chunks",
+		"Chunks of the category stored on the instance.", []string{"category"}, nil)
+	diskBytesDesc = prometheus.NewDesc("event_bus_disk_bytes",
+		"Bytes of the category stored on the instance.", []string{"category"}, nil)
+	activeChunkBytesDesc = prometheus.NewDesc("event_bus_active_chunk_bytes",
+		"Size of the chunk of the category currently written into.", []string{"category"}, nil)
+	openFilesDesc = prometheus.NewDesc("event_bus_open_files",
+		"File pointers kept open for the chunks of the category.", []string{"category"}, nil)
+)
+
+// storageCollector reports the chunks stored by the server when the metrics are scraped
+type storageCollector struct {
+	s *Server
+}
+
+func (c storageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- chunksDesc
+	ch <- diskBytesDesc
+	ch <- activeChunkBytesDesc
+	ch <- openFilesDesc
+}
+
+func (c storageCollector) Collect(ch chan<- prometheus.Metric) {
+	categories, err := c.s.localCategories()
+	if err != nil {
+		c.s.logger.Error("error listing categories for metrics", "error", err)
+		return
+	}
+	for _, category := range categories {
+		storage, err := c.s.getStorage(category)
+		if err != nil {
+			continue
+		}
+		stats, err := storage.Stats()
+		if err != nil {
+			c.s.logger.Error("error getting stats", "category", category, "error", err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(chunksDesc, prometheus.GaugeValue, float64(stats.Chunks), category)
+		ch <- prometheus.MustNewConstMetric(diskBytesDesc, prometheus.GaugeValue, float64(stats.Bytes), category)
+		ch <- prometheus.MustNewConstMetric(activeChunkBytesDesc, prometheus.GaugeValue, float64(stats.ActiveChunkSize), category)
+		ch <- prometheus.MustNewConstMetric(openFilesDesc, prometheus.GaugeValue, float64(stats.OpenFiles), category)
+	}
+}
+
+// newMetricsHandler serves the process wide metrics together with the ones of the server
+func (s *Server) newMetricsHandler() fasthttp.RequestHandler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(storageCollector{s: s})
+	gatherers := prometheus.Gatherers{prometheus.DefaultGatherer, registry}
+	return fasthttpadaptor.NewFastHTTPHandler(promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}))
+}
+
+// otherCategory labels the requests for categories which are not stored on the instance, the
+// client input is not used as a label value so that the number of series stays bounded
+const otherCategory = "other"
+
+// observeRequest records the latency of the request and, when it succeeded, the bytes it carried
+func (s *Server) observeRequest(ctx *fasthttp.RequestCtx, op string, start time.Time, bytes int) {
+	category := string(ctx.QueryArgs().Peek("category"))
+	if !s.hasLocalCategory(category) {
+		category = otherCategory
+	}
+	requestDuration.WithLabelValues(op, category).Observe(time.Since(start).Seconds())
+	if ctx.Response.StatusCode() == fasthttp.StatusOK {
+		requestBytes.WithLabelValues(op, category).Add(float64(bytes))
+	}
+}
@@ -0,0 +1,75 @@
+package web
+
+import (
+	"context"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/valyala/fasthttp"
+	"strings"
+	"testing"
+)
+
+func TestStorageCollector(t *testing.T) {
+	backend := replication.NewMemoryBackend()
+	client := replication.NewClientWithBackend(backend, "test")
+	s := NewServer(client, "luffy", t.TempDir(), "", replication.NewStorage(client, "luffy"))
+	storage, err := s.getStorage("numbers")
+	if err != nil {
+		t.Fatalf("error getting storage %v", err)
+	}
+	if err := storage.Write(context.Background(), []byte("1\n2\n")); err != nil {
+		t.Fatalf("error writing %v", err)
+	}
+
+	want := `
+# HELP event_bus_active_chunk_bytes Size of the chunk of the category currently written into.
+# TYPE event_bus_active_chunk_bytes gauge
+event_bus_active_chunk_bytes{category="numbers"} 4
+# HELP event_bus_chunks Chunks of the category stored on the instance.
+# TYPE event_bus_chunks gauge
+event_bus_chunks{category="numbers"} 1
+# HELP event_bus_disk_bytes Bytes of the category stored on the instance.
+# TYPE event_bus_disk_bytes gauge
+event_bus_disk_bytes{category="numbers"} 4
+# HELP event_bus_open_files File pointers kept open for the chunks of the category.
+# TYPE event_bus_open_files gauge
+event_bus_open_files{category="numbers"} 1
+`
+	if err := testutil.CollectAndCompare(storageCollector{s: s}, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRequestMetricsCategoryLabel(t *testing.T) {
+	client := replication.NewClientWithBackend(replication.NewMemoryBackend(), "test")
+	s := NewServer(client, "luffy", t.TempDir(), "", replication.NewStorage(client, "luffy"))
+	for _, uri := range []string{"/write?category=numbers", "/read?category=letters&chunk=luffy-chunk000000000&offset=0"} {
+		var req fasthttp.RequestCtx
+		req.Request.Header.SetMethod(fasthttp.MethodPost)
+		req.Request.SetRequestURI(uri)
+		req.Request.SetBodyString("1\n")
+		s.handleRequest(&req)
+	}
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("error gathering metrics %v", err)
+	}
+	categories := make(map[string]bool)
+	for _, f := range families {
+		if f.GetName() != "event_bus_request_duration_seconds" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "category" {
+					categories[l.GetValue()] = true
+				}
+			}
+		}
+	}
+	if !categories["numbers"] || !categories[otherCategory] || categories["letters"] {
+		t.Errorf("got category labels %v, want numbers and %s only for the unknown category", categories, otherCategory)
+	}
+}
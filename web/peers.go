@@ -0,0 +1,60 @@
+package web
+
+import (
+	"encoding/json"
+	"github.com/valyala/fasthttp"
+	"sort"
+)
+
+// PeerStatus is a peer registered in the cluster state together with the status it reports,
+// Error tells why the status could not be fetched
+type PeerStatus struct {
+	Name   string  `json:"name"`
+	Addr   string  `json:"addr"`
+	Zone   string  `json:"zone,omitempty"`
+	Status *Status `json:"status,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// peersHandler lists the peers of the cluster with the status of each of them
+func (s *Server) peersHandler(ctx *fasthttp.RequestCtx) {
+	peers, err := s.replicationClient.ListPeers(ctx)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	resp := make([]PeerStatus, 0, len(peers))
+	for _, p := range peers {
+		peer := PeerStatus{Name: p.Name, Addr: p.Addr, Zone: p.Zone}
+		var status Status
+		if p.Name == s.instanceName {
+			status, err = s.status()
+		} else {
+			status, err = s.peerStatus(p.Addr)
+		}
+		if err != nil {
+			peer.Error = err.Error()
+		} else {
+			peer.Status = &status
+		}
+		resp = append(resp, peer)
+	}
+	sort.Slice(resp, func(i, j int) bool { return resp[i].Name < resp[j].Name })
+	ctx.SetContentType("application/json")
+	if err := json.NewEncoder(ctx).Encode(resp); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+	}
+}
+
+func (s *Server) peerStatus(addr string) (Status, error) {
+	args := fasthttp.AcquireArgs()
+	defer fasthttp.ReleaseArgs(args)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	if err := s.peerRequest(addr, "/status", args, resp); err != nil {
+		return Status{}, err
+	}
+	var status Status
+	err := json.Unmarshal(resp.Body(), &status)
+	return status, err
+}
@@ -0,0 +1,312 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"github.com/valyala/fasthttp"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// quotaCacheTTL is how long the quotas read from the cluster state are trusted, changes
+	// take up to that long to apply
+	quotaCacheTTL = 5 * time.Second
+	// quotaLookupTimeout bounds the time a request waits for the quotas to be read, the cached
+	// ones apply when they cannot be read in time
+	quotaLookupTimeout = time.Second
+	// idleBucketTTL is how long the buckets of the clients and categories which send no request
+	// are kept before they are forgotten
+	idleBucketTTL = time.Minute
+)
+
+// WithQuotas sets the quotas of the clients and of the categories which have none in the cluster
+// state, the limits apply to the requests received by each instance
+func WithQuotas(client, category replication.Quota) Option {
+	return func(s *Server) {
+		s.quotas.defaults[replication.ClientQuota] = client
+		s.quotas.defaults[replication.CategoryQuota] = category
+	}
+}
+
+// tokenBucket refills at its rate and holds at most a second worth of tokens. A request is let
+// through as long as the bucket is not in debt, so that requests larger than a second worth of
+// tokens are delayed instead of rejected forever.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// wait refills the bucket and returns how long it takes to pay back its debt
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	if b.last.IsZero() {
+		b.tokens = b.rate
+	} else {
+		b.tokens = min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) take(n float64) {
+	if b.rate > 0 {
+		b.tokens -= n
+	}
+}
+
+// setRate changes the rate, the tokens left are kept
+func (b *tokenBucket) setRate(rate float64) {
+	if rate != b.rate {
+		b.rate = rate
+		b.tokens = min(b.tokens, rate)
+	}
+}
+
+type quotaKey struct {
+	scope replication.QuotaScope
+	name  string
+}
+
+// quotaState holds the buckets of a client or a category
+type quotaState struct {
+	quota    replication.Quota
+	lastUsed time.Time
+	requests tokenBucket
+	bytes    tokenBucket
+}
+
+// limiter enforces the quotas of the clients and of the categories with token buckets
+type limiter struct {
+	client   replication.Coordinator
+	defaults map[replication.QuotaScope]replication.Quota
+
+	mu         sync.Mutex
+	overrides  map[replication.QuotaScope]map[string]replication.Quota
+	expires    time.Time
+	refreshing bool
+	states     map[quotaKey]*quotaState
+	nextPrune  time.Time
+}
+
+func newLimiter(client replication.Coordinator) *limiter {
+	return &limiter{
+		client:   client,
+		defaults: make(map[replication.QuotaScope]replication.Quota),
+		states:   make(map[quotaKey]*quotaState),
+	}
+}
+
+// refresh reads the quotas of every client and category once the cached ones expire, outside of
+// the lock. A single request reads them while the others apply the cached ones, and the cluster
+// state is read again after quotaCacheTTL even when it holds no quota. The cached quotas, or the
+// defaults, are kept when they cannot be read.
+func (l *limiter) refresh(now time.Time) error {
+	l.mu.Lock()
+	if l.refreshing || now.Before(l.expires) {
+		l.mu.Unlock()
+		return nil
+	}
+	l.refreshing = true
+	l.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), quotaLookupTimeout)
+	defer cancel()
+	overrides := make(map[replication.QuotaScope]map[string]replication.Quota, 2)
+	var err error
+	for _, scope := range []replication.QuotaScope{replication.ClientQuota, replication.CategoryQuota} {
+		if overrides[scope], err = l.client.ListQuotas(ctx, scope); err != nil {
+			break
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refreshing = false
+	l.expires = now.Add(quotaCacheTTL)
+	if err != nil {
+		return err
+	}
+	l.overrides = overrides
+	for key, state := range l.states {
+		state.setQuota(l.quota(key))
+	}
+	return nil
+}
+
+// quota returns the quota of the key from the cluster state or its default, the lock must be held
+func (l *limiter) quota(key quotaKey) replication.Quota {
+	if quota, ok := l.overrides[key.scope][key.name]; ok {
+		return quota
+	}
+	return l.defaults[key.scope]
+}
+
+func (s *quotaState) setQuota(quota replication.Quota) {
+	s.quota = quota
+	s.requests.setRate(quota.RequestsPerSecond)
+	s.bytes.setRate(quota.BytesPerSecond)
+}
+
+// allow takes a request of the given size from the buckets of every key, or none of them when
+// one of the buckets is in debt. It returns how long to wait and the key which is over its quota.
+func (l *limiter) allow(keys []quotaKey, size int, now time.Time) (time.Duration, quotaKey, error) {
+	err := l.refresh(now)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(now)
+	var wait time.Duration
+	var over quotaKey
+	for _, key := range keys {
+		state, ok := l.states[key]
+		if !ok {
+			state = &quotaState{}
+			state.setQuota(l.quota(key))
+			l.states[key] = state
+		}
+		state.lastUsed = now
+		if w := max(state.requests.wait(now), state.bytes.wait(now)); w > wait {
+			wait, over = w, key
+		}
+	}
+	if wait > 0 {
+		return wait, over, err
+	}
+	for _, key := range keys {
+		l.states[key].requests.take(1)
+		l.states[key].bytes.take(float64(size))
+	}
+	return 0, quotaKey{}, err
+}
+
+// prune forgets the buckets which have not been used for a while and are not in debt
+func (l *limiter) prune(now time.Time) {
+	if now.Before(l.nextPrune) {
+		return
+	}
+	l.nextPrune = now.Add(idleBucketTTL)
+	for key, state := range l.states {
+		if now.Sub(state.lastUsed) > idleBucketTTL && state.requests.wait(now) == 0 && state.bytes.wait(now) == 0 {
+			delete(l.states, key)
+		}
+	}
+}
+
+// admit checks the quotas of the client and of the category of the request and responds with 429
+// when one of them is exceeded. The requests of the peers authenticated with the cluster token are
+// not limited, the quotas have been checked by the instance which received them first. Without
+// authentication nothing tells a peer from a client, every request is charged.
+func (s *Server) admit(ctx *fasthttp.RequestCtx) bool {
+	path := string(ctx.Path())
+	if publicPaths[path] {
+		return true
+	}
+	principal := requestPrincipal(ctx)
+	if principal == clusterPrincipal {
+		return true
+	}
+	client := principal
+	if client == "" {
+		client = ctx.RemoteIP().String()
+	}
+	keys := []quotaKey{{scope: replication.ClientQuota, name: client}}
+	if category := string(ctx.QueryArgs().Peek("category")); category != "" {
+		keys = append(keys, quotaKey{scope: replication.CategoryQuota, name: category})
+	}
+	size := 0
+	if path == "/write" {
+		size = len(ctx.PostBody())
+	}
+
+	wait, over, err := s.quotas.allow(keys, size, time.Now())
+	if err != nil {
+		s.requestLogger(ctx).Warn("error getting quotas, applying the cached ones", "error", err)
+	}
+	if wait <= 0 {
+		return true
+	}
+	throttledRequests.WithLabelValues(string(over.scope)).Inc()
+	subject := "client"
+	if over.scope == replication.CategoryQuota {
+		subject = "category"
+	}
+	ctx.Error(fmt.Sprintf("%s %q is over its quota, retry in %s", subject, over.name, wait.Round(time.Millisecond)), fasthttp.StatusTooManyRequests)
+	// set after the error which resets the headers
+	ctx.Response.Header.Set(fasthttp.HeaderRetryAfter, retryAfterSeconds(wait))
+	return false
+}
+
+// retryAfterSeconds formats a delay for the Retry-After header, which is in whole seconds
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(max(1, int(math.Ceil(d.Seconds()))))
+}
+
+// Quotas is the response of /admin/quotas
+type Quotas struct {
+	Defaults   map[replication.QuotaScope]replication.Quota `json:"defaults"`
+	Clients    map[string]replication.Quota                 `json:"clients"`
+	Categories map[string]replication.Quota                 `json:"categories"`
+}
+
+// quotasHandler lists the quotas, sets the quota of a client or a category or restores its default,
+// the client or the category is given by the client or the category parameter
+func (s *Server) quotasHandler(ctx *fasthttp.RequestCtx) {
+	scope, name := replication.ClientQuota, string(ctx.QueryArgs().Peek("client"))
+	if category := string(ctx.QueryArgs().Peek("category")); category != "" {
+		scope, name = replication.CategoryQuota, category
+	}
+	switch string(ctx.Method()) {
+	case fasthttp.MethodGet:
+		resp := Quotas{Defaults: s.quotas.defaults}
+		var err error
+		if resp.Clients, err = s.replicationClient.ListQuotas(ctx, replication.ClientQuota); err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+			return
+		}
+		if resp.Categories, err = s.replicationClient.ListQuotas(ctx, replication.CategoryQuota); err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+			return
+		}
+		if err := json.NewEncoder(ctx).Encode(resp); err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		}
+	case fasthttp.MethodPut:
+		if name == "" {
+			ctx.Error("either client or category must be set", fasthttp.StatusBadRequest)
+			return
+		}
+		var quota replication.Quota
+		if err := json.Unmarshal(ctx.PostBody(), &quota); err != nil {
+			ctx.Error(fmt.Sprintf("invalid quota %v", err), fasthttp.StatusBadRequest)
+			return
+		}
+		if quota.BytesPerSecond < 0 || quota.RequestsPerSecond < 0 {
+			ctx.Error("rates cannot be negative", fasthttp.StatusBadRequest)
+			return
+		}
+		if err := s.replicationClient.SetQuota(ctx, scope, name, quota); err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		}
+	case fasthttp.MethodDelete:
+		if name == "" {
+			ctx.Error("either client or category must be set", fasthttp.StatusBadRequest)
+			return
+		}
+		if err := s.replicationClient.DeleteQuota(ctx, scope, name); err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		}
+	default:
+		ctx.Error("method not allowed", fasthttp.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,139 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"github.com/valyala/fasthttp"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingQuotas counts the reads of the quotas and fails them when err is set
+type countingQuotas struct {
+	replication.Coordinator
+	lists atomic.Int32
+	err   error
+}
+
+func (c *countingQuotas) ListQuotas(ctx context.Context, scope replication.QuotaScope) (map[string]replication.Quota, error) {
+	c.lists.Add(1)
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.Coordinator.ListQuotas(ctx, scope)
+}
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	client := replication.NewClientWithBackend(replication.NewMemoryBackend(), "test")
+	l := newLimiter(client)
+	l.defaults[replication.ClientQuota] = replication.Quota{BytesPerSecond: 100}
+	if err := client.SetQuota(ctx, replication.CategoryQuota, "orders", replication.Quota{RequestsPerSecond: 2}); err != nil {
+		t.Fatalf("error setting quota %v", err)
+	}
+	alice := quotaKey{scope: replication.ClientQuota, name: "alice"}
+	orders := quotaKey{scope: replication.CategoryQuota, name: "orders"}
+	now := time.Now()
+
+	// a write larger than the burst goes through and puts the bucket in debt
+	if wait, _, err := l.allow([]quotaKey{alice}, 250, now); err != nil || wait != 0 {
+		t.Fatalf("first write: got wait %s error %v", wait, err)
+	}
+	if wait, over, _ := l.allow([]quotaKey{alice}, 1, now.Add(time.Second)); over != alice || wait != 500*time.Millisecond {
+		t.Errorf("write in debt: got wait %s over %v", wait, over)
+	}
+	if wait, _, _ := l.allow([]quotaKey{alice}, 1, now.Add(1500*time.Millisecond)); wait != 0 {
+		t.Errorf("write after the debt is paid back: got wait %s", wait)
+	}
+
+	bob := quotaKey{scope: replication.ClientQuota, name: "bob"}
+	for i := 0; i < 2; i++ {
+		if wait, _, _ := l.allow([]quotaKey{bob, orders}, 0, now); wait != 0 {
+			t.Fatalf("request %d: got wait %s", i, wait)
+		}
+	}
+	if wait, over, _ := l.allow([]quotaKey{bob, orders}, 0, now); wait != 0 {
+		// the bucket holds a second worth of tokens and is not in debt yet
+		t.Fatalf("third request: got wait %s over %v", wait, over)
+	}
+	if wait, over, _ := l.allow([]quotaKey{bob, orders}, 0, now); over != orders || wait != 500*time.Millisecond {
+		t.Errorf("request over the category quota: got wait %s over %v", wait, over)
+	}
+}
+
+func TestTooManyRequests(t *testing.T) {
+	client := replication.NewClientWithBackend(replication.NewMemoryBackend(), "test")
+	s := NewServer(client, "luffy", t.TempDir(), "", replication.NewStorage(client, "luffy"),
+		WithQuotas(replication.Quota{RequestsPerSecond: 1}, replication.Quota{}))
+
+	write := func() *fasthttp.RequestCtx {
+		var req fasthttp.RequestCtx
+		req.Request.Header.SetMethod(fasthttp.MethodPost)
+		req.Request.SetRequestURI("/write?category=numbers")
+		req.Request.SetBodyString("1\n")
+		s.handleRequest(&req)
+		return &req
+	}
+	for i := 0; i < 2; i++ {
+		if code := write().Response.StatusCode(); code != fasthttp.StatusOK {
+			t.Fatalf("write %d: got status %d", i, code)
+		}
+	}
+	req := write()
+	if code := req.Response.StatusCode(); code != fasthttp.StatusTooManyRequests {
+		t.Fatalf("got status %d want %d", code, fasthttp.StatusTooManyRequests)
+	}
+	if got := string(req.Response.Header.Peek(fasthttp.HeaderRetryAfter)); got != "1" {
+		t.Errorf("got Retry-After %q", got)
+	}
+
+	// anyone can claim a request was forwarded by a peer
+	var forwarded fasthttp.RequestCtx
+	forwarded.Request.Header.SetMethod(fasthttp.MethodPost)
+	forwarded.Request.SetRequestURI("/write?category=numbers")
+	forwarded.Request.Header.Set(replication.ForwardedHeader, "1")
+	forwarded.Request.SetBodyString("1\n")
+	s.handleRequest(&forwarded)
+	if code := forwarded.Response.StatusCode(); code != fasthttp.StatusTooManyRequests {
+		t.Errorf("forwarded request: got status %d want %d", code, fasthttp.StatusTooManyRequests)
+	}
+
+	var health fasthttp.RequestCtx
+	health.Request.SetRequestURI("/healthz")
+	s.handleRequest(&health)
+	if code := health.Response.StatusCode(); code != fasthttp.StatusOK {
+		t.Errorf("health check: got status %d", code)
+	}
+}
+
+func TestLimiterCachesQuotas(t *testing.T) {
+	client := &countingQuotas{Coordinator: replication.NewClientWithBackend(replication.NewMemoryBackend(), "test")}
+	l := newLimiter(client)
+	now := time.Now()
+
+	// no quota is set, the cluster state is read once for every client
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if _, _, err := l.allow([]quotaKey{{scope: replication.ClientQuota, name: name}}, 1, now); err != nil {
+			t.Fatalf("allow(%s) = %v", name, err)
+		}
+	}
+	if got := client.lists.Load(); got != 2 {
+		t.Errorf("got %d reads of the quotas want 2", got)
+	}
+
+	// the quotas cannot be read once they expire, the requests are let through
+	client.err = errors.New("unavailable")
+	alice := quotaKey{scope: replication.ClientQuota, name: "alice"}
+	later := now.Add(quotaCacheTTL)
+	if wait, _, err := l.allow([]quotaKey{alice}, 1, later); err == nil || wait != 0 {
+		t.Errorf("got wait %s error %v, want no wait and an error", wait, err)
+	}
+	if wait, _, err := l.allow([]quotaKey{alice}, 1, later); err != nil || wait != 0 {
+		t.Errorf("got wait %s error %v once the failure is cached", wait, err)
+	}
+	if got := client.lists.Load(); got != 3 {
+		t.Errorf("got %d reads of the quotas want 3", got)
+	}
+}
@@ -0,0 +1,86 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"github.com/Vignesh-Rajarajan/event-bus/manager"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"time"
+)
+
+// RunRetention periodically applies the settings of the local categories, which may have been
+// changed through another instance, and removes the chunks older than their retention
+func (s *Server) RunRetention(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		categories, err := s.localCategories()
+		if err != nil {
+			s.logger.Error("error listing categories", "error", err)
+			continue
+		}
+		for _, category := range categories {
+			s.applyRetention(ctx, category)
+		}
+	}
+}
+
+func (s *Server) applyRetention(ctx context.Context, category string) {
+	cfg, _, err := s.replicationClient.GetCategory(ctx, category)
+	if err != nil {
+		s.logger.Error("error getting category settings", "category", category, "error", err)
+		return
+	}
+	storage, err := s.getStorage(category)
+	if err != nil {
+		s.logger.Error("error getting storage", "category", category, "error", err)
+		return
+	}
+	storage.SetSettings(storageSettings(cfg))
+	if cfg.RetentionSeconds <= 0 {
+		return
+	}
+	expired, err := storage.Expire(time.Now().Add(-time.Duration(cfg.RetentionSeconds) * time.Second))
+	for _, ch := range expired {
+		s.logger.Info("removed chunk past its retention", "category", category, "chunk", ch.Name, "size", ch.Size)
+		if err := s.forgetChunk(ctx, category, ch.Name); err != nil {
+			s.logger.Error("error removing cluster state of expired chunk", "category", category, "chunk", ch.Name, "error", err)
+		}
+	}
+	if err != nil {
+		s.logger.Error("error removing chunks past their retention", "category", category, "error", err)
+	}
+}
+
+// forgetChunk removes the cluster state of a chunk removed from the local storage: the state of the
+// copy held by the current instance and, for the chunks it wrote, the copies still queued on the peers
+func (s *Server) forgetChunk(ctx context.Context, category, fileName string) error {
+	if err := s.replicationClient.DeleteReplicaState(ctx, category, replication.ReplicaState{Instance: s.instanceName, FileName: fileName}); err != nil {
+		return fmt.Errorf("error deleting replica state %v", err)
+	}
+	if owner, ok := manager.ChunkOwner(fileName); !ok || owner != s.instanceName {
+		return nil
+	}
+	peers, err := s.replicationClient.ListPeers(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing peers %v", err)
+	}
+	for _, p := range peers {
+		if p.Name == s.instanceName {
+			continue
+		}
+		if err := s.replicationClient.DeleteChunkFromReplicationQueue(ctx, p.Name, replication.Chunk{
+			Category: category,
+			FileName: fileName,
+			OwnedBy:  s.instanceName,
+		}); err != nil {
+			return fmt.Errorf("error removing chunk from the replication queue of %s %v", p.Name, err)
+		}
+	}
+	return nil
+}
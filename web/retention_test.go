@@ -0,0 +1,55 @@
+package web
+
+import (
+	"context"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"testing"
+)
+
+func TestForgetChunk(t *testing.T) {
+	ctx := context.Background()
+	client := replication.NewClientWithBackend(replication.NewMemoryBackend(), "test")
+	s := NewServer(client, "luffy", t.TempDir(), "", replication.NewStorage(client, "luffy"))
+	for _, p := range []replication.Peer{{Name: "luffy", Addr: "luffy:8080"}, {Name: "zoro", Addr: "zoro:8080"}} {
+		if err := client.RegisterPeer(ctx, p); err != nil {
+			t.Fatalf("error registering peer %v", err)
+		}
+	}
+	for _, ch := range []replication.Chunk{
+		{Category: "numbers", FileName: "luffy-chunk000000000", OwnedBy: "luffy"},
+		{Category: "numbers", FileName: "luffy-chunk000000001", OwnedBy: "luffy"},
+	} {
+		if err := client.AddChunkToReplicationQueue(ctx, "zoro", ch); err != nil {
+			t.Fatalf("error queueing chunk %v", err)
+		}
+	}
+	for _, state := range []replication.ReplicaState{
+		{Instance: "luffy", FileName: "zoro-chunk000000000", Size: 2, Complete: true},
+		{Instance: "zoro", FileName: "zoro-chunk000000000", Size: 2, Complete: true},
+	} {
+		if err := client.SetReplicaState(ctx, "numbers", state); err != nil {
+			t.Fatalf("error setting replica state %v", err)
+		}
+	}
+
+	for _, name := range []string{"luffy-chunk000000000", "zoro-chunk000000000"} {
+		if err := s.forgetChunk(ctx, "numbers", name); err != nil {
+			t.Fatalf("forgetChunk(%s) = %v", name, err)
+		}
+	}
+	queued, err := client.Get(ctx, "replication/zoro/", replication.WithPrefix())
+	if err != nil {
+		t.Fatalf("error listing replication queue %v", err)
+	}
+	if len(queued) != 1 {
+		t.Errorf("got queued chunks %+v, want only luffy-chunk000000001", queued)
+	}
+	// the copy held by zoro is forgotten by zoro once it removes it
+	replicas, err := client.ListReplicas(ctx, "numbers")
+	if err != nil {
+		t.Fatalf("error listing replicas %v", err)
+	}
+	if len(replicas) != 1 || replicas[0].Instance != "zoro" {
+		t.Errorf("got replicas %+v, want only the copy of zoro", replicas)
+	}
+}
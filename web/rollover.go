@@ -0,0 +1,41 @@
+package web
+
+import (
+	"context"
+	"github.com/Vignesh-Rajarajan/event-bus/manager"
+	"time"
+)
+
+// RunRollover periodically completes the chunks which have been written into for longer than
+// the maximum age of their category, writes only roll the chunks over when they come
+func (s *Server) RunRollover(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.rollOver(now)
+		}
+	}
+}
+
+func (s *Server) rollOver(now time.Time) {
+	s.m.Lock()
+	storages := make(map[string]*manager.EventBusOnDisk, len(s.storages))
+	for category, storage := range s.storages {
+		storages[category] = storage
+	}
+	s.m.Unlock()
+	for category, storage := range storages {
+		chunk, err := storage.RollOver(now)
+		if err != nil {
+			s.logger.Error("error rolling chunk over", "category", category, "error", err)
+			continue
+		}
+		if chunk != "" {
+			s.logger.Info("completed chunk past its maximum age", "category", category, "chunk", chunk)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package web
+
+import (
+	"context"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"testing"
+	"time"
+)
+
+func TestRollOverQuietCategory(t *testing.T) {
+	client := replication.NewClientWithBackend(replication.NewMemoryBackend(), "test")
+	s := NewServer(client, "luffy", t.TempDir(), "", replication.NewStorage(client, "luffy"))
+	if err := client.SetCategory(context.Background(), "numbers", replication.CategoryConfig{MaxChunkAgeSeconds: 60}); err != nil {
+		t.Fatalf("error setting category %v", err)
+	}
+	storage, err := s.getStorage("numbers")
+	if err != nil {
+		t.Fatalf("error getting storage %v", err)
+	}
+	if err := storage.Write(context.Background(), []byte("1\n")); err != nil {
+		t.Fatalf("error writing %v", err)
+	}
+
+	s.rollOver(time.Now())
+	if chunks, err := storage.ListChunks(); err != nil || len(chunks) != 1 || chunks[0].Complete {
+		t.Fatalf("chunk completed before its maximum age %+v, err %v", chunks, err)
+	}
+	s.rollOver(time.Now().Add(time.Minute))
+	if chunks, err := storage.ListChunks(); err != nil || len(chunks) != 1 || !chunks[0].Complete {
+		t.Errorf("chunk not completed after its maximum age %+v, err %v", chunks, err)
+	}
+}
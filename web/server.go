@@ -1,43 +1,176 @@
 package web
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/Vignesh-Rajarajan/event-bus/chunk"
+	"github.com/Vignesh-Rajarajan/event-bus/diskspace"
 	"github.com/Vignesh-Rajarajan/event-bus/manager"
 	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"github.com/Vignesh-Rajarajan/event-bus/tracing"
 	"github.com/valyala/fasthttp"
-	"log"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+	"log/slog"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+const forwardTimeout = 10 * time.Second
+
 type Server struct {
 	instanceName       string
 	dirname            string
 	listenAddr         string
 	replicationStorage *replication.Storage
-	replicationClient  *replication.Client
+	replicationClient  replication.Coordinator
 	m                  sync.Mutex
-	storages           map[string]manager.EventManager
-	logger             *log.Logger
+	storages           map[string]*manager.EventBusOnDisk
+	logger             *slog.Logger
+	leadership         replication.Leadership
+	httpCli            *fasthttp.Client
+	reportMu           sync.Mutex
+	report             ReplicationReport
+	draining           atomic.Bool
+	raftHandler        fasthttp.RequestHandler
+	metricsHandler     fasthttp.RequestHandler
+	cluster            string
+	startedAt          time.Time
+	srv                *fasthttp.Server
+	tlsConfig          *tls.Config
+	peerScheme         string
+	auth               *authenticator
+	quotas             *limiter
+	disk               diskGuard
+	autoCreate         bool
+	drainRunning       atomic.Bool
+	drainTimeout       time.Duration
+	lagExpiry          time.Duration
+
+	// writeMu is held for reading by the writes in flight, the draining flag is set with it held
+	// for writing so that no write is accepted once the chunks are sealed
+	writeMu sync.RWMutex
+}
+
+// Option configures optional behaviour of the server
+type Option func(*Server)
+
+// WithLeadership makes the server accept writes only for the categories it leads, writes for
+// other categories are proxied to their leader
+func WithLeadership(leadership replication.Leadership) Option {
+	return func(s *Server) {
+		s.leadership = leadership
+	}
+}
+
+// WithLogger replaces the default logger of the server
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithCluster sets the name of the cluster reported by /status
+func WithCluster(name string) Option {
+	return func(s *Server) {
+		s.cluster = name
+	}
+}
+
+// WithTLS serves the API over TLS with the server configuration and connects to the peers,
+// which must all use TLS too, with the client configuration
+func WithTLS(server, client *tls.Config) Option {
+	return func(s *Server) {
+		s.tlsConfig = server
+		s.httpCli.TLSConfig = client
+		s.peerScheme = "https"
+	}
+}
+
+// WithRaft serves the changes forwarded to the embedded raft leader by the other members
+func WithRaft(backend *replication.RaftBackend) Option {
+	return func(s *Server) {
+		s.raftHandler = fasthttpadaptor.NewFastHTTPHandler(backend)
+	}
 }
 
-func NewServer(replicationClient *replication.Client, instanceName, dirname, listenerAddr string, replicationStorage *replication.Storage) *Server {
-	return &Server{
+func NewServer(replicationClient replication.Coordinator, instanceName, dirname, listenerAddr string, replicationStorage *replication.Storage, opts ...Option) *Server {
+	s := &Server{
 		dirname:            dirname,
 		instanceName:       instanceName,
 		listenAddr:         listenerAddr,
 		replicationClient:  replicationClient,
-		logger:             log.Default(),
-		storages:           make(map[string]manager.EventManager),
+		logger:             slog.Default().With("instance", instanceName),
+		storages:           make(map[string]*manager.EventBusOnDisk),
 		replicationStorage: replicationStorage,
+		httpCli:            &fasthttp.Client{},
+		startedAt:          time.Now(),
+		peerScheme:         "http",
+		disk:               diskGuard{usage: diskspace.Get, interval: defaultDiskCheckInterval},
+		autoCreate:         true,
+		drainTimeout:       defaultDrainTimeout,
+		lagExpiry:          defaultConsumerLagExpiry,
+	}
+	s.quotas = newLimiter(replicationClient)
+	for _, opt := range opts {
+		opt(s)
 	}
+	s.metricsHandler = s.newMetricsHandler()
+	s.srv = &fasthttp.Server{Handler: s.handleRequest}
+	return s
 }
 
+// Start serves the requests until the server is shut down
 func (s *Server) Start() error {
-	return fasthttp.ListenAndServe(s.listenAddr, s.handleRequest)
+	if s.tlsConfig == nil {
+		return s.srv.ListenAndServe(s.listenAddr)
+	}
+	ln, err := net.Listen("tcp", s.listenAddr)
+	if err != nil {
+		return err
+	}
+	return s.srv.Serve(tls.NewListener(ln, s.tlsConfig))
+}
+
+// Shutdown stops accepting connections and waits for the requests in flight, then flushes and seals
+// the chunks, gives up the leaderships and removes the instance from the peers. The instance
+// registers again when it starts next time. Every step runs even when the previous ones failed,
+// the errors are joined.
+func (s *Server) Shutdown(ctx context.Context) error {
+	var errs []error
+	if err := s.srv.ShutdownWithContext(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("error waiting for requests in flight %w", err))
+	}
+	s.m.Lock()
+	for category, storage := range s.storages {
+		if err := storage.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("error closing storage of %s %w", category, err))
+		}
+	}
+	s.m.Unlock()
+	if s.leadership != nil {
+		if err := s.leadership.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("error giving up leadership %w", err))
+		}
+	}
+	// the instance is deregistered even once the time given to the requests in flight is over
+	deleteCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), forwardTimeout)
+	defer cancel()
+	if err := s.replicationClient.DeletePeer(deleteCtx, s.instanceName); err != nil {
+		errs = append(errs, fmt.Errorf("error deregistering peer %w", err))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	s.logger.Info("server shut down")
+	return nil
 }
 
 func isValidCategory(category string) bool {
@@ -48,27 +181,52 @@ func isValidCategory(category string) bool {
 	if cleanPath != category {
 		return false
 	}
-	if strings.ContainsAny(cleanPath, `/\.`) {
+	// dots may separate the parts of a name such as orders.eu, the names starting with one are
+	// reserved for the state directories such as .raft
+	if strings.ContainsAny(cleanPath, `/\`) || strings.HasPrefix(cleanPath, ".") {
 		return false
 	}
 	return true
 }
 
-func (s *Server) getStorage(category string) (manager.EventManager, error) {
-	s.m.Lock()
-	defer s.m.Unlock()
+// getStorage returns the storage of the category, opening it with the settings of the category
+// and creating it unless automatic creation is disabled
+func (s *Server) getStorage(category string) (*manager.EventBusOnDisk, error) {
 	if !isValidCategory(category) {
 		return nil, fmt.Errorf("invalid category %s", category)
 	}
+	s.m.Lock()
 	storage, ok := s.storages[category]
+	s.m.Unlock()
 	if ok {
 		return storage, nil
 	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), forwardTimeout)
+	defer cancel()
+	cfg, found, err := s.replicationClient.GetCategory(ctx, category)
+	if err != nil {
+		// the settings are applied again by the retention
+		s.logger.Warn("error getting category settings, using the defaults", "category", category, "error", err)
+	}
 	dir := filepath.Join(s.dirname, category)
+	if _, statErr := os.Stat(dir); statErr != nil && !found && !s.autoCreate {
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w %s, it must be created first", errUnknownCategory, category)
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	if storage, ok := s.storages[category]; ok {
+		return storage, nil
+	}
 	if err := os.MkdirAll(dir, 0777); err != nil {
 		return nil, fmt.Errorf("error creating directory %s: %v", dir, err)
 	}
-	storage, err := manager.NewEventBusOnDisk(dir, category, s.instanceName, s.replicationStorage)
+	storage, err = manager.NewEventBusOnDisk(dir, category, s.instanceName, s.replicationStorage,
+		manager.WithLogger(s.logger), manager.WithSettings(storageSettings(cfg)))
 	if err != nil {
 		return nil, fmt.Errorf("error creating storage: %v", err)
 	}
@@ -76,18 +234,102 @@ func (s *Server) getStorage(category string) (manager.EventManager, error) {
 	return storage, nil
 }
 
+// Stat returns the size of the chunk stored locally, it is used by the replicator
+func (s *Server) Stat(category, fileName string) (uint64, bool, error) {
+	if !s.hasLocalCategory(category) {
+		// the category is created by the first write of the copy
+		return 0, false, nil
+	}
+	storage, err := s.getStorage(category)
+	if err != nil {
+		return 0, false, err
+	}
+	return storage.Stat(fileName)
+}
+
+// WriteDirect appends the replicated contents to the chunk owned by another instance
+func (s *Server) WriteDirect(category, fileName string, contents []byte) error {
+	storage, err := s.getStorage(category)
+	if err != nil {
+		return err
+	}
+	return storage.WriteDirect(fileName, contents)
+}
+
+// CompleteCopy records when the copy of the chunk owned by another instance was completed
+func (s *Server) CompleteCopy(category, fileName string) error {
+	if !s.hasLocalCategory(category) {
+		// nothing was copied from an empty chunk
+		return nil
+	}
+	storage, err := s.getStorage(category)
+	if err != nil {
+		return err
+	}
+	return storage.CompleteCopy(fileName)
+}
+
 func (s *Server) handleRequest(ctx *fasthttp.RequestCtx) {
+	start := time.Now()
+	startRequest(ctx)
+	span := s.startSpan(ctx)
+	defer endSpan(ctx, span)
+	defer s.finishRequest(ctx, start)
+	if !s.authorize(ctx) || !s.admit(ctx) {
+		return
+	}
 	switch string(ctx.Path()) {
 	case "/write":
 		s.handleWrite(ctx)
+		s.observeRequest(ctx, "write", start, len(ctx.PostBody()))
 	case "/read":
 		s.handleRead(ctx)
+		s.observeRequest(ctx, "read", start, len(ctx.Response.Body()))
 	case "/ack":
 		s.ackHandler(ctx)
+		size, _ := ctx.QueryArgs().GetUint("size")
+		s.observeRequest(ctx, "ack", start, size)
 	case "/listChunks":
 		s.listChunksHandler(ctx)
+	case "/checksum":
+		s.checksumHandler(ctx)
+	case "/commit":
+		s.commitHandler(ctx)
+	case "/lag":
+		s.lagHandler(ctx)
+	case "/countMessages":
+		s.countMessagesHandler(ctx)
+	case "/replicationStatus":
+		s.replicationStatusHandler(ctx)
+	case "/admin/drain":
+		s.drainHandler(ctx)
+	case "/admin/tokens":
+		s.tokensHandler(ctx)
+	case "/admin/acls":
+		s.aclsHandler(ctx)
+	case "/admin/quotas":
+		s.quotasHandler(ctx)
+	case "/categories":
+		s.categoryNamesHandler(ctx)
+	case "/admin/categories":
+		s.categoriesHandler(ctx)
+	case "/healthz":
+		s.healthzHandler(ctx)
+	case "/readyz":
+		s.readyzHandler(ctx)
+	case "/status":
+		s.statusHandler(ctx)
+	case "/peers":
+		s.peersHandler(ctx)
+	case "/metrics":
+		s.metricsHandler(ctx)
+	case replication.RaftApplyPath:
+		if s.raftHandler == nil {
+			ctx.Error("raft coordination is not enabled", fasthttp.StatusNotFound)
+			return
+		}
+		s.raftHandler(ctx)
 	default:
-		s.logger.Println(fmt.Sprintf("path %s doesn't exist", ctx.Path()))
 		ctx.Error("Unsupported path", fasthttp.StatusNotFound)
 	}
 }
@@ -98,12 +340,48 @@ func (s *Server) handleWrite(ctx *fasthttp.RequestCtx) {
 		ctx.Error("category cannot be empty", fasthttp.StatusBadRequest)
 		return
 	}
+	if s.draining.Load() {
+		ctx.Error(fmt.Sprintf("instance %s is draining and does not accept writes", s.instanceName), fasthttp.StatusServiceUnavailable)
+		return
+	}
+	if s.leadership != nil {
+		if !isValidCategory(category) {
+			ctx.Error(fmt.Sprintf("invalid category %s", category), fasthttp.StatusBadRequest)
+			return
+		}
+		leader, err := s.categoryLeader(ctx, category)
+		if err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusServiceUnavailable)
+			return
+		}
+		if leader != s.instanceName {
+			s.forwardToPeer(ctx, leader)
+			return
+		}
+	}
+	if retry, err := s.disk.writeError(s.dirname); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusServiceUnavailable)
+		ctx.Response.Header.Set(fasthttp.HeaderRetryAfter, retryAfterSeconds(retry))
+		return
+	}
 	storage, err := s.getStorage(category)
-	if err != nil {
+	if errors.Is(err, errUnknownCategory) {
+		ctx.Error(err.Error(), fasthttp.StatusNotFound)
+		return
+	} else if err != nil {
 		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
 		return
 	}
-	if err := storage.Write(ctx, ctx.PostBody()); err != nil {
+	s.writeMu.RLock()
+	defer s.writeMu.RUnlock()
+	if s.draining.Load() {
+		// the drain started while the request was on its way
+		ctx.Error(fmt.Sprintf("instance %s is draining and does not accept writes", s.instanceName), fasthttp.StatusServiceUnavailable)
+		return
+	}
+	if err := storage.Write(requestContext(ctx), ctx.PostBody()); errors.Is(err, manager.ErrDeleted) {
+		ctx.Error(fmt.Sprintf("category %s has been deleted", category), fasthttp.StatusNotFound)
+	} else if err != nil {
 		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
 	}
 }
@@ -114,18 +392,29 @@ func (s *Server) handleRead(ctx *fasthttp.RequestCtx) {
 		ctx.Error("category cannot be empty", fasthttp.StatusBadRequest)
 		return
 	}
-	storage, err := s.getStorage(category)
-	if err != nil {
-		fmt.Println(fmt.Sprintf("error getting storage %v", err))
-		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
-		return
-	}
 	chunk := string(ctx.QueryArgs().Peek("chunk"))
 	if chunk == "" {
-		fmt.Println(fmt.Sprintf("chunk cannot be empty"))
 		ctx.Error("chunk cannot be empty", fasthttp.StatusBadRequest)
 		return
 	}
+	if !isValidChunk(chunk) {
+		ctx.Error(fmt.Sprintf("invalid chunk %s", chunk), fasthttp.StatusBadRequest)
+		return
+	}
+	if !isForwarded(ctx) && !s.hasLocalChunk(category, chunk) {
+		s.forwardChunkRequest(ctx, category, chunk)
+		return
+	}
+	if !s.hasLocalCategory(category) {
+		// the category may have been deleted, it must not be created again by a peer
+		ctx.Error(fmt.Sprintf("%v %s", errUnknownCategory, category), fasthttp.StatusNotFound)
+		return
+	}
+	storage, err := s.getStorage(category)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
 	offset, err := ctx.QueryArgs().GetUint("offset")
 	if err != nil {
 		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
@@ -136,12 +425,17 @@ func (s *Server) handleRead(ctx *fasthttp.RequestCtx) {
 		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
 		return
 	}
-	err = storage.Read(chunk, uint64(offset), uint64(maxSize), ctx)
-	if err != nil {
+	err = storage.Read(requestContext(ctx), chunk, uint64(offset), uint64(maxSize), ctx)
+	if errors.Is(err, manager.ErrBufferTooSmall) {
+		ctx.Error(fmt.Sprintf("the message at offset %d is larger than maxSize %d", offset, maxSize), fasthttp.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
 		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
 		return
 	}
-	return
+	if producers := storage.Producers(chunk, uint64(offset), uint64(offset)+uint64(len(ctx.Response.Body()))); len(producers) > 0 {
+		ctx.Response.Header.Set(tracing.ProducersHeader, tracing.FormatProducers(producers))
+	}
 }
 
 func (s *Server) ackHandler(ctx *fasthttp.RequestCtx) {
@@ -150,45 +444,160 @@ func (s *Server) ackHandler(ctx *fasthttp.RequestCtx) {
 		ctx.Error("category cannot be empty", fasthttp.StatusBadRequest)
 		return
 	}
-	storage, err := s.getStorage(category)
-	if err != nil {
-		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
-		return
-	}
 	chunk := string(ctx.QueryArgs().Peek("chunk"))
 	if chunk == "" {
 		ctx.Error("chunk cannot be empty", fasthttp.StatusBadRequest)
 		return
 	}
+	if !isValidChunk(chunk) {
+		ctx.Error(fmt.Sprintf("invalid chunk %s", chunk), fasthttp.StatusBadRequest)
+		return
+	}
 	size, err := ctx.QueryArgs().GetUint("size")
 	if err != nil {
 		ctx.Error(fmt.Sprintf("bad `size` getParam: %v", err.Error()), fasthttp.StatusBadRequest)
 		return
 	}
+	if isClusterScope(ctx) {
+		if !isValidCategory(category) {
+			ctx.Error(fmt.Sprintf("invalid category %s", category), fasthttp.StatusBadRequest)
+			return
+		}
+		if err := s.ackCluster(ctx, category, chunk, uint64(size)); err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		}
+		return
+	}
+	if !isForwarded(ctx) && !s.hasLocalChunk(category, chunk) {
+		s.forwardChunkRequest(ctx, category, chunk)
+		return
+	}
+	if !s.hasLocalCategory(category) {
+		// the category may have been deleted, it must not be created again by a peer
+		ctx.Error(fmt.Sprintf("%v %s", errUnknownCategory, category), fasthttp.StatusNotFound)
+		return
+	}
+	storage, err := s.getStorage(category)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	// only a peer authenticated with the cluster token is trusted to say the owner acked the chunk,
+	// without authentication the copies are only acked once complete
+	ownerAcked := isForwarded(ctx) && requestPrincipal(ctx) == clusterPrincipal && ctx.QueryArgs().GetBool("ownerAcked")
+	if owner, ok := manager.ChunkOwner(chunk); ok && owner != s.instanceName && !ownerAcked {
+		// like the chunk still written into by the owner, a copy is only acked once it is complete
+		complete, err := s.replicaComplete(ctx, category, chunk)
+		if err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+			return
+		}
+		if !complete {
+			ctx.Error(fmt.Sprintf("cannot ack chunk %s as its copy is incomplete", chunk), fasthttp.StatusConflict)
+			return
+		}
+	}
 	if err := storage.Ack(chunk, uint64(size)); err != nil {
 		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
 	}
 }
 
+// replicaComplete tells whether the replicator copied the whole chunk once the owner completed it
+func (s *Server) replicaComplete(ctx *fasthttp.RequestCtx, category, fileName string) (bool, error) {
+	replicas, err := s.replicationClient.ListReplicas(ctx, category)
+	if err != nil {
+		return false, fmt.Errorf("error listing replicas %v", err)
+	}
+	for _, r := range replicas {
+		if r.FileName == fileName && r.Instance == s.instanceName {
+			return r.Complete, nil
+		}
+	}
+	return false, nil
+}
+
 func (s *Server) listChunksHandler(ctx *fasthttp.RequestCtx) {
 	category := string(ctx.QueryArgs().Peek("category"))
 	if category == "" {
 		ctx.Error("category cannot be empty", fasthttp.StatusBadRequest)
 		return
 	}
-	storage, err := s.getStorage(category)
-	if err != nil {
-		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+	if !isValidCategory(category) {
+		ctx.Error(fmt.Sprintf("invalid category %s", category), fasthttp.StatusBadRequest)
 		return
 	}
-	chunks, err := storage.ListChunks()
+	chunks, err := s.localChunks(ctx, category)
 	if err != nil {
 		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
 		return
 	}
-	err = json.NewEncoder(ctx).Encode(chunks)
-	if err != nil {
+	if len(chunks) == 0 && !isForwarded(ctx) && !isClusterScope(ctx) && s.forwardListChunks(ctx) {
+		return
+	}
+	if isClusterScope(ctx) {
+		listings, addrs, err := s.listPeerChunks(ctx, category, chunks)
+		if err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+			return
+		}
+		chunks = mergeChunkListings(listings, addrs)
+	}
+	if err := json.NewEncoder(ctx).Encode(chunks); err != nil {
 		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
 		return
 	}
 }
+
+// addReplicaInfo fills in where each chunk lives so that consumers can read it from a replica when the owner is unavailable
+func (s *Server) addReplicaInfo(ctx context.Context, category string, chunks []chunk.Chunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	addrs, err := s.peerAddrs(ctx)
+	if err != nil {
+		return err
+	}
+	replicas, err := s.replicationClient.ListReplicas(ctx, category)
+	if err != nil {
+		return fmt.Errorf("error listing replicas %v", err)
+	}
+	states := make(map[string][]replication.ReplicaState)
+	for _, r := range replicas {
+		states[r.FileName] = append(states[r.FileName], r)
+	}
+
+	for i := range chunks {
+		ch := &chunks[i]
+		owner, ok := manager.ChunkOwner(ch.Name)
+		if !ok {
+			continue
+		}
+		ch.Owner = owner
+		ch.OwnerAddr = addrs[owner]
+		if owner != s.instanceName {
+			// the local copy is a replica which is only complete once the replicator says so
+			ch.Complete = false
+		}
+		for _, st := range states[ch.Name] {
+			if st.Instance == owner {
+				continue
+			}
+			if st.Instance == s.instanceName {
+				ch.Complete = st.Complete && st.Size == ch.Size
+			}
+			ch.Replicas = append(ch.Replicas, chunk.Replica{
+				Instance: st.Instance,
+				Addr:     addrs[st.Instance],
+				Size:     st.Size,
+				Complete: st.Complete,
+			})
+		}
+	}
+	return nil
+}
+
+func (s *Server) categoryLeader(ctx context.Context, category string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, forwardTimeout)
+	defer cancel()
+	return s.leadership.Leader(ctx, category)
+}
@@ -1,6 +1,12 @@
 package web
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"github.com/valyala/fasthttp"
+	"testing"
+)
 
 func TestValidCategory(t *testing.T) {
 	testCases := []struct {
@@ -19,10 +25,18 @@ func TestValidCategory(t *testing.T) {
 			category: "..",
 			valid:    false,
 		},
+		{
+			category: ".raft",
+			valid:    false,
+		},
 		{
 			category: "number",
 			valid:    true,
 		},
+		{
+			category: "orders.eu",
+			valid:    true,
+		},
 		{
 			category: "num\nbers",
 			valid:    true,
@@ -41,3 +55,70 @@ func TestValidCategory(t *testing.T) {
 		})
 	}
 }
+
+// failingLeadership cannot give up its leaderships
+type failingLeadership struct{}
+
+func (failingLeadership) Leader(ctx context.Context, category string) (string, error) {
+	return "luffy", nil
+}
+
+func (failingLeadership) Close() error {
+	return errors.New("leadership lost")
+}
+
+func TestShutdownRunsEveryStep(t *testing.T) {
+	client := replication.NewClientWithBackend(replication.NewMemoryBackend(), "test")
+	s := NewServer(client, "luffy", t.TempDir(), "", replication.NewStorage(client, "luffy"), WithLeadership(failingLeadership{}))
+	if err := client.RegisterPeer(context.Background(), replication.Peer{Name: "luffy", Addr: "127.0.0.1:8080"}); err != nil {
+		t.Fatalf("error registering peer %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Shutdown(ctx); err == nil {
+		t.Errorf("Shutdown() did not report the leadership error")
+	}
+	peers, err := client.ListPeers(context.Background())
+	if err != nil {
+		t.Fatalf("error listing peers %v", err)
+	}
+	if len(peers) != 0 {
+		t.Errorf("got peers %v, the instance was not deregistered", peers)
+	}
+}
+
+func TestAckOwnerAckedOnlyFromCluster(t *testing.T) {
+	for _, auth := range []bool{false, true} {
+		client := replication.NewClientWithBackend(replication.NewMemoryBackend(), "test")
+		var opts []Option
+		if auth {
+			opts = append(opts, WithAuth("secret"))
+		}
+		s := NewServer(client, "luffy", t.TempDir(), "", replication.NewStorage(client, "luffy"), opts...)
+		if err := s.WriteDirect("numbers", "zoro-chunk000000000", []byte("1\n")); err != nil {
+			t.Fatalf("error writing copy %v", err)
+		}
+		ack := func(forwarded bool, want int) {
+			t.Helper()
+			var req fasthttp.RequestCtx
+			req.Request.SetRequestURI("/ack?category=numbers&chunk=zoro-chunk000000000&size=2&ownerAcked=true")
+			if auth {
+				req.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer secret")
+			}
+			if forwarded {
+				req.Request.Header.Set(replication.ForwardedHeader, "zoro")
+			}
+			s.handleRequest(&req)
+			if code := req.Response.StatusCode(); code != want {
+				t.Errorf("auth %v forwarded %v: got status %d want %d %s", auth, forwarded, code, want, req.Response.Body())
+			}
+		}
+		// the copy is incomplete, only a peer can tell that the owner acked it
+		ack(false, fasthttp.StatusConflict)
+		if auth {
+			ack(true, fasthttp.StatusOK)
+		} else {
+			ack(true, fasthttp.StatusConflict)
+		}
+	}
+}
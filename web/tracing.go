@@ -0,0 +1,64 @@
+package web
+
+import (
+	"context"
+	"github.com/Vignesh-Rajarajan/event-bus/tracing"
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type spanKey struct{}
+
+// requestHeaderCarrier lets the propagators read and write the headers of a fasthttp request
+type requestHeaderCarrier struct {
+	h *fasthttp.RequestHeader
+}
+
+func (c requestHeaderCarrier) Get(key string) string {
+	return string(c.h.Peek(key))
+}
+
+func (c requestHeaderCarrier) Set(key, value string) {
+	c.h.Set(key, value)
+}
+
+func (c requestHeaderCarrier) Keys() []string {
+	var keys []string
+	c.h.VisitAll(func(key, _ []byte) {
+		keys = append(keys, string(key))
+	})
+	return keys
+}
+
+// startSpan starts the server span of the request as a child of the trace context sent by the caller
+func (s *Server) startSpan(ctx *fasthttp.RequestCtx) trace.Span {
+	parent := tracing.Propagator.Extract(context.Background(), requestHeaderCarrier{h: &ctx.Request.Header})
+	_, span := tracing.Tracer().Start(parent, string(ctx.Path()),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.method", string(ctx.Method())),
+			attribute.String("category", string(ctx.QueryArgs().Peek("category"))),
+			attribute.String("instance", s.instanceName),
+		))
+	ctx.SetUserValue(spanKey{}, span)
+	return span
+}
+
+func endSpan(ctx *fasthttp.RequestCtx, span trace.Span) {
+	status := ctx.Response.StatusCode()
+	span.SetAttributes(attribute.Int("http.status_code", status))
+	if status >= fasthttp.StatusInternalServerError {
+		span.SetStatus(codes.Error, string(ctx.Response.Body()))
+	}
+	span.End()
+}
+
+// requestContext returns a context carrying the span of the request, to be passed to the storage
+func requestContext(ctx *fasthttp.RequestCtx) context.Context {
+	if span, ok := ctx.UserValue(spanKey{}).(trace.Span); ok {
+		return trace.ContextWithSpan(ctx, span)
+	}
+	return ctx
+}
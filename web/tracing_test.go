@@ -0,0 +1,46 @@
+package web
+
+import (
+	"context"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"github.com/Vignesh-Rajarajan/event-bus/tracing"
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel/trace"
+	"testing"
+)
+
+func TestReadReturnsProducers(t *testing.T) {
+	client := replication.NewClientWithBackend(replication.NewMemoryBackend(), "test")
+	s := NewServer(client, "luffy", t.TempDir(), "", replication.NewStorage(client, "luffy"))
+
+	producer := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{1},
+		TraceFlags: trace.FlagsSampled,
+	})
+	var write fasthttp.RequestCtx
+	write.Request.Header.SetMethod(fasthttp.MethodPost)
+	write.Request.SetRequestURI("/write?category=numbers")
+	write.Request.SetBodyString("1\n2\n")
+	tracing.Propagator.Inject(trace.ContextWithSpanContext(context.Background(), producer), requestHeaderCarrier{h: &write.Request.Header})
+	s.handleRequest(&write)
+	if code := write.Response.StatusCode(); code != fasthttp.StatusOK {
+		t.Fatalf("write: got status %d %s", code, write.Response.Body())
+	}
+
+	chunks, err := s.localChunks(context.Background(), "numbers")
+	if err != nil || len(chunks) != 1 {
+		t.Fatalf("listing chunks: got %v %v", chunks, err)
+	}
+	var read fasthttp.RequestCtx
+	read.Request.SetRequestURI("/read?category=numbers&offset=0&maxSize=1024&chunk=" + chunks[0].Name)
+	s.handleRequest(&read)
+	if code := read.Response.StatusCode(); code != fasthttp.StatusOK {
+		t.Fatalf("read: got status %d %s", code, read.Response.Body())
+	}
+
+	links := tracing.ParseProducers(string(read.Response.Header.Peek(tracing.ProducersHeader)))
+	if len(links) != 1 || links[0].SpanContext.TraceID() != producer.TraceID() {
+		t.Errorf("got producers %v want the trace %s", links, producer.TraceID())
+	}
+}
@@ -0,0 +1,46 @@
+package web
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+// ReportDiskUsage periodically publishes how many bytes the current instance stores so that
+// new copies are placed on the least used peers
+func (s *Server) ReportDiskUsage(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		usage, err := s.diskUsage()
+		if err != nil {
+			s.logger.Error("error computing disk usage", "error", err)
+		} else if err := s.replicationClient.SetDiskUsage(ctx, s.instanceName, usage); err != nil {
+			s.logger.Error("error reporting disk usage", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// diskUsage sums the size of every file in the data directory
+func (s *Server) diskUsage() (uint64, error) {
+	var total uint64
+	err := filepath.WalkDir(s.dirname, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			// the chunk might have been acked in the meantime
+			return nil
+		}
+		total += uint64(info.Size())
+		return nil
+	})
+	return total, err
+}
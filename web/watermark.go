@@ -0,0 +1,222 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"github.com/Vignesh-Rajarajan/event-bus/diskspace"
+	"github.com/Vignesh-Rajarajan/event-bus/manager"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"sync"
+	"time"
+)
+
+// defaultDiskCheckInterval is the interval between the checks of the disk usage when it is not set
+const defaultDiskCheckInterval = 10 * time.Second
+
+var (
+	diskUsedRatio = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "event_bus_disk_used_ratio",
+		Help: "Share of the file system holding the data directory which is used.",
+	})
+	diskWritesBlocked = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "event_bus_disk_writes_blocked",
+		Help: "1 while the writes are rejected because the disk usage went above the high watermark.",
+	})
+	reclaimedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "event_bus_disk_reclaimed_bytes_total",
+		Help: "Bytes of chunks processed by every consumer removed because the disk usage went above the high watermark.",
+	})
+)
+
+// WithDiskWatermarks rejects the writes once the share of the file system holding the data directory
+// which is used goes above high, until it goes back below low. Both are between 0 and 1.
+func WithDiskWatermarks(high, low float64) Option {
+	return func(s *Server) {
+		s.disk.high = high
+		s.disk.low = low
+	}
+}
+
+// diskGuard keeps track of the disk usage against the watermarks
+type diskGuard struct {
+	high     float64
+	low      float64
+	interval time.Duration
+	usage    func(dirname string) (diskspace.Usage, error)
+
+	mu      sync.Mutex
+	used    float64
+	blocked bool
+}
+
+// DiskWatermarks is the state of the watermarks reported by /status
+type DiskWatermarks struct {
+	High          float64 `json:"high"`
+	Low           float64 `json:"low"`
+	Used          float64 `json:"used"`
+	WritesBlocked bool    `json:"writesBlocked"`
+}
+
+func (g *diskGuard) enabled() bool {
+	return g.high > 0
+}
+
+func (g *diskGuard) state() DiskWatermarks {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return DiskWatermarks{High: g.high, Low: g.low, Used: g.used, WritesBlocked: g.blocked}
+}
+
+// update records the usage and reports whether the writes are blocked and whether that changed
+func (g *diskGuard) update(used float64) (blocked, changed bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.used = used
+	switch {
+	case !g.blocked && used >= g.high:
+		g.blocked, changed = true, true
+	case g.blocked && used <= g.low:
+		g.blocked, changed = false, true
+	}
+	return g.blocked, changed
+}
+
+// writeError returns how long to wait before retrying and the reason the writes are rejected,
+// the error is nil while the writes are accepted
+func (g *diskGuard) writeError(dirname string) (time.Duration, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.blocked {
+		return 0, nil
+	}
+	return g.interval, fmt.Errorf("disk holding %s is %.1f%% used, above the high watermark of %.1f%%, writes resume below %.1f%%",
+		dirname, g.used*100, g.high*100, g.low*100)
+}
+
+// WatchDiskSpace periodically compares the disk usage with the watermarks. Above the high watermark
+// the writes are rejected and the chunks every consumer has processed are removed without waiting
+// for the consumers to ack them.
+func (s *Server) WatchDiskSpace(ctx context.Context, interval time.Duration) {
+	if !s.disk.enabled() {
+		return
+	}
+	s.disk.mu.Lock()
+	s.disk.interval = interval
+	s.disk.mu.Unlock()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.checkDiskSpace(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) checkDiskSpace(ctx context.Context) {
+	usage, err := s.disk.usage(s.dirname)
+	if err != nil {
+		s.logger.Error("error getting disk space", "error", err)
+		return
+	}
+	used := usage.UsedRatio()
+	diskUsedRatio.Set(used)
+	blocked, changed := s.disk.update(used)
+	switch {
+	case blocked && changed:
+		diskWritesBlocked.Set(1)
+		s.logger.Warn("disk usage above the high watermark, rejecting writes", "used", used, "high", s.disk.high)
+	case !blocked && changed:
+		diskWritesBlocked.Set(0)
+		s.logger.Info("disk usage below the low watermark, accepting writes", "used", used, "low", s.disk.low)
+	}
+	if blocked {
+		s.reclaimProcessedChunks(ctx)
+	}
+}
+
+// reclaimProcessedChunks removes the local chunks which precede the committed position of every
+// consumer of their category. Only the chunks of an instance are consumed in the order of their
+// index, so a chunk is removed when every consumer has committed a position in a later chunk of
+// the same instance. Chunks of instances some consumer has no position for are left alone since
+// nothing tells whether they have been processed.
+func (s *Server) reclaimProcessedChunks(ctx context.Context) {
+	categories, err := s.localCategories()
+	if err != nil {
+		s.logger.Error("error listing categories", "error", err)
+		return
+	}
+	for _, category := range categories {
+		offsets, err := s.replicationClient.ListConsumerOffsets(ctx, category)
+		if err != nil {
+			s.logger.Error("error listing consumer offsets", "category", category, "error", err)
+			continue
+		}
+		processed := processedChunks(offsets)
+		if len(processed) == 0 {
+			continue
+		}
+		storage, err := s.getStorage(category)
+		if err != nil {
+			s.logger.Error("error getting storage", "category", category, "error", err)
+			continue
+		}
+		chunks, err := storage.ListChunks()
+		if err != nil {
+			s.logger.Error("error listing chunks", "category", category, "error", err)
+			continue
+		}
+		for _, ch := range chunks {
+			owner, idx, ok := manager.ParseChunkName(ch.Name)
+			if !ch.Complete || !ok {
+				continue
+			}
+			if lowest, ok := processed[owner]; !ok || idx >= lowest {
+				continue
+			}
+			if err := storage.Ack(ch.Name, ch.Size); err != nil {
+				s.logger.Error("error removing processed chunk", "category", category, "chunk", ch.Name, "error", err)
+				continue
+			}
+			reclaimedBytes.Add(float64(ch.Size))
+			s.logger.Info("removed processed chunk to free disk space", "category", category, "chunk", ch.Name, "size", ch.Size)
+		}
+	}
+}
+
+// processedChunks returns, by instance, the lowest index of the chunks of that instance the
+// consumers have committed a position in. Instances are left out unless every consumer has a
+// position in one of their chunks.
+func processedChunks(offsets []replication.ConsumerOffset) map[string]uint64 {
+	consumers := make(map[string]bool, len(offsets))
+	positions := make(map[string]map[string]uint64)
+	for _, o := range offsets {
+		consumers[o.Consumer] = true
+		owner, idx, ok := manager.ParseChunkName(o.Chunk)
+		if !ok {
+			continue
+		}
+		if positions[owner] == nil {
+			positions[owner] = make(map[string]uint64)
+		}
+		if lowest, ok := positions[owner][o.Consumer]; !ok || idx < lowest {
+			positions[owner][o.Consumer] = idx
+		}
+	}
+	processed := make(map[string]uint64, len(positions))
+	for owner, byConsumer := range positions {
+		if len(byConsumer) < len(consumers) {
+			continue
+		}
+		for _, idx := range byConsumer {
+			if lowest, ok := processed[owner]; !ok || idx < lowest {
+				processed[owner] = idx
+			}
+		}
+	}
+	return processed
+}
@@ -0,0 +1,139 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"github.com/Vignesh-Rajarajan/event-bus/diskspace"
+	"github.com/Vignesh-Rajarajan/event-bus/replication"
+	"github.com/valyala/fasthttp"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiskWatermarks(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	client := replication.NewClientWithBackend(replication.NewMemoryBackend(), "test")
+	s := NewServer(client, "luffy", dir, "", replication.NewStorage(client, "luffy"), WithDiskWatermarks(0.9, 0.8))
+	var free uint64
+	s.disk.usage = func(string) (diskspace.Usage, error) {
+		return diskspace.Usage{Total: 100, Free: free}, nil
+	}
+
+	write := func(want int) {
+		t.Helper()
+		var req fasthttp.RequestCtx
+		req.Request.Header.SetMethod(fasthttp.MethodPost)
+		req.Request.SetRequestURI("/write?category=numbers")
+		req.Request.SetBodyString("1\n")
+		s.handleRequest(&req)
+		if code := req.Response.StatusCode(); code != want {
+			t.Fatalf("got status %d want %d %s", code, want, req.Response.Body())
+		}
+		if want == fasthttp.StatusServiceUnavailable && len(req.Response.Header.Peek(fasthttp.HeaderRetryAfter)) == 0 {
+			t.Errorf("rejected write without Retry-After")
+		}
+	}
+
+	free = 50
+	s.checkDiskSpace(ctx)
+	storage, err := s.getStorage("numbers")
+	if err != nil {
+		t.Fatalf("error getting storage %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		write(fasthttp.StatusOK)
+		storage.Seal()
+	}
+	for consumer, chunk := range map[string]string{"a": "luffy-chunk000000002", "b": "luffy-chunk000000001"} {
+		if err := client.SetConsumerOffset(ctx, "numbers", replication.ConsumerOffset{Consumer: consumer, Chunk: chunk}); err != nil {
+			t.Fatalf("error committing offset %v", err)
+		}
+	}
+
+	free = 5
+	s.checkDiskSpace(ctx)
+	write(fasthttp.StatusServiceUnavailable)
+	if _, err := os.Stat(filepath.Join(dir, "numbers", "luffy-chunk000000000")); !os.IsNotExist(err) {
+		t.Errorf("chunk processed by every consumer was not removed %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "numbers", "luffy-chunk000000001")); err != nil {
+		t.Errorf("chunk not processed by consumer b was removed %v", err)
+	}
+	if state := s.disk.state(); !state.WritesBlocked || state.Used != 0.95 {
+		t.Errorf("got watermarks state %+v", state)
+	}
+
+	// writes resume below the low watermark only
+	free = 15
+	s.checkDiskSpace(ctx)
+	write(fasthttp.StatusServiceUnavailable)
+	free = 25
+	s.checkDiskSpace(ctx)
+	write(fasthttp.StatusOK)
+}
+
+func TestReclaimInterleavedOwners(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	client := replication.NewClientWithBackend(replication.NewMemoryBackend(), "test")
+	s := NewServer(client, "luffy", dir, "", replication.NewStorage(client, "luffy"), WithDiskWatermarks(0.9, 0.8))
+	s.disk.usage = func(string) (diskspace.Usage, error) {
+		return diskspace.Usage{Total: 100, Free: 5}, nil
+	}
+	storage, err := s.getStorage("numbers")
+	if err != nil {
+		t.Fatalf("error getting storage %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := storage.Write(ctx, []byte("1\n")); err != nil {
+			t.Fatalf("error writing %v", err)
+		}
+		storage.Seal()
+		if err := storage.WriteDirect(fmt.Sprintf("zoro-chunk%09d", i), []byte("1\n")); err != nil {
+			t.Fatalf("error writing copy %v", err)
+		}
+	}
+	// the names of the chunks of zoro sort after the ones of luffy, which are not processed
+	for consumer, chunk := range map[string]string{"a": "zoro-chunk000000002", "b": "zoro-chunk000000001"} {
+		if err := client.SetConsumerOffset(ctx, "numbers", replication.ConsumerOffset{Consumer: consumer, Chunk: chunk}); err != nil {
+			t.Fatalf("error committing offset %v", err)
+		}
+	}
+
+	s.checkDiskSpace(ctx)
+	for i := 0; i < 3; i++ {
+		for _, owner := range []string{"luffy", "zoro"} {
+			name := fmt.Sprintf("%s-chunk%09d", owner, i)
+			_, err := os.Stat(filepath.Join(dir, "numbers", name))
+			if removed := os.IsNotExist(err); removed != (owner == "zoro" && i == 0) {
+				t.Errorf("chunk %s removed %v, error %v", name, removed, err)
+			}
+		}
+	}
+}
+
+func TestProcessedChunks(t *testing.T) {
+	offset := func(consumer, chunk string) replication.ConsumerOffset {
+		return replication.ConsumerOffset{Consumer: consumer, Chunk: chunk}
+	}
+	tests := []struct {
+		name    string
+		offsets []replication.ConsumerOffset
+		want    map[string]uint64
+	}{
+		{"no consumers", nil, map[string]uint64{}},
+		{"same owner", []replication.ConsumerOffset{offset("a", "luffy-chunk000000002"), offset("b", "luffy-chunk000000001")}, map[string]uint64{"luffy": 1}},
+		{"consumers on different owners", []replication.ConsumerOffset{offset("a", "luffy-chunk000000002"), offset("b", "zoro-chunk000000001")}, map[string]uint64{}},
+		{"invalid chunk", []replication.ConsumerOffset{offset("a", "luffy-chunk000000002"), offset("b", "numbers")}, map[string]uint64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := processedChunks(tt.offsets); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("processedChunks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}